@@ -0,0 +1,163 @@
+// api/admin.go
+package api
+
+import (
+    "net/http"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+type damagedBlobResponse struct {
+    BlobID         string                    `json:"blob_id"`
+    Hash           string                    `json:"hash,omitempty"`
+    Size           int64                     `json:"size"`
+    Status         string                    `json:"status"`
+    LastVerifiedAt time.Time                 `json:"last_verified_at"`
+    Versions       []affectedVersionResponse `json:"versions"`
+}
+
+// affectedVersionResponse identifies a version whose content is a damaged
+// blob.
+type affectedVersionResponse struct {
+    Bucket    string `json:"bucket"`
+    Path      string `json:"path"`
+    VersionID string `json:"version_id"`
+}
+
+type scrubReportResponse struct {
+    Blobs   map[string]int        `json:"blobs"`
+    Damaged []damagedBlobResponse `json:"damaged"`
+}
+
+type scrubResultResponse struct {
+    Verified int      `json:"verified"`
+    Bytes    int64    `json:"bytes"`
+    Corrupt  []string `json:"corrupt"`
+    Missing  []string `json:"missing"`
+}
+
+// handleScrub serves /admin/scrub. GET reports the blobs the scrubber has
+// found damaged and the versions affected; POST runs a scrub pass over the
+// blobs that are due for verification.
+func (h *Handler) handleScrub(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        h.getScrubReport(w, r)
+    case http.MethodPost:
+        h.runScrub(w, r)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func (h *Handler) getScrubReport(w http.ResponseWriter, r *http.Request) {
+    counts, err := h.store.MetadataStore.CountBlobsByStatus(r.Context())
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    blobs, err := h.store.MetadataStore.ListDamagedBlobs(r.Context())
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := scrubReportResponse{Blobs: counts, Damaged: make([]damagedBlobResponse, 0, len(blobs))}
+    for _, blob := range blobs {
+        versions, err := h.store.MetadataStore.ListVersionsByBlob(r.Context(), blob.ID)
+        if err != nil {
+            writeError(w, r, err, statusForError(err))
+            return
+        }
+        damaged := damagedBlobResponse{
+            BlobID:         blob.ID,
+            Hash:           blob.Hash,
+            Size:           blob.Size,
+            Status:         blob.Status,
+            LastVerifiedAt: blob.LastVerifiedAt,
+            Versions:       make([]affectedVersionResponse, 0, len(versions)),
+        }
+        for _, v := range versions {
+            damaged.Versions = append(damaged.Versions, affectedVersionResponse{
+                Bucket:    v.Bucket,
+                Path:      v.ObjectPath,
+                VersionID: v.VersionID,
+            })
+        }
+        resp.Damaged = append(resp.Damaged, damaged)
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) runScrub(w http.ResponseWriter, r *http.Request) {
+    result, err := h.store.ScrubBlobs(r.Context())
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, newScrubResultResponse(result))
+}
+
+func newScrubResultResponse(result *store.ScrubResult) scrubResultResponse {
+    resp := scrubResultResponse{
+        Verified: result.Verified,
+        Bytes:    result.Bytes,
+        Corrupt:  result.Corrupt,
+        Missing:  result.Missing,
+    }
+    if resp.Corrupt == nil {
+        resp.Corrupt = []string{}
+    }
+    if resp.Missing == nil {
+        resp.Missing = []string{}
+    }
+    return resp
+}
+
+type lifecycleActionResponse struct {
+    Kind      string `json:"kind"`
+    Bucket    string `json:"bucket"`
+    Path      string `json:"path"`
+    VersionID string `json:"version_id,omitempty"`
+    UploadID  string `json:"upload_id,omitempty"`
+    Rule      string `json:"rule,omitempty"`
+}
+
+type lifecycleReportResponse struct {
+    DryRun  bool                      `json:"dry_run"`
+    Actions []lifecycleActionResponse `json:"actions"`
+}
+
+// handleLifecycle serves /admin/lifecycle. GET reports what the buckets'
+// lifecycle rules would remove now, without removing it; POST runs a
+// lifecycle pass.
+func (h *Handler) handleLifecycle(w http.ResponseWriter, r *http.Request) {
+    var opts store.LifecycleOptions
+    switch r.Method {
+    case http.MethodGet:
+        opts.DryRun = true
+    case http.MethodPost:
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    report, err := h.store.ApplyLifecycle(r.Context(), opts)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    resp := lifecycleReportResponse{DryRun: report.DryRun, Actions: make([]lifecycleActionResponse, 0, len(report.Actions))}
+    for _, action := range report.Actions {
+        resp.Actions = append(resp.Actions, lifecycleActionResponse{
+            Kind:      action.Kind,
+            Bucket:    action.Bucket,
+            Path:      action.ObjectPath,
+            VersionID: action.VersionID,
+            UploadID:  action.UploadID,
+            Rule:      action.Rule,
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,114 @@
+// api/admin_test.go
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "strings"
+    "testing"
+)
+
+func TestScrubRoutes(t *testing.T) {
+    ctx := t.Context()
+    server, s := setupTestServer(t)
+    defer server.Close()
+
+    resp, err := http.Post(server.URL+"/objects?path=scrubbed.txt", "text/plain", strings.NewReader("intact"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    metadata, err := s.StatObject(ctx, "scrubbed.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(metadata.LocalPath, []byte("broken"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    resp, err = http.Post(server.URL+"/admin/scrub", "", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var result scrubResultResponse
+    json.NewDecoder(resp.Body).Decode(&result)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    if result.Verified != 1 || len(result.Corrupt) != 1 || result.Corrupt[0] != metadata.BlobID {
+        t.Errorf("Unexpected scrub result: %+v", result)
+    }
+
+    resp, err = http.Get(server.URL + "/admin/scrub")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var report scrubReportResponse
+    json.NewDecoder(resp.Body).Decode(&report)
+    resp.Body.Close()
+    if report.Blobs["corrupt"] != 1 || len(report.Damaged) != 1 {
+        t.Fatalf("Unexpected scrub report: %+v", report)
+    }
+    damaged := report.Damaged[0]
+    if damaged.Status != "corrupt" || len(damaged.Versions) != 1 || damaged.Versions[0].Path != "scrubbed.txt" || damaged.Versions[0].VersionID != metadata.VersionID {
+        t.Errorf("Unexpected damaged blob: %+v", damaged)
+    }
+
+    resp, err = http.Get(server.URL + "/objects/scrubbed.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode == http.StatusOK {
+        t.Error("Expected reading a corrupt object to fail")
+    }
+}
+func TestLifecycleRoutes(t *testing.T) {
+    ctx := t.Context()
+    server, s := setupTestServer(t)
+    defer server.Close()
+
+    put := func(body string) (int, bucketResponse) {
+        req, _ := http.NewRequest(http.MethodPut, server.URL+"/buckets/default", strings.NewReader(body))
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        var bucket bucketResponse
+        json.NewDecoder(resp.Body).Decode(&bucket)
+        return resp.StatusCode, bucket
+    }
+
+    status, bucket := put(`{"lifecycle":[{"id":"tmp","prefix":"tmp/","expire_days":1}]}`)
+    if status != http.StatusOK || len(bucket.Lifecycle) != 1 || bucket.Lifecycle[0].ExpireDays != 1 {
+        t.Fatalf("Expected the lifecycle rules to be set, got %d: %+v", status, bucket)
+    }
+    if status, _ := put(`{"lifecycle":[{"prefix":"tmp/"}]}`); status != http.StatusBadRequest {
+        t.Errorf("Expected a rule without an action to get %d, got %d", http.StatusBadRequest, status)
+    }
+    if stored, _ := s.GetBucket(ctx, "default"); len(stored.Lifecycle) != 1 {
+        t.Errorf("Expected the invalid rules not to be stored, got %+v", stored.Lifecycle)
+    }
+
+    for _, method := range []string{http.MethodGet, http.MethodPost} {
+        req, _ := http.NewRequest(method, server.URL+"/admin/lifecycle", nil)
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var report lifecycleReportResponse
+        json.NewDecoder(resp.Body).Decode(&report)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK || report.DryRun != (method == http.MethodGet) || report.Actions == nil {
+            t.Errorf("%s: unexpected lifecycle report %d: %+v", method, resp.StatusCode, report)
+        }
+    }
+
+    if status, bucket := put(`{"lifecycle":[]}`); status != http.StatusOK || len(bucket.Lifecycle) != 0 {
+        t.Errorf("Expected the lifecycle rules to be removed, got %d: %+v", status, bucket)
+    }
+}
@@ -0,0 +1,337 @@
+// api/auth.go
+package api
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "net/http"
+    "net/url"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// Requests are signed as AWS Signature Version 4 defines, so that S3 clients
+// can sign them with an API key as they would with AWS credentials, and
+// clients of the /objects API can use any SigV4 signer. The region and
+// service of the credential scope are not checked.
+const (
+    signingAlgorithm    = "AWS4-HMAC-SHA256"
+    amzDateHeader       = "X-Amz-Date"
+    contentSHA256Header = "X-Amz-Content-Sha256"
+    amzDateFormat       = "20060102T150405Z"
+    // unsignedPayload is signed in place of the payload's hash by clients
+    // that do not hash it. It is assumed when the request does not say.
+    unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// errContentSHA256Mismatch is returned while reading the body of a request
+// whose content does not match the hash it was signed with.
+var errContentSHA256Mismatch = errors.New("the content does not match its signed SHA-256")
+
+// authError is a request authentication failure, with the S3 error code
+// describing it.
+type authError struct {
+    status  int
+    code    string
+    message string
+}
+
+func (e *authError) Error() string {
+    return e.message
+}
+
+func accessDenied(code, format string, args ...any) *authError {
+    return &authError{status: http.StatusForbidden, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// authenticator checks that requests are signed with an enabled API key.
+type authenticator struct {
+    store  *store.Store
+    window time.Duration
+    // publicMetrics lets scrapers fetch /metrics without a signature.
+    publicMetrics bool
+    now           func() time.Time
+}
+
+// authenticate wraps next so that it only serves signed requests, and
+// requests to /admin only when signed with an admin key. The key a request
+// was signed with is available to next through requestKey. Requests to
+// presigned object URLs go through without a key, as the handler verifies
+// their signature. So do requests for metrics when they are public.
+func (a *authenticator) authenticate(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if _, _, ok := presignedObject(r); ok && isPresigned(r) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if a.publicMetrics && r.URL.Path == metricsPath {
+            next.ServeHTTP(w, r)
+            return
+        }
+        key, err := a.verify(r)
+        if err == nil && strings.HasPrefix(r.URL.Path, adminPrefix) && !key.Admin {
+            err = accessDenied("AccessDenied", "API key %s is not an admin key", key.AccessKeyID)
+        }
+        if err != nil {
+            writeAuthError(w, r, err)
+            return
+        }
+        setPrincipal(r, key.AccessKeyID)
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+    })
+}
+
+type apiKeyContextKey struct{}
+
+// requestKey returns the API key r was signed with, or nil if requests are
+// not authenticated.
+func requestKey(r *http.Request) *store.APIKey {
+    key, _ := r.Context().Value(apiKeyContextKey{}).(*store.APIKey)
+    return key
+}
+
+// verify checks the signature of r and returns the key it was made with.
+func (a *authenticator) verify(r *http.Request) (*store.APIKey, *authError) {
+    auth, err := parseAuthorization(r.Header.Get("Authorization"))
+    if err != nil {
+        return nil, err
+    }
+
+    signedAt, parseErr := time.Parse(amzDateFormat, r.Header.Get(amzDateHeader))
+    if parseErr != nil {
+        return nil, accessDenied("AccessDenied", "the request must be dated with %s", amzDateHeader)
+    }
+    if skew := a.now().Sub(signedAt); skew > a.window || skew < -a.window {
+        return nil, accessDenied("RequestTimeTooSkewed", "the request time is too far from the server time")
+    }
+    if auth.date != signedAt.Format("20060102") {
+        return nil, accessDenied("AuthorizationHeaderMalformed", "the credential date does not match %s", amzDateHeader)
+    }
+    for _, name := range []string{"host", strings.ToLower(amzDateHeader)} {
+        if !slices.Contains(auth.signedHeaders, name) {
+            return nil, accessDenied("AccessDenied", "the %s header must be signed", name)
+        }
+    }
+
+    key, getErr := a.store.GetAPIKey(r.Context(), auth.accessKeyID)
+    if errors.Is(getErr, store.ErrAPIKeyNotFound) || (getErr == nil && key.Disabled) {
+        return nil, accessDenied("InvalidAccessKeyId", "the access key ID %s is not valid", auth.accessKeyID)
+    }
+    if getErr != nil {
+        return nil, &authError{status: http.StatusInternalServerError, code: "InternalError", message: getErr.Error()}
+    }
+
+    payloadHash := r.Header.Get(contentSHA256Header)
+    if payloadHash == "" {
+        payloadHash = unsignedPayload
+    }
+    stringToSign := signingString(r, signedAt, auth.scope, auth.signedHeaders, payloadHash)
+    if !matchSignature(key.Secrets(a.now()), auth.date, auth.scope, stringToSign, auth.signature) {
+        return nil, accessDenied("SignatureDoesNotMatch", "the request signature does not match")
+    }
+
+    // The signature covers the content only through its hash, which is
+    // checked as the content is read.
+    if sum, err := hex.DecodeString(payloadHash); err == nil && len(sum) == sha256.Size {
+        r.Body = &verifiedBody{r: r.Body, hash: sha256.New(), sum: sum}
+    }
+    return key, nil
+}
+
+// authorization holds the parts of a SigV4 Authorization header.
+type authorization struct {
+    accessKeyID string
+    // date is the day of the credential scope, and scope the whole scope.
+    date          string
+    scope         string
+    signedHeaders []string
+    signature     string
+}
+
+func parseAuthorization(header string) (*authorization, *authError) {
+    if header == "" {
+        return nil, accessDenied("AccessDenied", "the request is not signed")
+    }
+    params, ok := strings.CutPrefix(header, signingAlgorithm+" ")
+    if !ok {
+        return nil, accessDenied("AuthorizationHeaderMalformed", "the request must be signed with %s", signingAlgorithm)
+    }
+
+    auth := &authorization{}
+    for _, param := range strings.Split(params, ",") {
+        name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+        switch name {
+        case "Credential":
+            accessKeyID, scope, _ := strings.Cut(value, "/")
+            auth.accessKeyID, auth.scope = accessKeyID, scope
+            auth.date, _, _ = strings.Cut(scope, "/")
+        case "SignedHeaders":
+            auth.signedHeaders = strings.Split(value, ";")
+        case "Signature":
+            auth.signature = value
+        }
+    }
+    if auth.accessKeyID == "" || strings.Count(auth.scope, "/") != 3 || auth.signedHeaders == nil || auth.signature == "" {
+        return nil, accessDenied("AuthorizationHeaderMalformed", "the Authorization header is malformed")
+    }
+    return auth, nil
+}
+
+// signingString returns the string a SigV4 signature of r signs.
+func signingString(r *http.Request, signedAt time.Time, scope string, signedHeaders []string, payloadHash string) string {
+    canonical := strings.Join([]string{
+        r.Method,
+        canonicalURI(r.URL.Path),
+        canonicalQuery(r.URL.Query()),
+        canonicalHeaders(r, signedHeaders),
+        strings.Join(signedHeaders, ";"),
+        payloadHash,
+    }, "\n")
+    sum := sha256.Sum256([]byte(canonical))
+    return strings.Join([]string{signingAlgorithm, signedAt.Format(amzDateFormat), scope, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// matchSignature reports whether signature was made with one of secrets.
+func matchSignature(secrets []string, date, scope, stringToSign, signature string) bool {
+    want, err := hex.DecodeString(signature)
+    if err != nil {
+        return false
+    }
+    _, rest, _ := strings.Cut(scope, "/")
+    region, service, _ := strings.Cut(rest, "/")
+    service, _, _ = strings.Cut(service, "/")
+
+    for _, secret := range secrets {
+        key := hmacSHA256([]byte("AWS4"+secret), date)
+        for _, part := range []string{region, service, "aws4_request"} {
+            key = hmacSHA256(key, part)
+        }
+        if hmac.Equal(hmacSHA256(key, stringToSign), want) {
+            return true
+        }
+    }
+    return false
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+func canonicalURI(path string) string {
+    if path == "" {
+        return "/"
+    }
+    return uriEncode(path, false)
+}
+
+// canonicalQuery encodes query sorted by name and then value. A presigned
+// request's signature is not part of what it signs.
+func canonicalQuery(query url.Values) string {
+    var pairs [][2]string
+    for name, values := range query {
+        if name == "X-Amz-Signature" {
+            continue
+        }
+        for _, value := range values {
+            pairs = append(pairs, [2]string{uriEncode(name, true), uriEncode(value, true)})
+        }
+    }
+    slices.SortFunc(pairs, func(a, b [2]string) int {
+        return strings.Compare(a[0]+"\x00"+a[1], b[0]+"\x00"+b[1])
+    })
+
+    encoded := make([]string, len(pairs))
+    for i, pair := range pairs {
+        encoded[i] = pair[0] + "=" + pair[1]
+    }
+    return strings.Join(encoded, "&")
+}
+
+// canonicalHeaders lists the signed headers of r, each followed by a
+// newline, with their values trimmed and runs of spaces collapsed.
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+    var b strings.Builder
+    for _, name := range signedHeaders {
+        var values []string
+        switch {
+        case name == "host":
+            values = []string{r.Host}
+        case name == "content-length" && r.Header.Get(name) == "":
+            // The server drops Content-Length from the headers of some
+            // requests.
+            values = []string{strconv.FormatInt(r.ContentLength, 10)}
+        default:
+            values = slices.Clone(r.Header.Values(name))
+        }
+        for i, value := range values {
+            values[i] = strings.Join(strings.Fields(value), " ")
+        }
+        b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+    }
+    return b.String()
+}
+
+// uriEncode percent-encodes every byte of s other than unreserved
+// characters, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+    const hexDigits = "0123456789ABCDEF"
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        switch {
+        case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+            b.WriteByte(c)
+        case c == '/' && !encodeSlash:
+            b.WriteByte(c)
+        default:
+            b.WriteByte('%')
+            b.WriteByte(hexDigits[c>>4])
+            b.WriteByte(hexDigits[c&15])
+        }
+    }
+    return b.String()
+}
+
+// verifiedBody fails the read that reaches the end of a request body if the
+// body does not match the SHA-256 it was signed with.
+type verifiedBody struct {
+    r    io.ReadCloser
+    hash hash.Hash
+    sum  []byte
+}
+
+func (b *verifiedBody) Read(p []byte) (int, error) {
+    n, err := b.r.Read(p)
+    b.hash.Write(p[:n])
+    if err == io.EOF && !hmac.Equal(b.hash.Sum(nil), b.sum) {
+        return n, errContentSHA256Mismatch
+    }
+    return n, err
+}
+
+func (b *verifiedBody) Close() error {
+    return b.r.Close()
+}
+
+// writeAuthError responds to a request that failed authentication, in the
+// error format of the API it was made to.
+func writeAuthError(w http.ResponseWriter, r *http.Request, err *authError) {
+    setError(r, err)
+    if strings.HasPrefix(r.URL.Path+"/", s3Prefix) {
+        writeS3Error(w, r, err.status, err.code, err.message)
+        return
+    }
+    http.Error(w, err.message, err.status)
+}
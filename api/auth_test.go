@@ -0,0 +1,226 @@
+// api/auth_test.go
+package api
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+    "github.com/aws/aws-sdk-go-v2/credentials"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/smithy-go"
+    "github.com/corylehan/object-store/store"
+)
+
+// setupAuthTestServer starts a server that authenticates requests and
+// accepts presigned URLs, and returns an admin key to sign them with.
+func setupAuthTestServer(t *testing.T) (*httptest.Server, *store.Store, *store.APIKey) {
+    ctx := t.Context()
+    tempDir := t.TempDir()
+    configFile := filepath.Join(tempDir, "config.json")
+    config := store.Config{
+        StorageDirectory: filepath.Join(tempDir, "storage"),
+        Auth:             store.AuthConfig{Enabled: true},
+        Presign:          store.PresignConfig{Secret: "presign secret"},
+    }
+    configData, _ := json.Marshal(config)
+    os.WriteFile(configFile, configData, 0644)
+
+    s, err := store.NewStore(configFile, filepath.Join(tempDir, "metadata.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { s.Close() })
+    admin, err := s.CreateAPIKey(ctx, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    server := httptest.NewServer(NewServer(0, s).Handler)
+    t.Cleanup(server.Close)
+    return server, s, admin
+}
+
+// signedRequest returns a request signed with key at signedAt. The payload
+// is signed by hash.
+func signedRequest(t *testing.T, method, url, body string, key *store.APIKey, signedAt time.Time) *http.Request {
+    req, err := http.NewRequest(method, url, strings.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    sum := sha256.Sum256([]byte(body))
+    payloadHash := hex.EncodeToString(sum[:])
+    req.Header.Set(contentSHA256Header, payloadHash)
+
+    creds := aws.Credentials{AccessKeyID: key.AccessKeyID, SecretAccessKey: key.Secret}
+    if err := v4.NewSigner().SignHTTP(context.Background(), creds, req, payloadHash, "s3", "us-east-1", signedAt); err != nil {
+        t.Fatal(err)
+    }
+    return req
+}
+
+func doRequest(t *testing.T, req *http.Request) (int, string) {
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    return resp.StatusCode, string(body)
+}
+
+func TestAuthentication(t *testing.T) {
+    ctx := t.Context()
+    server, s, admin := setupAuthTestServer(t)
+    now := time.Now()
+
+    resp, err := http.Get(server.URL + "/objects")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusForbidden {
+        t.Errorf("Expected unsigned request to get %d, got %d", http.StatusForbidden, resp.StatusCode)
+    }
+
+    status, body := doRequest(t, signedRequest(t, http.MethodPost, server.URL+"/objects?path=a.txt", "signed", admin, now))
+    if status != http.StatusCreated {
+        t.Fatalf("Expected signed request to get %d, got %d: %s", http.StatusCreated, status, body)
+    }
+    status, body = doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/objects/a.txt", "", admin, now))
+    if status != http.StatusOK || body != "signed" {
+        t.Errorf("Expected signed read to succeed, got %d: %s", status, body)
+    }
+
+    // A tampered query, a wrong secret or a stale timestamp fail.
+    req := signedRequest(t, http.MethodGet, server.URL+"/objects?prefix=a", "", admin, now)
+    req.URL.RawQuery = "prefix=b"
+    if status, _ := doRequest(t, req); status != http.StatusForbidden {
+        t.Errorf("Expected tampered request to get %d, got %d", http.StatusForbidden, status)
+    }
+    forged := *admin
+    forged.Secret = "wrong"
+    if status, _ := doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/objects", "", &forged, now)); status != http.StatusForbidden {
+        t.Errorf("Expected wrong secret to get %d, got %d", http.StatusForbidden, status)
+    }
+    status, body = doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/objects", "", admin, now.Add(-time.Hour)))
+    if status != http.StatusForbidden || !strings.Contains(body, "too far") {
+        t.Errorf("Expected replayed request to be refused, got %d: %s", status, body)
+    }
+
+    // The content must match the hash it was signed with.
+    req = signedRequest(t, http.MethodPost, server.URL+"/objects?path=b.txt", "original", admin, now)
+    req.Body = io.NopCloser(strings.NewReader("swapped!"))
+    if status, _ := doRequest(t, req); status != http.StatusBadRequest {
+        t.Errorf("Expected swapped content to get %d, got %d", http.StatusBadRequest, status)
+    }
+
+    // Only admin keys reach the admin endpoints.
+    status, body = doRequest(t, signedRequest(t, http.MethodPost, server.URL+"/admin/keys", `{"admin":false}`, admin, now))
+    if status != http.StatusCreated {
+        t.Fatalf("Expected key creation to get %d, got %d: %s", http.StatusCreated, status, body)
+    }
+    var created apiKeyResponse
+    json.Unmarshal([]byte(body), &created)
+    user := &store.APIKey{AccessKeyID: created.AccessKeyID, Secret: created.Secret}
+    listing := &store.Policy{Statements: []store.Statement{{Effect: store.EffectAllow, Actions: []string{store.ActionList}, Paths: []string{"**"}}}}
+    if err := s.PutPolicy(ctx, store.PolicyKey, user.AccessKeyID, listing); err != nil {
+        t.Fatal(err)
+    }
+    if status, _ := doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/admin/keys", "", user, now)); status != http.StatusForbidden {
+        t.Errorf("Expected non-admin key to get %d, got %d", http.StatusForbidden, status)
+    }
+    if status, _ := doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/objects", "", user, now)); status != http.StatusOK {
+        t.Errorf("Expected non-admin key to list objects, got %d", status)
+    }
+
+    status, body = doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/admin/keys", "", admin, now))
+    if status != http.StatusOK || strings.Contains(body, admin.Secret) || strings.Count(body, "access_key_id") != 2 {
+        t.Errorf("Expected a listing of two keys without secrets, got %d: %s", status, body)
+    }
+
+    // After a rotation with a grace period both secrets work.
+    keyURL := server.URL + "/admin/keys/" + user.AccessKeyID
+    status, body = doRequest(t, signedRequest(t, http.MethodPost, keyURL+"/rotate", `{"grace_seconds":60}`, admin, now))
+    if status != http.StatusOK {
+        t.Fatalf("Expected rotation to get %d, got %d: %s", http.StatusOK, status, body)
+    }
+    var rotated apiKeyResponse
+    json.Unmarshal([]byte(body), &rotated)
+    if rotated.Secret == user.Secret || rotated.PreviousSecretExpiresAt == nil {
+        t.Errorf("Expected a new secret with a grace period, got %+v", rotated)
+    }
+    renewed := &store.APIKey{AccessKeyID: user.AccessKeyID, Secret: rotated.Secret}
+    for _, key := range []*store.APIKey{user, renewed} {
+        if status, _ := doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/objects", "", key, now)); status != http.StatusOK {
+            t.Errorf("Expected both secrets to be valid during the grace period, got %d", status)
+        }
+    }
+
+    // A disabled key is refused.
+    status, _ = doRequest(t, signedRequest(t, http.MethodPut, keyURL, `{"disabled":true}`, admin, now))
+    if status != http.StatusOK {
+        t.Fatalf("Expected disabling to get %d, got %d", http.StatusOK, status)
+    }
+    status, body = doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/objects", "", renewed, now))
+    if status != http.StatusForbidden || !strings.Contains(body, "not valid") {
+        t.Errorf("Expected disabled key to be refused, got %d: %s", status, body)
+    }
+    if key, _ := s.GetAPIKey(ctx, user.AccessKeyID); !key.Disabled {
+        t.Error("Expected the key to be disabled")
+    }
+}
+
+func TestS3Authentication(t *testing.T) {
+    server, _, admin := setupAuthTestServer(t)
+    ctx := context.Background()
+
+    newClient := func(accessKeyID, secret string) *s3.Client {
+        return s3.New(s3.Options{
+            BaseEndpoint: aws.String(server.URL + strings.TrimSuffix(s3Prefix, "/")),
+            Region:       "us-east-1",
+            UsePathStyle: true,
+            Credentials:  credentials.NewStaticCredentialsProvider(accessKeyID, secret, ""),
+        })
+    }
+
+    client := newClient(admin.AccessKeyID, admin.Secret)
+    _, err := client.PutObject(ctx, &s3.PutObjectInput{
+        Bucket: aws.String("default"),
+        Key:    aws.String("dir/signed file.txt"),
+        Body:   strings.NewReader("signed by the SDK"),
+    })
+    if err != nil {
+        t.Fatalf("PutObject failed: %v", err)
+    }
+    out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("default"), Key: aws.String("dir/signed file.txt")})
+    if err != nil {
+        t.Fatalf("GetObject failed: %v", err)
+    }
+    data, _ := io.ReadAll(out.Body)
+    out.Body.Close()
+    if string(data) != "signed by the SDK" {
+        t.Errorf("Unexpected content %q", data)
+    }
+    if _, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("default"), Prefix: aws.String("dir/")}); err != nil {
+        t.Errorf("ListObjectsV2 failed: %v", err)
+    }
+
+    _, err = newClient(admin.AccessKeyID, "wrong").ListBuckets(ctx, &s3.ListBucketsInput{})
+    var apiErr smithy.APIError
+    if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "SignatureDoesNotMatch" {
+        t.Errorf("Expected SignatureDoesNotMatch, got %v", err)
+    }
+}
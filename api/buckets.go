@@ -0,0 +1,195 @@
+// api/buckets.go
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+type bucketRequest struct {
+    Name                 string  `json:"name"`
+    Versioning           *bool   `json:"versioning"`
+    QuotaBytes           *int64  `json:"quota_bytes"`
+    DefaultRetentionDays *int    `json:"default_retention_days"`
+    DefaultRetentionMode *string `json:"default_retention_mode"`
+    Compression          *string `json:"compression"`
+    // Lifecycle replaces the bucket's lifecycle rules; an empty list
+    // removes them.
+    Lifecycle *[]store.LifecycleRule `json:"lifecycle"`
+}
+
+// apply copies the settings present in the request onto bucket.
+func (req *bucketRequest) apply(bucket *store.Bucket) {
+    if req.Versioning != nil {
+        bucket.Versioning = *req.Versioning
+    }
+    if req.QuotaBytes != nil {
+        bucket.QuotaBytes = *req.QuotaBytes
+    }
+    if req.DefaultRetentionDays != nil {
+        bucket.DefaultRetentionDays = *req.DefaultRetentionDays
+    }
+    if req.DefaultRetentionMode != nil {
+        bucket.DefaultRetentionMode = *req.DefaultRetentionMode
+    }
+    if req.Compression != nil {
+        bucket.Compression = *req.Compression
+    }
+    if req.Lifecycle != nil {
+        bucket.Lifecycle = *req.Lifecycle
+    }
+}
+
+type bucketResponse struct {
+    Name                 string                `json:"name"`
+    Versioning           bool                  `json:"versioning"`
+    QuotaBytes           int64                 `json:"quota_bytes"`
+    DefaultRetentionDays int                   `json:"default_retention_days"`
+    DefaultRetentionMode string                `json:"default_retention_mode,omitempty"`
+    Compression          string                `json:"compression,omitempty"`
+    Lifecycle            []store.LifecycleRule `json:"lifecycle,omitempty"`
+    UsedBytes            *int64                `json:"used_bytes,omitempty"`
+    CreatedAt            time.Time             `json:"created_at"`
+}
+
+func newBucketResponse(b *store.Bucket) bucketResponse {
+    return bucketResponse{
+        Name:                 b.Name,
+        Versioning:           b.Versioning,
+        QuotaBytes:           b.QuotaBytes,
+        DefaultRetentionDays: b.DefaultRetentionDays,
+        DefaultRetentionMode: b.DefaultRetentionMode,
+        Compression:          b.Compression,
+        Lifecycle:            b.Lifecycle,
+        CreatedAt:            b.CreatedAt,
+    }
+}
+
+func (h *Handler) handleBuckets(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        h.listBuckets(w, r)
+    case http.MethodPost:
+        h.createBucket(w, r)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// handleBucket serves /buckets/{bucket} and the object routes nested under
+// it, /buckets/{bucket}/objects and /buckets/{bucket}/objects/{path}.
+func (h *Handler) handleBucket(w http.ResponseWriter, r *http.Request) {
+    bucket, rest, nested := strings.Cut(strings.TrimPrefix(r.URL.Path, "/buckets/"), "/")
+    if !nested {
+        switch r.Method {
+        case http.MethodGet:
+            h.getBucket(w, r, bucket)
+        case http.MethodPut:
+            h.updateBucket(w, r, bucket)
+        case http.MethodDelete:
+            h.deleteBucket(w, r, bucket)
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+        return
+    }
+
+    scoped := *h
+    scoped.store = h.store.InBucket(bucket)
+    switch {
+    case rest == "objects":
+        scoped.handleObjects(w, r)
+    case strings.HasPrefix(rest, "objects/"):
+        scoped.serveObject(w, r, strings.TrimPrefix(rest, "objects/"))
+    case rest == "changes":
+        scoped.handleChanges(w, r)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func (h *Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+    buckets, err := h.store.ListBuckets(r.Context())
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := make([]bucketResponse, 0, len(buckets))
+    for _, b := range buckets {
+        resp = append(resp, newBucketResponse(b))
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) createBucket(w http.ResponseWriter, r *http.Request) {
+    var req bucketRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid bucket JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    bucket := &store.Bucket{Name: req.Name, Versioning: true}
+    req.apply(bucket)
+    if err := h.store.CreateBucket(r.Context(), bucket); err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, newBucketResponse(bucket))
+}
+
+func (h *Handler) getBucket(w http.ResponseWriter, r *http.Request, name string) {
+    bucket, err := h.store.GetBucket(r.Context(), name)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    usage, err := h.store.BucketUsage(r.Context(), name)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := newBucketResponse(bucket)
+    resp.UsedBytes = &usage
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) updateBucket(w http.ResponseWriter, r *http.Request, name string) {
+    bucket, err := h.store.GetBucket(r.Context(), name)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    var req bucketRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid bucket JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    req.apply(bucket)
+    if err := h.store.UpdateBucket(r.Context(), bucket); err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newBucketResponse(bucket))
+}
+
+func (h *Handler) deleteBucket(w http.ResponseWriter, r *http.Request, name string) {
+    if err := h.store.DeleteBucket(r.Context(), name); err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprintf(w, "Deleted bucket %s", name)
+}
@@ -0,0 +1,118 @@
+// api/buckets_test.go
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+func TestBucketRoutes(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    // Create a bucket
+    resp, err := http.Post(server.URL+"/buckets", "application/json", strings.NewReader(`{"name":"team-a","quota_bytes":100}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var created bucketResponse
+    json.NewDecoder(resp.Body).Decode(&created)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+    if !created.Versioning || created.QuotaBytes != 100 {
+        t.Errorf("Unexpected bucket settings: %+v", created)
+    }
+
+    // Duplicate bucket
+    resp, _ = http.Post(server.URL+"/buckets", "application/json", strings.NewReader(`{"name":"team-a"}`))
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusConflict {
+        t.Errorf("Expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+    }
+
+    // The same path in two buckets holds different objects
+    objectPath := url.QueryEscape("shared/report.txt")
+    http.Post(fmt.Sprintf("%s/objects?path=%s", server.URL, objectPath), "text/plain", strings.NewReader("default bucket"))
+    resp, err = http.Post(fmt.Sprintf("%s/buckets/team-a/objects?path=%s", server.URL, objectPath), "text/plain", strings.NewReader("team bucket"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+
+    resp, _ = http.Get(server.URL + "/buckets/team-a/objects/" + objectPath)
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "team bucket" {
+        t.Errorf("Expected %q, got %q", "team bucket", body)
+    }
+
+    resp, _ = http.Get(server.URL + "/objects/" + objectPath)
+    body, _ = io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "default bucket" {
+        t.Errorf("Expected %q, got %q", "default bucket", body)
+    }
+
+    // Quota
+    resp, _ = http.Post(fmt.Sprintf("%s/buckets/team-a/objects?path=big.bin", server.URL), "application/octet-stream", bytes.NewReader(make([]byte, 200)))
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusInsufficientStorage {
+        t.Errorf("Expected status %d, got %d", http.StatusInsufficientStorage, resp.StatusCode)
+    }
+
+    // An update over the quota is refused the same way
+    req, _ := http.NewRequest(http.MethodPut, server.URL+"/buckets/team-a/objects/"+objectPath, bytes.NewReader(make([]byte, 200)))
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusInsufficientStorage {
+        t.Errorf("Expected status %d for an update over quota, got %d", http.StatusInsufficientStorage, resp.StatusCode)
+    }
+
+    // Update settings and read them back
+    req, _ = http.NewRequest(http.MethodPut, server.URL+"/buckets/team-a", strings.NewReader(`{"versioning":false,"default_retention_days":7,"default_retention_mode":"compliance"}`))
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    resp, _ = http.Get(server.URL + "/buckets/team-a")
+    var bucket bucketResponse
+    json.NewDecoder(resp.Body).Decode(&bucket)
+    resp.Body.Close()
+    if bucket.Versioning || bucket.QuotaBytes != 100 || bucket.DefaultRetentionDays != 7 || bucket.DefaultRetentionMode != "compliance" {
+        t.Errorf("Unexpected bucket settings: %+v", bucket)
+    }
+    if bucket.UsedBytes == nil || *bucket.UsedBytes != int64(len("team bucket")) {
+        t.Errorf("Unexpected bucket usage: %v", bucket.UsedBytes)
+    }
+
+    // A bucket with objects cannot be deleted
+    req, _ = http.NewRequest(http.MethodDelete, server.URL+"/buckets/team-a", nil)
+    resp, _ = http.DefaultClient.Do(req)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusConflict {
+        t.Errorf("Expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+    }
+
+    // Objects in unknown buckets
+    resp, _ = http.Get(server.URL + "/buckets/missing/objects/" + objectPath)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+    }
+}
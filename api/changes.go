@@ -0,0 +1,175 @@
+// api/changes.go
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// maxChangeWait caps how long a long-poll for changes waits.
+const maxChangeWait = 60 * time.Second
+
+// sseKeepalive is how often an idle change stream sends a comment, so that
+// proxies do not time it out.
+const sseKeepalive = 15 * time.Second
+
+type changesResponse struct {
+    Changes []*store.Event `json:"changes"`
+    // NextSince is the since to ask for the next changes with.
+    NextSince int64 `json:"next_since"`
+}
+
+// handleChanges serves /changes, the feed of changes to the bucket's
+// objects. GET returns the changes after the sequence number in since,
+// waiting up to wait seconds for one if there are none yet. Clients that
+// accept text/event-stream instead get the changes as server-sent events
+// as they happen, resuming after Last-Event-ID if given.
+func (h *Handler) handleChanges(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    query := r.URL.Query()
+    opts := store.ChangeOptions{Prefix: query.Get("prefix")}
+    since := query.Get("since")
+    if id := r.Header.Get("Last-Event-ID"); id != "" {
+        since = id
+    }
+    if since != "" {
+        n, err := strconv.ParseInt(since, 10, 64)
+        if err != nil || n < 0 {
+            http.Error(w, "Invalid 'since' query parameter", http.StatusBadRequest)
+            return
+        }
+        opts.Since = n
+    }
+    if v := query.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            http.Error(w, "Invalid 'limit' query parameter", http.StatusBadRequest)
+            return
+        }
+        opts.Limit = n
+    }
+
+    if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+        h.streamChanges(w, r, opts)
+        return
+    }
+
+    var wait time.Duration
+    if v := query.Get("wait"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            http.Error(w, "Invalid 'wait' query parameter", http.StatusBadRequest)
+            return
+        }
+        wait = min(time.Duration(n)*time.Second, maxChangeWait)
+    }
+    h.pollChanges(w, r, opts, wait)
+}
+
+// pollChanges responds with the changes after opts.Since as soon as there
+// are any, or with none once wait has passed.
+func (h *Handler) pollChanges(w http.ResponseWriter, r *http.Request, opts store.ChangeOptions, wait time.Duration) {
+    timeout := time.NewTimer(wait)
+    defer timeout.Stop()
+
+    for {
+        notify := h.store.ChangeNotify()
+        changes, err := h.store.ListChanges(r.Context(), opts)
+        if err != nil {
+            writeError(w, r, err, statusForChangesError(err))
+            return
+        }
+        if len(changes) > 0 {
+            writeJSON(w, http.StatusOK, changesResponse{Changes: changes, NextSince: changes[len(changes)-1].Seq})
+            return
+        }
+
+        select {
+        case <-notify:
+        case <-timeout.C:
+            writeJSON(w, http.StatusOK, changesResponse{Changes: []*store.Event{}, NextSince: opts.Since})
+            return
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+// streamChanges sends the changes after opts.Since as server-sent events,
+// each with its sequence number as ID, its type as event and its JSON as
+// data, until the client goes away.
+func (h *Handler) streamChanges(w http.ResponseWriter, r *http.Request, opts store.ChangeOptions) {
+    keepalive := time.NewTicker(sseKeepalive)
+    defer keepalive.Stop()
+    rc := http.NewResponseController(w)
+
+    started := false
+    for {
+        notify := h.store.ChangeNotify()
+        changes, err := h.store.ListChanges(r.Context(), opts)
+        if err != nil && !started {
+            writeError(w, r, err, statusForChangesError(err))
+            return
+        }
+        if err != nil {
+            // The stream is under way, so all that is left is to tell the
+            // client why it ends.
+            fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+            rc.Flush()
+            return
+        }
+        if !started {
+            w.Header().Set("Content-Type", "text/event-stream")
+            w.Header().Set("Cache-Control", "no-cache")
+            w.WriteHeader(http.StatusOK)
+            started = true
+        }
+
+        for _, change := range changes {
+            data, err := json.Marshal(change)
+            if err != nil {
+                return
+            }
+            fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Type, data)
+            opts.Since = change.Seq
+        }
+        if err := rc.Flush(); err != nil {
+            return
+        }
+        if len(changes) > 0 {
+            // There may be more than one page of changes to catch up on.
+            continue
+        }
+
+        select {
+        case <-notify:
+        case <-keepalive.C:
+            fmt.Fprint(w, ": keepalive\n\n")
+            if err := rc.Flush(); err != nil {
+                return
+            }
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+// statusForChangesError maps ErrChangesExpired to 410 Gone: the consumer
+// has to resynchronize rather than resume.
+func statusForChangesError(err error) int {
+    if errors.Is(err, store.ErrChangesExpired) {
+        return http.StatusGone
+    }
+    return statusForError(err)
+}
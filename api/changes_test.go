@@ -0,0 +1,164 @@
+// api/changes_test.go
+package api
+
+import (
+    "bufio"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+func TestChangesLongPoll(t *testing.T) {
+    ctx := t.Context()
+    server, s := setupTestServer(t)
+    defer server.Close()
+
+    if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+        t.Fatal(err)
+    }
+
+    status, body := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/changes?since=0", ""))
+    var resp changesResponse
+    json.Unmarshal([]byte(body), &resp)
+    if status != http.StatusOK || len(resp.Changes) != 1 || resp.Changes[0].Type != store.EventObjectCreated || resp.NextSince != 1 {
+        t.Fatalf("Unexpected changes %d %s", status, body)
+    }
+
+    // With nothing new, the request waits for the next change.
+    go func() {
+        time.Sleep(100 * time.Millisecond)
+        s.CreateObject(ctx, "b.txt", []byte("b"))
+    }()
+    start := time.Now()
+    status, body = doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/changes?since=1&wait=10", ""))
+    resp = changesResponse{}
+    json.Unmarshal([]byte(body), &resp)
+    if status != http.StatusOK || len(resp.Changes) != 1 || resp.Changes[0].ObjectPath != "b.txt" || resp.NextSince != 2 {
+        t.Fatalf("Unexpected changes %d %s", status, body)
+    }
+    if elapsed := time.Since(start); elapsed > 5*time.Second {
+        t.Errorf("Expected the change to end the wait, took %s", elapsed)
+    }
+
+    // Without a change, the wait times out empty.
+    status, body = doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/changes?since=2&wait=1", ""))
+    if status != http.StatusOK || !strings.Contains(body, `"changes":[]`) || !strings.Contains(body, `"next_since":2`) {
+        t.Errorf("Unexpected empty poll %d %s", status, body)
+    }
+
+    if status, _ := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/changes?since=x", "")); status != http.StatusBadRequest {
+        t.Errorf("Expected 400 for an invalid since, got %d", status)
+    }
+
+    if _, err := s.MetadataStore.PruneChanges(ctx, time.Now().Add(time.Second)); err != nil {
+        t.Fatal(err)
+    }
+    if status, _ := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/changes?since=0", "")); status != http.StatusGone {
+        t.Errorf("Expected 410 for pruned changes, got %d", status)
+    }
+}
+
+func TestChangesStream(t *testing.T) {
+    ctx := t.Context()
+    server, s := setupTestServer(t)
+    defer server.Close()
+
+    if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.CreateObject(ctx, "b.txt", []byte("b")); err != nil {
+        t.Fatal(err)
+    }
+
+    req := mustRequest(t, http.MethodGet, server.URL+"/changes", "")
+    req.Header.Set("Accept", "text/event-stream")
+    req.Header.Set("Last-Event-ID", "1")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+        t.Fatalf("Unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+    }
+
+    events := make(chan store.Event)
+    go func() {
+        defer close(events)
+        var event store.Event
+        scanner := bufio.NewScanner(resp.Body)
+        for scanner.Scan() {
+            if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+                json.Unmarshal([]byte(data), &event)
+                events <- event
+            }
+        }
+    }()
+    next := func() store.Event {
+        t.Helper()
+        select {
+        case event := <-events:
+            return event
+        case <-time.After(5 * time.Second):
+            t.Fatal("Timed out waiting for an event")
+            return store.Event{}
+        }
+    }
+
+    // The stream resumes after the last event ID, then follows new changes.
+    if event := next(); event.Seq != 2 || event.ObjectPath != "b.txt" {
+        t.Errorf("Unexpected first event %+v", event)
+    }
+    if err := s.DeleteObject(ctx, "a.txt"); err != nil {
+        t.Fatal(err)
+    }
+    if event := next(); event.Seq != 3 || event.Type != store.EventObjectDeleted || event.ObjectPath != "a.txt" {
+        t.Errorf("Unexpected second event %+v", event)
+    }
+}
+
+func TestChangesAfterLifecycle(t *testing.T) {
+    ctx := t.Context()
+    server, s := setupTestServer(t)
+    defer server.Close()
+
+    bucket, err := s.GetBucket(ctx, store.DefaultBucket)
+    if err != nil {
+        t.Fatal(err)
+    }
+    bucket.Lifecycle = []store.LifecycleRule{{ID: "history", NoncurrentDays: 7}}
+    if err := s.UpdateBucket(ctx, bucket); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.CreateObject(ctx, "a.txt", []byte("v1")); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.UpdateObject(ctx, "a.txt", []byte("v2")); err != nil {
+        t.Fatal(err)
+    }
+    versions, err := s.ListVersions(ctx, "a.txt")
+    if err != nil || len(versions) != 2 {
+        t.Fatalf("Expected two versions, got %d: %v", len(versions), err)
+    }
+    noncurrent := versions[1]
+
+    if _, err := s.ApplyLifecycle(ctx, store.LifecycleOptions{Now: time.Now().Add(8 * 24 * time.Hour)}); err != nil {
+        t.Fatal(err)
+    }
+
+    // A mirror following the feed learns that the expired version is gone.
+    status, body := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/changes?since=2", ""))
+    var resp changesResponse
+    json.Unmarshal([]byte(body), &resp)
+    if status != http.StatusOK || len(resp.Changes) != 1 {
+        t.Fatalf("Unexpected changes %d %s", status, body)
+    }
+    event := resp.Changes[0]
+    if event.Type != store.EventObjectVersionDeleted || event.ObjectPath != "a.txt" || event.VersionID != noncurrent.VersionID || event.ObjectID == "" {
+        t.Errorf("Unexpected change %+v", event)
+    }
+}
@@ -0,0 +1,82 @@
+// api/compression.go
+package api
+
+import (
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// compressionHeader selects the codec an upload is compressed with at rest,
+// overriding the bucket's default: gzip, zstd, or identity for none. Both
+// APIs accept it.
+const compressionHeader = "X-Compression"
+
+// acceptedCodecs returns the codecs the Accept-Encoding header of r allows
+// content to be sent compressed with. Codings given a zero quality are
+// refused, and a wildcard stands for every codec not named explicitly.
+func acceptedCodecs(r *http.Request) []string {
+    quality := make(map[string]bool)
+    for _, field := range r.Header.Values("Accept-Encoding") {
+        for _, coding := range strings.Split(field, ",") {
+            name, params, _ := strings.Cut(coding, ";")
+            name = strings.ToLower(strings.TrimSpace(name))
+            if name == "" {
+                continue
+            }
+            quality[name] = acceptable(params)
+        }
+    }
+
+    var codecs []string
+    for _, codec := range []string{store.CodecGzip, store.CodecZstd} {
+        accepted, named := quality[codec]
+        if !named {
+            accepted = quality["*"]
+        }
+        if accepted {
+            codecs = append(codecs, codec)
+        }
+    }
+    return codecs
+}
+
+// acceptable reports whether the parameters of an Accept-Encoding coding
+// give it a non-zero quality.
+func acceptable(params string) bool {
+    for _, param := range strings.Split(params, ";") {
+        name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+        if strings.EqualFold(name, "q") {
+            q, err := strconv.ParseFloat(value, 64)
+            return err == nil && q > 0
+        }
+    }
+    return true
+}
+
+// writeContentCoding describes content read from rc that is sent as stored,
+// compressed, instead of the plain content the other headers describe. The
+// ETag becomes weak, since the bytes sent differ from the plain content's.
+func writeContentCoding(w http.ResponseWriter, rc io.Reader) {
+    encoded, ok := rc.(*store.EncodedReader)
+    if !ok {
+        return
+    }
+    h := w.Header()
+    h.Set("Content-Encoding", encoded.Codec)
+    h.Set("Content-Length", strconv.FormatInt(encoded.Size, 10))
+    if etag := h.Get("ETag"); etag != "" {
+        h.Set("ETag", "W/"+etag)
+    }
+}
+
+// writeVary notes that responses for content compressed with codec depend
+// on the request's Accept-Encoding.
+func writeVary(w http.ResponseWriter, codec string) {
+    if codec != "" {
+        w.Header().Add("Vary", "Accept-Encoding")
+    }
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// entityTag returns the quoted ETag of the content stored in blobID, derived
+// from its SHA-256.
+func entityTag(ctx context.Context, s *store.Store, blobID string) (string, error) {
+    hash, err := s.ContentHash(ctx, blobID)
+    if err != nil {
+        return "", err
+    }
+    return `"` + hash + `"`, nil
+}
+
+// hasPreconditions reports whether r carries conditional request headers.
+func hasPreconditions(r *http.Request) bool {
+    for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+        if r.Header.Get(name) != "" {
+            return true
+        }
+    }
+    return false
+}
+
+// evaluatePreconditions checks the conditional headers of r against the
+// current state of an object, in the order given by RFC 9110 section 13.2.2.
+// etag is empty if the object does not exist. It returns 0 if the request
+// should proceed, or the status to respond with otherwise.
+func evaluatePreconditions(r *http.Request, etag string, modTime time.Time) int {
+    exists := etag != ""
+    modTime = modTime.Truncate(time.Second)
+
+    if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+        if !exists || !matchETag(ifMatch, etag, false) {
+            return http.StatusPreconditionFailed
+        }
+    } else if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && exists {
+        if modTime.After(since) {
+            return http.StatusPreconditionFailed
+        }
+    }
+
+    safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+    if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+        if exists && matchETag(ifNoneMatch, etag, true) {
+            if safe {
+                return http.StatusNotModified
+            }
+            return http.StatusPreconditionFailed
+        }
+    } else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && exists && safe {
+        if !modTime.After(since) {
+            return http.StatusNotModified
+        }
+    }
+    return 0
+}
+
+// matchETag reports whether etag is in the comma-separated list of entity
+// tags from an If-Match or If-None-Match header. Weak comparison ignores the
+// W/ prefix; strong comparison never matches weak tags.
+func matchETag(list, etag string, weak bool) bool {
+    for _, candidate := range strings.Split(list, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" {
+            return true
+        }
+        if strings.HasPrefix(candidate, "W/") {
+            if !weak {
+                continue
+            }
+            candidate = candidate[2:]
+        }
+        if candidate == etag {
+            return true
+        }
+    }
+    return false
+}
+
+// serveContent writes the content read from rc, of the given size, as the
+// response body, honouring Range requests. Content that cannot seek, such as
+// decompressed content, is read forward to the start of the range, so only
+// single ranges are served from it; asking for several gets the whole
+// content.
+func serveContent(w http.ResponseWriter, r *http.Request, rc io.Reader, size int64, modTime time.Time) {
+    rs, ok := rc.(io.ReadSeeker)
+    if !ok {
+        if encoded, ok := rc.(*store.EncodedReader); ok {
+            size = encoded.Size
+        }
+        rs = &forwardSeeker{r: rc, size: size}
+        if strings.Contains(r.Header.Get("Range"), ",") {
+            r = r.Clone(r.Context())
+            r.Header.Del("Range")
+        }
+    }
+
+    // ServeContent sets the length of whatever range it sends, and of the
+    // whole content unless it is sent with a Content-Encoding, in which case
+    // the length already set stands.
+    if w.Header().Get("Content-Encoding") == "" {
+        w.Header().Del("Content-Length")
+    }
+    http.ServeContent(w, r, "", modTime, rs)
+}
+
+// errSeekBack is returned when seeking a forwardSeeker back before what it
+// has read.
+var errSeekBack = errors.New("content can only be read forward")
+
+// forwardSeeker presents content that can only be read forward, of a known
+// size, as an io.ReadSeeker. Seeking only moves the position; the content
+// up to it is skipped on the next Read.
+type forwardSeeker struct {
+    r    io.Reader
+    size int64
+    // read is the number of bytes read from r, and pos the position.
+    read int64
+    pos  int64
+}
+
+func (s *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+    switch whence {
+    case io.SeekCurrent:
+        offset += s.pos
+    case io.SeekEnd:
+        offset += s.size
+    }
+    if offset < s.read {
+        return 0, errSeekBack
+    }
+    s.pos = offset
+    return offset, nil
+}
+
+func (s *forwardSeeker) Read(p []byte) (int, error) {
+    if s.pos > s.read {
+        n, err := io.CopyN(io.Discard, s.r, s.pos-s.read)
+        s.read += n
+        if err != nil {
+            return 0, err
+        }
+    }
+    n, err := s.r.Read(p)
+    s.read += int64(n)
+    s.pos = s.read
+    return n, err
+}
@@ -0,0 +1,65 @@
+// api/encryption.go
+package api
+
+import (
+    "crypto/md5"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "net/http"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// Server-side encryption headers, as defined by S3. Both APIs accept them.
+const (
+    sseHeader                  = "X-Amz-Server-Side-Encryption"
+    sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+    sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+    sseCustomerKeyMD5Header    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+    sseAlgorithm               = "AES256"
+)
+
+// customerKey returns the customer-supplied key of a request, or nil if it
+// has none. The key must come with its algorithm and its base64-encoded MD5.
+func customerKey(r *http.Request) ([]byte, error) {
+    algorithm := r.Header.Get(sseCustomerAlgorithmHeader)
+    encoded := r.Header.Get(sseCustomerKeyHeader)
+    if algorithm == "" && encoded == "" {
+        return nil, nil
+    }
+    if algorithm != sseAlgorithm {
+        return nil, fmt.Errorf("%w: the customer key algorithm must be %s", store.ErrInvalidKey, sseAlgorithm)
+    }
+
+    key, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil || len(key) != store.KeySize {
+        return nil, fmt.Errorf("%w: the customer key must be a base64-encoded %d-byte key", store.ErrInvalidKey, store.KeySize)
+    }
+    sum := md5.Sum(key)
+    if r.Header.Get(sseCustomerKeyMD5Header) != base64.StdEncoding.EncodeToString(sum[:]) {
+        return nil, fmt.Errorf("%w: the customer key MD5 does not match the key", store.ErrInvalidKey)
+    }
+    return key, nil
+}
+
+// writeEncryptionHeaders describes how content whose KeyID is keyID is
+// encrypted at rest. For content under a customer key it echoes the key's
+// MD5 from the request.
+func writeEncryptionHeaders(w http.ResponseWriter, r *http.Request, keyID string) {
+    switch keyID {
+    case "":
+    case store.CustomerKeyID:
+        w.Header().Set(sseCustomerAlgorithmHeader, sseAlgorithm)
+        if md5 := r.Header.Get(sseCustomerKeyMD5Header); md5 != "" {
+            w.Header().Set(sseCustomerKeyMD5Header, md5)
+        }
+    default:
+        w.Header().Set(sseHeader, sseAlgorithm)
+    }
+}
+
+// isEncryptionError reports whether err is about a customer-supplied key.
+func isEncryptionError(err error) bool {
+    return errors.Is(err, store.ErrInvalidKey) || errors.Is(err, store.ErrCustomerKeyRequired) || errors.Is(err, store.ErrCustomerKeyMismatch)
+}
@@ -0,0 +1,139 @@
+// api/encryption_test.go
+package api
+
+import (
+    "bytes"
+    "context"
+    "crypto/md5"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/smithy-go"
+)
+
+func newCustomerKey(t *testing.T) (key, md5sum string) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        t.Fatal(err)
+    }
+    sum := md5.Sum(raw)
+    return base64.StdEncoding.EncodeToString(raw), base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func setCustomerKey(r *http.Request, key, md5sum string) {
+    r.Header.Set(sseCustomerAlgorithmHeader, sseAlgorithm)
+    r.Header.Set(sseCustomerKeyHeader, key)
+    r.Header.Set(sseCustomerKeyMD5Header, md5sum)
+}
+
+func TestCustomerKeyObjects(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+    key, md5sum := newCustomerKey(t)
+
+    req, _ := http.NewRequest(http.MethodPost, server.URL+"/objects?path=sealed.txt", strings.NewReader("sealed"))
+    setCustomerKey(req, key, md5sum)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+
+    resp, _ = http.Get(server.URL + "/objects/sealed.txt")
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusBadRequest {
+        t.Errorf("Expected status %d without the key, got %d", http.StatusBadRequest, resp.StatusCode)
+    }
+
+    otherKey, otherMD5 := newCustomerKey(t)
+    req, _ = http.NewRequest(http.MethodGet, server.URL+"/objects/sealed.txt", nil)
+    setCustomerKey(req, otherKey, otherMD5)
+    resp, _ = http.DefaultClient.Do(req)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusForbidden {
+        t.Errorf("Expected status %d with the wrong key, got %d", http.StatusForbidden, resp.StatusCode)
+    }
+
+    req, _ = http.NewRequest(http.MethodGet, server.URL+"/objects/sealed.txt", nil)
+    setCustomerKey(req, key, otherMD5)
+    resp, _ = http.DefaultClient.Do(req)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusBadRequest {
+        t.Errorf("Expected status %d with a mismatched MD5, got %d", http.StatusBadRequest, resp.StatusCode)
+    }
+
+    req, _ = http.NewRequest(http.MethodGet, server.URL+"/objects/sealed.txt", nil)
+    setCustomerKey(req, key, md5sum)
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "sealed" {
+        t.Errorf("Expected %q, got %q", "sealed", body)
+    }
+    if resp.Header.Get(sseCustomerKeyMD5Header) != md5sum {
+        t.Errorf("Expected the key MD5 to be echoed, got %q", resp.Header.Get(sseCustomerKeyMD5Header))
+    }
+}
+
+func TestS3CustomerKeyObjects(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    ctx := context.Background()
+    client := newS3Client(server.URL)
+    bucket := aws.String("sealed")
+    if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: bucket}); err != nil {
+        t.Fatal(err)
+    }
+    key, md5sum := newCustomerKey(t)
+
+    _, err := client.PutObject(ctx, &s3.PutObjectInput{
+        Bucket:               bucket,
+        Key:                  aws.String("secret.txt"),
+        Body:                 strings.NewReader("top secret"),
+        SSECustomerAlgorithm: aws.String(sseAlgorithm),
+        SSECustomerKey:       aws.String(key),
+        SSECustomerKeyMD5:    aws.String(md5sum),
+    })
+    if err != nil {
+        t.Fatalf("PutObject failed: %v", err)
+    }
+
+    _, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("secret.txt")})
+    var apiErr smithy.APIError
+    if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidRequest" {
+        t.Errorf("Expected InvalidRequest without the key, got %v", err)
+    }
+
+    get, err := client.GetObject(ctx, &s3.GetObjectInput{
+        Bucket:               bucket,
+        Key:                  aws.String("secret.txt"),
+        SSECustomerAlgorithm: aws.String(sseAlgorithm),
+        SSECustomerKey:       aws.String(key),
+        SSECustomerKeyMD5:    aws.String(md5sum),
+    })
+    if err != nil {
+        t.Fatalf("GetObject failed: %v", err)
+    }
+    body, _ := io.ReadAll(get.Body)
+    get.Body.Close()
+    if !bytes.Equal(body, []byte("top secret")) {
+        t.Errorf("Expected %q, got %q", "top secret", body)
+    }
+    if aws.ToString(get.SSECustomerKeyMD5) != md5sum {
+        t.Errorf("Expected the key MD5 to be echoed, got %q", aws.ToString(get.SSECustomerKeyMD5))
+    }
+}
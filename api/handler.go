@@ -1,10 +1,15 @@
 package api
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
     "fmt"
     "io"
     "net/http"
+    "strconv"
     "strings"
+    "time"
 
     "github.com/corylehan/object-store/store"
 )
@@ -19,6 +24,8 @@ func NewHandler(s *store.Store) *Handler {
 
 func (h *Handler) handleObjects(w http.ResponseWriter, r *http.Request) {
     switch r.Method {
+    case http.MethodGet:
+        h.listObjects(w, r)
     case http.MethodPost:
         h.createObject(w, r)
     default:
@@ -29,34 +36,162 @@ func (h *Handler) handleObjects(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleObject(w http.ResponseWriter, r *http.Request) {
     // Extract everything after /objects/
     objectPath := strings.TrimPrefix(r.URL.Path, "/objects/")
+    h.serveObject(w, r, objectPath)
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, objectPath string) {
+    if isPresigned(r) {
+        h.servePresigned(w, r, objectPath)
+        return
+    }
+
+    query := r.URL.Query()
     switch r.Method {
     case http.MethodGet:
+        if query.Has("versions") {
+            h.listVersions(w, r, objectPath)
+            return
+        }
+        if query.Has("uploadId") {
+            h.listParts(w, r, objectPath)
+            return
+        }
+        if query.Has("retention") {
+            h.handleRetention(w, r, objectPath)
+            return
+        }
+        if query.Has("legal-hold") {
+            h.handleLegalHold(w, r, objectPath)
+            return
+        }
         h.getObject(w, r, objectPath)
+    case http.MethodHead:
+        h.headObject(w, r, objectPath)
+    case http.MethodPost:
+        switch {
+        case query.Has("restore"):
+            h.restoreVersion(w, r, objectPath)
+        case query.Has("uploads"):
+            h.initiateUpload(w, r, objectPath)
+        case query.Has("uploadId"):
+            h.completeUpload(w, r, objectPath)
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
     case http.MethodPut:
-        h.updateObject(w, r, objectPath)
+        switch {
+        case query.Has("uploadId"):
+            h.uploadPart(w, r, objectPath)
+        case query.Has("retention"):
+            h.handleRetention(w, r, objectPath)
+        case query.Has("legal-hold"):
+            h.handleLegalHold(w, r, objectPath)
+        default:
+            h.updateObject(w, r, objectPath)
+        }
     case http.MethodDelete:
+        if query.Has("uploadId") {
+            h.abortUpload(w, r, objectPath)
+            return
+        }
         h.deleteObject(w, r, objectPath)
     default:
         http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
     }
 }
 
-func (h *Handler) createObject(w http.ResponseWriter, r *http.Request) {
-    data, err := io.ReadAll(r.Body)
+// maxListLimit caps the page size clients may request from listObjects.
+const maxListLimit = 1000
+
+type objectResponse struct {
+    ObjectID  string    `json:"object_id"`
+    Path      string    `json:"path"`
+    VersionID string    `json:"version_id"`
+    Size      int64     `json:"size"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+type listResponse struct {
+    Objects        []objectResponse `json:"objects"`
+    CommonPrefixes []string         `json:"common_prefixes"`
+    IsTruncated    bool             `json:"is_truncated"`
+    NextCursor     string           `json:"next_cursor,omitempty"`
+}
+
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    limit := maxListLimit
+    if v := query.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            http.Error(w, "Invalid 'limit' query parameter", http.StatusBadRequest)
+            return
+        }
+        limit = min(n, maxListLimit)
+    }
+
+    result, err := h.store.ListObjects(r.Context(), store.ListOptions{
+        Prefix:    query.Get("prefix"),
+        Delimiter: query.Get("delimiter"),
+        Cursor:    query.Get("cursor"),
+        Limit:     limit,
+    })
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, r, err, statusForError(err))
         return
     }
 
+    resp := listResponse{
+        Objects:        make([]objectResponse, 0, len(result.Objects)),
+        CommonPrefixes: result.CommonPrefixes,
+        IsTruncated:    result.IsTruncated,
+        NextCursor:     result.NextCursor,
+    }
+    if resp.CommonPrefixes == nil {
+        resp.CommonPrefixes = []string{}
+    }
+    for _, m := range result.Objects {
+        resp.Objects = append(resp.Objects, objectResponse{
+            ObjectID:  m.ObjectID,
+            Path:      m.ObjectPath,
+            VersionID: m.VersionID,
+            Size:      m.Size,
+            CreatedAt: m.CreatedAt,
+            UpdatedAt: m.UpdatedAt,
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) createObject(w http.ResponseWriter, r *http.Request) {
     objectPath := r.URL.Query().Get("path")
     if objectPath == "" {
         http.Error(w, "Missing 'path' query parameter", http.StatusBadRequest)
         return
     }
 
-    objectID, err := h.store.CreateObject(objectPath, data)
+    key, err := customerKey(r)
+    if err != nil {
+        writeError(w, r, err, http.StatusBadRequest)
+        return
+    }
+    retention, legalHold, err := lockOptions(r)
+    if err != nil {
+        writeError(w, r, err, http.StatusBadRequest)
+        return
+    }
+
+    opts := store.WriteOptions{
+        Headers:     storedHeaders(r.Header, userMetadataPrefix),
+        CustomerKey: key,
+        Compression: r.Header.Get(compressionHeader),
+        Retention:   retention,
+        LegalHold:   legalHold,
+    }
+    objectID, err := h.store.CreateObjectWith(r.Context(), objectPath, r.Body, opts)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, r, err, statusForError(err))
         return
     }
 
@@ -65,25 +200,63 @@ func (h *Handler) createObject(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, objectPath string) {
-    data, err := h.store.ReadObject(objectPath)
+    key, err := customerKey(r)
+    if err != nil {
+        writeError(w, r, err, http.StatusBadRequest)
+        return
+    }
+
+    opts := store.ReadOptions{CustomerKey: key, AcceptCodecs: acceptedCodecs(r)}
+    rc, version, err := h.openVersion(r.Context(), objectPath, r.URL.Query().Get("versionId"), opts)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    defer rc.Close()
+
+    if !h.writeVersionHeaders(w, r, version) {
+        return
+    }
+    writeContentCoding(w, rc)
+    serveContent(w, r, rc, version.Size, version.CreatedAt)
+}
+
+func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, objectPath string) {
+    version, err := h.statVersion(r.Context(), objectPath, r.URL.Query().Get("versionId"))
     if err != nil {
-        http.Error(w, err.Error(), http.StatusNotFound)
+        w.WriteHeader(statusForError(err))
         return
     }
 
+    if !h.writeVersionHeaders(w, r, version) {
+        return
+    }
     w.WriteHeader(http.StatusOK)
-    w.Write(data)
 }
 
 func (h *Handler) updateObject(w http.ResponseWriter, r *http.Request, objectPath string) {
-    data, err := io.ReadAll(r.Body)
+    key, err := customerKey(r)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, r, err, http.StatusBadRequest)
         return
     }
 
-    if err := h.store.UpdateObject(objectPath, data); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    opts := store.WriteOptions{
+        Headers:          storedHeaders(r.Header, userMetadataPrefix),
+        CustomerKey:      key,
+        Compression:      r.Header.Get(compressionHeader),
+        BypassGovernance: bypassGovernance(r),
+    }
+    if hasPreconditions(r) {
+        versionID, ok := h.checkPreconditions(w, r, objectPath)
+        if !ok {
+            return
+        }
+        opts.IfVersionID = versionID
+    }
+
+    if err := h.store.UpdateObjectWith(r.Context(), objectPath, r.Body, opts); err != nil {
+        writeError(w, r, err, statusForWriteError(err))
         return
     }
 
@@ -92,8 +265,17 @@ func (h *Handler) updateObject(w http.ResponseWriter, r *http.Request, objectPat
 }
 
 func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, objectPath string) {
-    if err := h.store.DeleteObject(objectPath); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    opts := store.DeleteOptions{BypassGovernance: bypassGovernance(r)}
+    if hasPreconditions(r) {
+        versionID, ok := h.checkPreconditions(w, r, objectPath)
+        if !ok {
+            return
+        }
+        opts.IfVersionID = versionID
+    }
+
+    if err := h.store.DeleteObjectWith(r.Context(), objectPath, opts); err != nil {
+        writeError(w, r, err, statusForWriteError(err))
         return
     }
 
@@ -101,3 +283,215 @@ func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, objectPat
     fmt.Fprintf(w, "Deleted object %s", objectPath)
 }
 
+// openVersion opens the content of an object at versionID, or its current
+// content if versionID is empty.
+func (h *Handler) openVersion(ctx context.Context, objectPath, versionID string, opts store.ReadOptions) (io.ReadCloser, *store.Version, error) {
+    if versionID != "" {
+        return h.store.GetObjectVersionWith(ctx, objectPath, versionID, opts)
+    }
+
+    rc, metadata, err := h.store.GetObjectWith(ctx, objectPath, opts)
+    if err != nil {
+        return nil, nil, err
+    }
+    return rc, currentVersion(metadata), nil
+}
+
+// statVersion is openVersion without opening the content.
+func (h *Handler) statVersion(ctx context.Context, objectPath, versionID string) (*store.Version, error) {
+    if versionID != "" {
+        return h.store.StatObjectVersion(ctx, objectPath, versionID)
+    }
+
+    metadata, err := h.store.StatObject(ctx, objectPath)
+    if err != nil {
+        return nil, err
+    }
+    return currentVersion(metadata), nil
+}
+
+// writeVersionHeaders sets the response headers describing version and
+// evaluates the request's preconditions against it. It reports whether the
+// response should go on; if not, it has been written already.
+func (h *Handler) writeVersionHeaders(w http.ResponseWriter, r *http.Request, version *store.Version) bool {
+    etag, err := entityTag(r.Context(), h.store, version.BlobID)
+    if err != nil {
+        writeError(w, r, err, http.StatusInternalServerError)
+        return false
+    }
+
+    writeStoredHeaders(w, version.Headers, version.Size, userMetadataPrefix)
+    writeEncryptionHeaders(w, r, version.KeyID)
+    writeVary(w, version.Codec)
+    w.Header().Set("ETag", etag)
+    w.Header().Set("Last-Modified", version.CreatedAt.UTC().Format(http.TimeFormat))
+
+    switch status := evaluatePreconditions(r, etag, version.CreatedAt); status {
+    case 0:
+        return true
+    case http.StatusNotModified:
+        writeNotModified(w)
+    default:
+        http.Error(w, "Precondition failed", status)
+    }
+    return false
+}
+
+// checkPreconditions evaluates the preconditions of a write against the
+// object's current version and returns that version's ID, so the store can
+// refuse the write if the object changes in the meantime. It reports whether
+// the write should go on; if not, the response has been written already.
+func (h *Handler) checkPreconditions(w http.ResponseWriter, r *http.Request, objectPath string) (string, bool) {
+    var versionID, etag string
+    var modTime time.Time
+    metadata, err := h.store.StatObject(r.Context(), objectPath)
+    if err == nil {
+        versionID, modTime = metadata.VersionID, metadata.UpdatedAt
+        etag, err = entityTag(r.Context(), h.store, metadata.BlobID)
+    } else if errors.Is(err, store.ErrObjectNotFound) {
+        err = nil
+    }
+    if err != nil {
+        writeError(w, r, err, http.StatusInternalServerError)
+        return "", false
+    }
+
+    if status := evaluatePreconditions(r, etag, modTime); status != 0 {
+        http.Error(w, "Precondition failed", status)
+        return "", false
+    }
+    return versionID, true
+}
+
+// currentVersion describes the current content of an object as a version.
+func currentVersion(metadata *store.Metadata) *store.Version {
+    return &store.Version{
+        Bucket:     metadata.Bucket,
+        ObjectPath: metadata.ObjectPath,
+        VersionID:  metadata.VersionID,
+        BlobID:     metadata.BlobID,
+        Size:       metadata.Size,
+        KeyID:      metadata.KeyID,
+        Codec:      metadata.Codec,
+        CreatedAt:  metadata.UpdatedAt,
+        Headers:    metadata.Headers,
+    }
+}
+
+func writeNotModified(w http.ResponseWriter) {
+    h := w.Header()
+    h.Del("Content-Type")
+    h.Del("Content-Length")
+    h.Del("Content-Encoding")
+    w.WriteHeader(http.StatusNotModified)
+}
+
+type versionResponse struct {
+    VersionID string    `json:"version_id"`
+    Size      int64     `json:"size"`
+    CreatedAt time.Time `json:"created_at"`
+    IsLatest  bool      `json:"is_latest"`
+}
+
+func (h *Handler) listVersions(w http.ResponseWriter, r *http.Request, objectPath string) {
+    versions, err := h.store.ListVersions(r.Context(), objectPath)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := make([]versionResponse, 0, len(versions))
+    for i, v := range versions {
+        resp = append(resp, versionResponse{
+            VersionID: v.VersionID,
+            Size:      v.Size,
+            CreatedAt: v.CreatedAt,
+            IsLatest:  i == 0,
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) restoreVersion(w http.ResponseWriter, r *http.Request, objectPath string) {
+    versionID := r.URL.Query().Get("versionId")
+    if versionID == "" {
+        http.Error(w, "Missing 'versionId' query parameter", http.StatusBadRequest)
+        return
+    }
+
+    restored, err := h.store.RestoreVersion(r.Context(), objectPath, versionID)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprintf(w, "Restored version %s of object %s as version %s", versionID, objectPath, restored.VersionID)
+}
+
+func statusForError(err error) int {
+    switch {
+    case errors.Is(err, store.ErrObjectNotFound), errors.Is(err, store.ErrVersionNotFound), errors.Is(err, store.ErrUploadNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, store.ErrDeliveryNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, store.ErrBucketNotFound), errors.Is(err, store.ErrAPIKeyNotFound), errors.Is(err, store.ErrPolicyNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, store.ErrInvalidCursor), errors.Is(err, store.ErrInvalidBucketName), errors.Is(err, store.ErrInvalidBucketSettings):
+        return http.StatusBadRequest
+    case errors.Is(err, store.ErrInvalidPart), errors.Is(err, store.ErrInvalidPolicy):
+        return http.StatusBadRequest
+    case errors.Is(err, store.ErrInvalidKey), errors.Is(err, store.ErrCustomerKeyRequired):
+        return http.StatusBadRequest
+    case errors.Is(err, store.ErrInvalidCodec), errors.Is(err, errContentSHA256Mismatch):
+        return http.StatusBadRequest
+    case errors.Is(err, store.ErrInvalidPresign), errors.Is(err, store.ErrInvalidRetention):
+        return http.StatusBadRequest
+    case errors.Is(err, store.ErrCustomerKeyMismatch):
+        return http.StatusForbidden
+    case errors.Is(err, store.ErrPresignDisabled), errors.Is(err, store.ErrPresignExpired), errors.Is(err, store.ErrPresignMismatch):
+        return http.StatusForbidden
+    case errors.Is(err, store.ErrObjectExists), errors.Is(err, store.ErrObjectLocked):
+        return http.StatusConflict
+    case errors.Is(err, store.ErrBucketExists), errors.Is(err, store.ErrBucketNotEmpty), errors.Is(err, store.ErrDefaultBucket):
+        return http.StatusConflict
+    case errors.Is(err, store.ErrQuotaExceeded):
+        return http.StatusInsufficientStorage
+    case errors.Is(err, store.ErrPreconditionFailed):
+        return http.StatusPreconditionFailed
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+// statusForWriteError maps errors from updates and deletes, which report
+// failures other than failed preconditions, locked objects, exceeded quotas,
+// missing buckets and bad requests as server errors.
+func statusForWriteError(err error) int {
+    if errors.Is(err, store.ErrPreconditionFailed) {
+        return http.StatusPreconditionFailed
+    }
+    if errors.Is(err, store.ErrObjectLocked) {
+        return http.StatusConflict
+    }
+    if isEncryptionError(err) || errors.Is(err, store.ErrInvalidCodec) || errors.Is(err, errContentSHA256Mismatch) {
+        return statusForError(err)
+    }
+    if errors.Is(err, store.ErrQuotaExceeded) || errors.Is(err, store.ErrBucketNotFound) {
+        return statusForError(err)
+    }
+    return http.StatusInternalServerError
+}
+
+// writeError replies to r with err and status, and records err in the
+// access log.
+func writeError(w http.ResponseWriter, r *http.Request, err error, status int) {
+    setError(r, err)
+    http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
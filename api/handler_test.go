@@ -3,6 +3,8 @@ package api
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/corylehan/object-store/store"
@@ -34,11 +37,7 @@ func setupTestServer(t *testing.T) (*httptest.Server, *store.Store) {
         t.Fatal(err)
     }
 
-    h := NewHandler(s)
-    mux := http.NewServeMux()
-    mux.HandleFunc("/objects", h.handleObjects)
-    mux.HandleFunc("/objects/", h.handleObject)
-    server := httptest.NewServer(mux)
+    server := httptest.NewServer(NewServer(0, s).Router)
 
     return server, s
 }
@@ -145,3 +144,323 @@ func TestUpdateAndDeleteObject(t *testing.T) {
         t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
     }
 }
+
+func TestObjectVersions(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    objectURL := fmt.Sprintf("%s/objects/%s", server.URL, url.QueryEscape("test/versioned.txt"))
+    http.Post(fmt.Sprintf("%s/objects?path=%s", server.URL, url.QueryEscape("test/versioned.txt")), "application/octet-stream", bytes.NewReader([]byte("first")))
+    req, _ := http.NewRequest(http.MethodPut, objectURL, bytes.NewReader([]byte("second")))
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    // List versions
+    resp, err = http.Get(objectURL + "?versions")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var versions []versionResponse
+    json.NewDecoder(resp.Body).Decode(&versions)
+    resp.Body.Close()
+
+    if len(versions) != 2 || !versions[0].IsLatest {
+        t.Fatalf("Expected 2 versions with the newest first, got %+v", versions)
+    }
+    oldVersion := versions[1].VersionID
+
+    // Fetch the old version
+    resp, _ = http.Get(objectURL + "?versionId=" + oldVersion)
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "first" {
+        t.Errorf("Expected old version content %q, got %q", "first", body)
+    }
+
+    // Restore the old version
+    resp, err = http.Post(objectURL+"?restore&versionId="+oldVersion, "", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+
+    resp, _ = http.Get(objectURL)
+    body, _ = io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "first" {
+        t.Errorf("Expected restored content %q, got %q", "first", body)
+    }
+
+    // Unknown version
+    resp, _ = http.Get(objectURL + "?versionId=unknown")
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+    }
+}
+
+func TestObjectHeaders(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    objectPath := "reports/summary.json"
+    req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/objects?path=%s", server.URL, url.QueryEscape(objectPath)), bytes.NewReader([]byte(`{"ok":true}`)))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Content-Disposition", `attachment; filename="summary.json"`)
+    req.Header.Set("Cache-Control", "max-age=60")
+    req.Header.Set("X-Meta-Build-Id", "1234")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+
+    objectURL := fmt.Sprintf("%s/objects/%s", server.URL, url.QueryEscape(objectPath))
+    checkHeaders := func(resp *http.Response) {
+        t.Helper()
+        want := map[string]string{
+            "Content-Type":        "application/json",
+            "Content-Length":      "11",
+            "Content-Disposition": `attachment; filename="summary.json"`,
+            "Cache-Control":       "max-age=60",
+            "X-Meta-Build-Id":     "1234",
+        }
+        for name, value := range want {
+            if got := resp.Header.Get(name); got != value {
+                t.Errorf("%s %s: expected %s %q, got %q", resp.Request.Method, resp.Request.URL.Path, name, value, got)
+            }
+        }
+    }
+
+    resp, err = http.Get(objectURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    checkHeaders(resp)
+
+    resp, err = http.Head(objectURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("Expected HEAD status %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    checkHeaders(resp)
+
+    // An update replaces the stored headers; older versions keep theirs.
+    req, _ = http.NewRequest(http.MethodPut, objectURL, bytes.NewReader([]byte("plain")))
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    resp, err = http.Head(objectURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if got := resp.Header.Get("Content-Type"); got != "application/octet-stream" {
+        t.Errorf("Expected default Content-Type after update, got %q", got)
+    }
+    if got := resp.Header.Get("X-Meta-Build-Id"); got != "" {
+        t.Errorf("Expected user metadata to be replaced on update, got %q", got)
+    }
+
+    resp, err = http.Get(objectURL + "?versions")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var versions []versionResponse
+    json.NewDecoder(resp.Body).Decode(&versions)
+    resp.Body.Close()
+    if len(versions) != 2 {
+        t.Fatalf("Expected 2 versions, got %d", len(versions))
+    }
+    resp, err = http.Head(objectURL + "?versionId=" + versions[1].VersionID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    checkHeaders(resp)
+
+    resp, err = http.Head(fmt.Sprintf("%s/objects/missing", server.URL))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Errorf("Expected HEAD status %d for a missing object, got %d", http.StatusNotFound, resp.StatusCode)
+    }
+}
+
+func TestRangeAndConditionalRequests(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    content := []byte("0123456789abcdefghij")
+    sum := sha256.Sum256(content)
+    wantETag := `"` + hex.EncodeToString(sum[:]) + `"`
+    objectURL := fmt.Sprintf("%s/objects/%s", server.URL, url.QueryEscape("data/range.bin"))
+    resp, err := http.Post(fmt.Sprintf("%s/objects?path=%s", server.URL, url.QueryEscape("data/range.bin")), "application/octet-stream", bytes.NewReader(content))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    do := func(method, rawURL string, body []byte, header map[string]string) (*http.Response, []byte) {
+        t.Helper()
+        req, _ := http.NewRequest(method, rawURL, bytes.NewReader(body))
+        for name, value := range header {
+            req.Header.Set(name, value)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        return resp, data
+    }
+
+    resp, _ = do(http.MethodGet, objectURL, nil, nil)
+    etag := resp.Header.Get("ETag")
+    if etag != wantETag {
+        t.Errorf("Expected ETag %s, got %s", wantETag, etag)
+    }
+    lastModified := resp.Header.Get("Last-Modified")
+    if lastModified == "" {
+        t.Error("Expected a Last-Modified header")
+    }
+
+    resp, data := do(http.MethodGet, objectURL, nil, map[string]string{"Range": "bytes=5-9"})
+    if resp.StatusCode != http.StatusPartialContent || string(data) != "56789" {
+        t.Errorf("Expected 206 with %q, got %d with %q", "56789", resp.StatusCode, data)
+    }
+    if got := resp.Header.Get("Content-Range"); got != "bytes 5-9/20" {
+        t.Errorf("Expected Content-Range %q, got %q", "bytes 5-9/20", got)
+    }
+
+    resp, data = do(http.MethodGet, objectURL, nil, map[string]string{"Range": "bytes=0-1,-2"})
+    if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Type"), "multipart/byteranges") {
+        t.Errorf("Expected a multipart 206, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+    }
+    if !bytes.Contains(data, []byte("01")) || !bytes.Contains(data, []byte("ij")) {
+        t.Errorf("Multipart body is missing a range: %q", data)
+    }
+
+    resp, _ = do(http.MethodGet, objectURL, nil, map[string]string{"Range": "bytes=50-60"})
+    if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+        t.Errorf("Expected status %d, got %d", http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+    }
+
+    conditionals := []struct {
+        method string
+        header map[string]string
+        status int
+    }{
+        {http.MethodGet, map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+        {http.MethodGet, map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+        {http.MethodGet, map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+        {http.MethodGet, map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+        {http.MethodHead, map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+        {http.MethodGet, map[string]string{"If-Unmodified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}, http.StatusPreconditionFailed},
+        {http.MethodPut, map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+        {http.MethodPut, map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed},
+        {http.MethodDelete, map[string]string{"If-Unmodified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}, http.StatusPreconditionFailed},
+    }
+    for _, c := range conditionals {
+        resp, _ := do(c.method, objectURL, []byte("changed"), c.header)
+        if resp.StatusCode != c.status {
+            t.Errorf("%s with %v: expected status %d, got %d", c.method, c.header, c.status, resp.StatusCode)
+        }
+    }
+
+    // A matching If-Match lets the update through, after which the old
+    // ETag no longer matches.
+    resp, _ = do(http.MethodPut, objectURL, []byte("changed"), map[string]string{"If-Match": etag})
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("Expected conditional update to succeed, got %d", resp.StatusCode)
+    }
+    resp, _ = do(http.MethodDelete, objectURL, nil, map[string]string{"If-Match": etag})
+    if resp.StatusCode != http.StatusPreconditionFailed {
+        t.Errorf("Expected delete with a stale ETag to fail, got %d", resp.StatusCode)
+    }
+    resp, data = do(http.MethodGet, objectURL, nil, nil)
+    if string(data) != "changed" {
+        t.Errorf("Expected content %q, got %q", "changed", data)
+    }
+    resp, _ = do(http.MethodDelete, objectURL, nil, map[string]string{"If-Match": resp.Header.Get("ETag")})
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("Expected delete with the current ETag to succeed, got %d", resp.StatusCode)
+    }
+}
+
+func TestListObjects(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    for _, path := range []string{"docs/2026/a.txt", "docs/2026/b.txt", "docs/index.html", "images/logo.png"} {
+        resp, err := http.Post(fmt.Sprintf("%s/objects?path=%s", server.URL, url.QueryEscape(path)), "application/octet-stream", bytes.NewReader([]byte(path)))
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+    }
+
+    resp, err := http.Get(server.URL + "/objects?prefix=docs/&delimiter=/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var list listResponse
+    json.NewDecoder(resp.Body).Decode(&list)
+    resp.Body.Close()
+
+    if len(list.Objects) != 1 || list.Objects[0].Path != "docs/index.html" {
+        t.Errorf("Unexpected objects: %+v", list.Objects)
+    }
+    if len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0] != "docs/2026/" {
+        t.Errorf("Unexpected common prefixes: %v", list.CommonPrefixes)
+    }
+
+    // Page through everything one entry at a time
+    var paths []string
+    cursor := ""
+    for {
+        resp, err := http.Get(server.URL + "/objects?limit=1&cursor=" + url.QueryEscape(cursor))
+        if err != nil {
+            t.Fatal(err)
+        }
+        var page listResponse
+        json.NewDecoder(resp.Body).Decode(&page)
+        resp.Body.Close()
+
+        for _, obj := range page.Objects {
+            paths = append(paths, obj.Path)
+        }
+        if !page.IsTruncated {
+            break
+        }
+        cursor = page.NextCursor
+    }
+    if len(paths) != 4 {
+        t.Errorf("Expected 4 paths across pages, got %v", paths)
+    }
+
+    resp, _ = http.Get(server.URL + "/objects?limit=zero")
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusBadRequest {
+        t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+    }
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// userMetadataPrefix marks request and response headers that carry user
+// metadata on the /objects API.
+const userMetadataPrefix = "X-Meta-"
+
+// storedHeaders reads the headers of an upload that are stored with the
+// object. User metadata is taken from headers starting with metaPrefix.
+func storedHeaders(h http.Header, metaPrefix string) store.Headers {
+    headers := store.Headers{
+        ContentType:        h.Get("Content-Type"),
+        ContentEncoding:    h.Get("Content-Encoding"),
+        ContentDisposition: h.Get("Content-Disposition"),
+        CacheControl:       h.Get("Cache-Control"),
+    }
+    for name, values := range h {
+        key, ok := strings.CutPrefix(name, metaPrefix)
+        if !ok || key == "" {
+            continue
+        }
+        if headers.UserMetadata == nil {
+            headers.UserMetadata = make(map[string]string)
+        }
+        headers.UserMetadata[strings.ToLower(key)] = strings.Join(values, ",")
+    }
+    return headers
+}
+
+// writeStoredHeaders sets the response headers describing stored content of
+// the given size.
+func writeStoredHeaders(w http.ResponseWriter, headers store.Headers, size int64, metaPrefix string) {
+    h := w.Header()
+    contentType := headers.ContentType
+    if contentType == "" {
+        contentType = "application/octet-stream"
+    }
+    h.Set("Content-Type", contentType)
+    h.Set("Content-Length", strconv.FormatInt(size, 10))
+    if headers.ContentEncoding != "" {
+        h.Set("Content-Encoding", headers.ContentEncoding)
+    }
+    if headers.ContentDisposition != "" {
+        h.Set("Content-Disposition", headers.ContentDisposition)
+    }
+    if headers.CacheControl != "" {
+        h.Set("Cache-Control", headers.CacheControl)
+    }
+    for key, value := range headers.UserMetadata {
+        h.Set(metaPrefix+key, value)
+    }
+}
@@ -0,0 +1,173 @@
+// api/keys.go
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+type apiKeyRequest struct {
+    Admin    bool  `json:"admin"`
+    Disabled *bool `json:"disabled"`
+    // GraceSeconds is how long the previous secret stays valid after a
+    // rotation.
+    GraceSeconds int `json:"grace_seconds"`
+}
+
+type apiKeyResponse struct {
+    AccessKeyID string `json:"access_key_id"`
+    // Secret is only returned when the key is created or rotated.
+    Secret                  string     `json:"secret,omitempty"`
+    Admin                   bool       `json:"admin"`
+    Disabled                bool       `json:"disabled"`
+    CreatedAt               time.Time  `json:"created_at"`
+    RotatedAt               *time.Time `json:"rotated_at,omitempty"`
+    PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+}
+
+func newAPIKeyResponse(key *store.APIKey) apiKeyResponse {
+    resp := apiKeyResponse{
+        AccessKeyID: key.AccessKeyID,
+        Admin:       key.Admin,
+        Disabled:    key.Disabled,
+        CreatedAt:   key.CreatedAt,
+    }
+    if !key.RotatedAt.IsZero() {
+        resp.RotatedAt = &key.RotatedAt
+    }
+    if key.PreviousSecret != "" && time.Now().Before(key.PreviousExpiresAt) {
+        resp.PreviousSecretExpiresAt = &key.PreviousExpiresAt
+    }
+    return resp
+}
+
+// handleKeys serves /admin/keys. GET lists the API keys, without their
+// secrets; POST creates one and returns its secret, which cannot be
+// retrieved again.
+func (h *Handler) handleKeys(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        h.listKeys(w, r)
+    case http.MethodPost:
+        h.createKey(w, r)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// handleKey serves /admin/keys/{id}, which PUT disables or re-enables,
+// /admin/keys/{id}/rotate, which POST gives a new secret, and
+// /admin/keys/{id}/policy, the key's policy.
+func (h *Handler) handleKey(w http.ResponseWriter, r *http.Request) {
+    id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, adminPrefix+"keys/"), "/")
+    switch {
+    case action == "" && r.Method == http.MethodGet:
+        h.getKey(w, r, id)
+    case action == "" && r.Method == http.MethodPut:
+        h.updateKey(w, r, id)
+    case action == "rotate" && r.Method == http.MethodPost:
+        h.rotateKey(w, r, id)
+    case action == "policy":
+        h.servePolicy(w, r, store.PolicyKey, id)
+    case action == "" || action == "rotate":
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+    keys, err := h.store.ListAPIKeys(r.Context())
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := make([]apiKeyResponse, 0, len(keys))
+    for _, key := range keys {
+        resp = append(resp, newAPIKeyResponse(key))
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
+    req, ok := decodeAPIKeyRequest(w, r)
+    if !ok {
+        return
+    }
+
+    key, err := h.store.CreateAPIKey(r.Context(), req.Admin)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := newAPIKeyResponse(key)
+    resp.Secret = key.Secret
+    writeJSON(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) getKey(w http.ResponseWriter, r *http.Request, id string) {
+    key, err := h.store.GetAPIKey(r.Context(), id)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, newAPIKeyResponse(key))
+}
+
+func (h *Handler) updateKey(w http.ResponseWriter, r *http.Request, id string) {
+    req, ok := decodeAPIKeyRequest(w, r)
+    if !ok {
+        return
+    }
+    if req.Disabled == nil {
+        http.Error(w, "Missing 'disabled' setting", http.StatusBadRequest)
+        return
+    }
+
+    key, err := h.store.SetAPIKeyDisabled(r.Context(), id, *req.Disabled)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, newAPIKeyResponse(key))
+}
+
+func (h *Handler) rotateKey(w http.ResponseWriter, r *http.Request, id string) {
+    req, ok := decodeAPIKeyRequest(w, r)
+    if !ok {
+        return
+    }
+    if req.GraceSeconds < 0 {
+        http.Error(w, "The grace period must not be negative", http.StatusBadRequest)
+        return
+    }
+
+    key, err := h.store.RotateAPIKey(r.Context(), id, time.Duration(req.GraceSeconds)*time.Second)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := newAPIKeyResponse(key)
+    resp.Secret = key.Secret
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// decodeAPIKeyRequest reads the settings of a key request, which may have
+// no body.
+func decodeAPIKeyRequest(w http.ResponseWriter, r *http.Request) (*apiKeyRequest, bool) {
+    var req apiKeyRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+        http.Error(w, "Invalid key JSON: "+err.Error(), http.StatusBadRequest)
+        return nil, false
+    }
+    return &req, true
+}
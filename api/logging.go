@@ -0,0 +1,110 @@
+// api/logging.go
+package api
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "log/slog"
+    "net/http"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// requestIDHeader carries the ID of a request. The server keeps the ID a
+// client sends if it is valid, generates one otherwise, and returns it in
+// the response.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength caps the length of the request IDs clients may send.
+const maxRequestIDLength = 128
+
+// requestLog collects what the access log records about a request beyond
+// what the middleware sees itself.
+type requestLog struct {
+    info *store.RequestInfo
+    // principal is the access key ID the request was signed with.
+    principal string
+    // err is the error the request failed with, logged for server errors.
+    err error
+}
+
+type requestLogKey struct{}
+
+// logRequests wraps next so that every request gets an ID, carried to the
+// store through the request context, and is logged to the server's Logger
+// once served.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        entry := &requestLog{info: &store.RequestInfo{ID: requestID(r)}}
+        w.Header().Set(requestIDHeader, entry.info.ID)
+        ctx := store.WithRequestInfo(r.Context(), entry.info)
+        ctx = context.WithValue(ctx, requestLogKey{}, entry)
+        body := &countingReader{r: r.Body}
+        r.Body = body
+        rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+        start := time.Now()
+        next.ServeHTTP(rec, r.WithContext(ctx))
+
+        attrs := []slog.Attr{
+            slog.String("request_id", entry.info.ID),
+            slog.String("method", r.Method),
+            slog.String("path", r.URL.Path),
+            slog.String("object_id", entry.info.ObjectID),
+            slog.Int("status", rec.status),
+            slog.Int64("bytes_in", body.n),
+            slog.Int64("bytes_out", rec.n),
+            slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+            slog.String("principal", entry.principal),
+            slog.String("remote_addr", r.RemoteAddr),
+        }
+        if rec.status >= http.StatusInternalServerError && entry.err != nil {
+            attrs = append(attrs, slog.String("error", entry.err.Error()))
+        }
+        s.Logger.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+    })
+}
+
+// requestID returns the ID the client sent for r if it is valid, or a new
+// one.
+func requestID(r *http.Request) string {
+    if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+        return id
+    }
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether id is safe to log and return: short and
+// made of letters, digits and a few punctuation characters.
+func validRequestID(id string) bool {
+    if id == "" || len(id) > maxRequestIDLength {
+        return false
+    }
+    for _, c := range id {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+        case c == '-', c == '_', c == '.', c == ':':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// setError records the error r failed with in the access log.
+func setError(r *http.Request, err error) {
+    if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+        entry.err = err
+    }
+}
+
+// setPrincipal records who made r in the access log.
+func setPrincipal(r *http.Request, principal string) {
+    if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+        entry.principal = principal
+    }
+}
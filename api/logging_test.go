@@ -0,0 +1,139 @@
+// api/logging_test.go
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// serveLogged serves req with server, returning the response and the
+// access log record it produced.
+func serveLogged(t *testing.T, server *Server, req *http.Request) (*httptest.ResponseRecorder, map[string]any) {
+    t.Helper()
+    var buf bytes.Buffer
+    server.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+    rec := httptest.NewRecorder()
+    server.Handler.ServeHTTP(rec, req)
+
+    var record map[string]any
+    if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+        t.Fatalf("Invalid access log %q: %v", buf.String(), err)
+    }
+    return rec, record
+}
+
+func TestAccessLog(t *testing.T) {
+    router, s := setupTestServer(t)
+    defer router.Close()
+    server := NewServer(0, s)
+
+    req := httptest.NewRequest(http.MethodPost, "/objects?path=a.txt", strings.NewReader("hello"))
+    req.Header.Set(requestIDHeader, "client-id-1")
+    rec, record := serveLogged(t, server, req)
+    if rec.Code != http.StatusCreated || rec.Header().Get(requestIDHeader) != "client-id-1" {
+        t.Fatalf("Unexpected response %d %v", rec.Code, rec.Header())
+    }
+    objectID := strings.TrimPrefix(rec.Body.String(), "Created object ")
+    want := map[string]any{
+        "msg":        "request",
+        "request_id": "client-id-1",
+        "method":     "POST",
+        "path":       "/objects",
+        "object_id":  objectID,
+        "status":     float64(http.StatusCreated),
+        "bytes_in":   float64(5),
+        "bytes_out":  float64(rec.Body.Len()),
+        "principal":  "",
+    }
+    for key, value := range want {
+        if record[key] != value {
+            t.Errorf("Expected %s to be %v, got %v", key, value, record[key])
+        }
+    }
+    if _, ok := record["duration_ms"].(float64); !ok {
+        t.Errorf("Expected a duration, got %v", record["duration_ms"])
+    }
+
+    // Objects addressed by path are logged by ID.
+    rec, record = serveLogged(t, server, httptest.NewRequest(http.MethodGet, "/s3/default/a.txt", nil))
+    if rec.Code != http.StatusOK || record["object_id"] != objectID || record["bytes_out"] != float64(5) {
+        t.Errorf("Unexpected record for S3 read %v", record)
+    }
+
+    // Invalid IDs are replaced, and S3 errors carry the ID.
+    req = httptest.NewRequest(http.MethodGet, "/s3/default/missing.txt", nil)
+    req.Header.Set(requestIDHeader, "bad id\n")
+    rec, record = serveLogged(t, server, req)
+    id := rec.Header().Get(requestIDHeader)
+    if id == "" || id == "bad id\n" || record["request_id"] != id {
+        t.Errorf("Expected a generated request ID, got %q logged as %v", id, record["request_id"])
+    }
+    if !strings.Contains(rec.Body.String(), "<RequestId>"+id+"</RequestId>") {
+        t.Errorf("Expected the S3 error to carry the request ID, got %s", rec.Body.String())
+    }
+}
+
+func TestAccessLogPrincipal(t *testing.T) {
+    ctx := t.Context()
+    tempDir := t.TempDir()
+    configFile := filepath.Join(tempDir, "config.json")
+    config := store.Config{
+        StorageDirectory: filepath.Join(tempDir, "storage"),
+        Auth:             store.AuthConfig{Enabled: true},
+    }
+    configData, _ := json.Marshal(config)
+    os.WriteFile(configFile, configData, 0644)
+    s, err := store.NewStore(configFile, filepath.Join(tempDir, "metadata.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+    key, err := s.CreateAPIKey(ctx, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := NewServer(0, s)
+
+    req := signedRequest(t, http.MethodGet, "http://example.com/objects", "", key, time.Now())
+    if rec, record := serveLogged(t, server, req); rec.Code != http.StatusOK || record["principal"] != key.AccessKeyID {
+        t.Errorf("Expected principal %s, got %d %v", key.AccessKeyID, rec.Code, record["principal"])
+    }
+    req = httptest.NewRequest(http.MethodGet, "/objects", nil)
+    if rec, record := serveLogged(t, server, req); rec.Code != http.StatusForbidden || record["principal"] != "" || record["status"] != float64(http.StatusForbidden) {
+        t.Errorf("Expected an anonymous denied request, got %d %v", rec.Code, record)
+    }
+}
+
+func TestAccessLogError(t *testing.T) {
+    router, s := setupTestServer(t)
+    defer router.Close()
+    server := NewServer(0, s)
+
+    // Client errors are not logged with their error.
+    rec, record := serveLogged(t, server, httptest.NewRequest(http.MethodGet, "/objects/missing.txt", nil))
+    if rec.Code != http.StatusNotFound || record["error"] != nil {
+        t.Errorf("Expected a not found request without an error, got %d %v", rec.Code, record)
+    }
+
+    // Server errors carry the store error, in both APIs.
+    s.Close()
+    for _, path := range []string{"/objects", "/s3/default"} {
+        rec, record = serveLogged(t, server, httptest.NewRequest(http.MethodGet, path, nil))
+        if rec.Code != http.StatusInternalServerError {
+            t.Fatalf("Expected %s to fail with %d, got %d", path, http.StatusInternalServerError, rec.Code)
+        }
+        if logged, _ := record["error"].(string); !strings.Contains(logged, "database is closed") {
+            t.Errorf("Expected %s to log the store error, got %v", path, record)
+        }
+    }
+}
@@ -0,0 +1,142 @@
+// api/metrics.go
+package api
+
+import (
+    "io"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsPath is the path Prometheus scrapes.
+const metricsPath = "/metrics"
+
+// httpMetrics holds the metrics of the requests the server serves, and
+// the registry they are exposed from together with the store's.
+type httpMetrics struct {
+    registry *prometheus.Registry
+    requests *prometheus.CounterVec
+    duration *prometheus.HistogramVec
+    bytesIn  *prometheus.CounterVec
+    bytesOut *prometheus.CounterVec
+}
+
+func newHTTPMetrics(s *store.Store) *httpMetrics {
+    labels := []string{"route", "method", "status"}
+    m := &httpMetrics{
+        registry: prometheus.NewRegistry(),
+        requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "objectstore_http_requests_total",
+            Help: "Requests served, by route, method and status.",
+        }, labels),
+        duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "objectstore_http_request_duration_seconds",
+            Help:    "Time taken to serve requests, by route, method and status.",
+            Buckets: prometheus.DefBuckets,
+        }, labels),
+        bytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "objectstore_http_request_bytes_total",
+            Help: "Bytes read from request bodies, by route.",
+        }, []string{"route"}),
+        bytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "objectstore_http_response_bytes_total",
+            Help: "Bytes written to response bodies, by route.",
+        }, []string{"route"}),
+    }
+    m.registry.MustRegister(
+        m.requests, m.duration, m.bytesIn, m.bytesOut,
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+    )
+    m.registry.MustRegister(s.Collectors()...)
+    return m
+}
+
+// handler serves the metrics in the Prometheus exposition format.
+func (m *httpMetrics) handler() http.Handler {
+    return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument wraps next so that every request is counted and timed under
+// the pattern router routes it to, which keeps the number of series bounded
+// whatever paths clients ask for.
+func (m *httpMetrics) instrument(router *http.ServeMux, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, route := router.Handler(r)
+        if route == "" {
+            route = "unmatched"
+        }
+        body := &countingReader{r: r.Body}
+        r.Body = body
+        rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+        start := time.Now()
+        next.ServeHTTP(rec, r)
+
+        status := strconv.Itoa(rec.status)
+        method := metricsMethod(r.Method)
+        m.requests.WithLabelValues(route, method, status).Inc()
+        m.duration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+        m.bytesIn.WithLabelValues(route).Add(float64(body.n))
+        m.bytesOut.WithLabelValues(route).Add(float64(rec.n))
+    })
+}
+
+// metricsMethod returns the label for a request method, folding unknown
+// methods together.
+func metricsMethod(method string) string {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions:
+        return method
+    }
+    return "other"
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+    r io.ReadCloser
+    n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    c.n += int64(n)
+    return n, err
+}
+
+func (c *countingReader) Close() error {
+    return c.r.Close()
+}
+
+// responseRecorder records the status and counts the bytes of a response.
+type responseRecorder struct {
+    http.ResponseWriter
+    status      int
+    n           int64
+    wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+    if !rec.wroteHeader {
+        rec.status, rec.wroteHeader = status, true
+    }
+    rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+    rec.wroteHeader = true
+    n, err := rec.ResponseWriter.Write(p)
+    rec.n += int64(n)
+    return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, to flush
+// streamed responses.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+    return rec.ResponseWriter
+}
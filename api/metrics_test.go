@@ -0,0 +1,81 @@
+// api/metrics_test.go
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/corylehan/object-store/store"
+)
+
+func TestMetrics(t *testing.T) {
+    router, s := setupTestServer(t)
+    defer router.Close()
+    server := httptest.NewServer(NewServer(0, s).Handler)
+    defer server.Close()
+
+    doRequest(t, mustRequest(t, http.MethodPost, server.URL+"/objects?path=a.txt", "hello"))
+    doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/objects/a.txt", ""))
+    doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/objects/missing.txt", ""))
+
+    status, body := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/metrics", ""))
+    if status != http.StatusOK {
+        t.Fatalf("Expected 200, got %d", status)
+    }
+    for _, want := range []string{
+        `objectstore_http_requests_total{method="POST",route="/objects",status="201"} 1`,
+        `objectstore_http_requests_total{method="GET",route="/objects/",status="200"} 1`,
+        `objectstore_http_requests_total{method="GET",route="/objects/",status="404"} 1`,
+        `objectstore_http_request_duration_seconds_count{method="GET",route="/objects/",status="200"} 1`,
+        `objectstore_http_request_bytes_total{route="/objects"} 5`,
+        `objectstore_http_response_bytes_total{route="/objects/"} `,
+        `objectstore_store_operation_duration_seconds_count{operation="create",phase="file"} 1`,
+        `objectstore_store_operation_duration_seconds_count{operation="create",phase="metadata"} 1`,
+        `objectstore_store_operation_duration_seconds_count{operation="get",phase="file"} 1`,
+        `objectstore_objects{bucket="default"} 1`,
+        `objectstore_object_bytes{bucket="default"} 5`,
+        `objectstore_blob_bytes 5`,
+        `go_sql_open_connections{db_name="metadata"}`,
+    } {
+        if !strings.Contains(body, want) {
+            t.Errorf("Expected metrics to contain %s", want)
+        }
+    }
+}
+
+func TestPublicMetrics(t *testing.T) {
+    for _, public := range []bool{false, true} {
+        tempDir := t.TempDir()
+        configFile := filepath.Join(tempDir, "config.json")
+        config := store.Config{
+            StorageDirectory: filepath.Join(tempDir, "storage"),
+            Auth:             store.AuthConfig{Enabled: true, PublicMetrics: public},
+        }
+        configData, _ := json.Marshal(config)
+        os.WriteFile(configFile, configData, 0644)
+        s, err := store.NewStore(configFile, filepath.Join(tempDir, "metadata.db"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        server := httptest.NewServer(NewServer(0, s).Handler)
+
+        want := http.StatusForbidden
+        if public {
+            want = http.StatusOK
+        }
+        if status, _ := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/metrics", "")); status != want {
+            t.Errorf("Expected %d for public metrics %v, got %d", want, public, status)
+        }
+        // Only /metrics is public.
+        if status, _ := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/objects", "")); status != http.StatusForbidden {
+            t.Errorf("Expected 403 for objects, got %d", status)
+        }
+        server.Close()
+        s.Close()
+    }
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+type uploadResponse struct {
+    UploadID  string    `json:"upload_id"`
+    Path      string    `json:"path"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type partResponse struct {
+    PartNumber int       `json:"part_number"`
+    ETag       string    `json:"etag"`
+    Size       int64     `json:"size"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+type completeRequest struct {
+    Parts []struct {
+        PartNumber int    `json:"part_number"`
+        ETag       string `json:"etag"`
+    } `json:"parts"`
+}
+
+type completeResponse struct {
+    objectResponse
+    ETag     string `json:"etag"`
+    Checksum string `json:"checksum"`
+}
+
+func (h *Handler) initiateUpload(w http.ResponseWriter, r *http.Request, objectPath string) {
+    opts := store.WriteOptions{Headers: storedHeaders(r.Header, userMetadataPrefix)}
+    upload, err := h.store.InitiateUpload(r.Context(), objectPath, opts)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, uploadResponse{
+        UploadID:  upload.UploadID,
+        Path:      upload.ObjectPath,
+        CreatedAt: upload.CreatedAt,
+    })
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, objectPath string) {
+    query := r.URL.Query()
+    uploadID := query.Get("uploadId")
+    partNumber, err := strconv.Atoi(query.Get("partNumber"))
+    if err != nil {
+        http.Error(w, "Invalid 'partNumber' query parameter", http.StatusBadRequest)
+        return
+    }
+    if !h.checkUpload(w, r, uploadID, objectPath) {
+        return
+    }
+
+    part, err := h.store.UploadPart(r.Context(), uploadID, partNumber, r.Body)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    w.Header().Set("ETag", `"`+part.Hash+`"`)
+    writeJSON(w, http.StatusOK, newPartResponse(part))
+}
+
+func (h *Handler) listParts(w http.ResponseWriter, r *http.Request, objectPath string) {
+    uploadID := r.URL.Query().Get("uploadId")
+    if !h.checkUpload(w, r, uploadID, objectPath) {
+        return
+    }
+
+    parts, err := h.store.ListParts(r.Context(), uploadID)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := make([]partResponse, 0, len(parts))
+    for _, part := range parts {
+        resp = append(resp, newPartResponse(part))
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) completeUpload(w http.ResponseWriter, r *http.Request, objectPath string) {
+    uploadID := r.URL.Query().Get("uploadId")
+    if !h.checkUpload(w, r, uploadID, objectPath) {
+        return
+    }
+
+    // An empty body completes the upload with every uploaded part.
+    var req completeRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+    parts := make([]store.CompletedPart, 0, len(req.Parts))
+    for _, p := range req.Parts {
+        parts = append(parts, store.CompletedPart{PartNumber: p.PartNumber, Hash: strings.Trim(p.ETag, `"`)})
+    }
+
+    metadata, checksum, err := h.store.CompleteUpload(r.Context(), uploadID, parts)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    etag, err := entityTag(r.Context(), h.store, metadata.BlobID)
+    if err != nil {
+        writeError(w, r, err, http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("ETag", etag)
+    writeJSON(w, http.StatusOK, completeResponse{
+        objectResponse: objectResponse{
+            ObjectID:  metadata.ObjectID,
+            Path:      metadata.ObjectPath,
+            VersionID: metadata.VersionID,
+            Size:      metadata.Size,
+            CreatedAt: metadata.CreatedAt,
+            UpdatedAt: metadata.UpdatedAt,
+        },
+        ETag:     etag,
+        Checksum: checksum,
+    })
+}
+
+func (h *Handler) abortUpload(w http.ResponseWriter, r *http.Request, objectPath string) {
+    uploadID := r.URL.Query().Get("uploadId")
+    if !h.checkUpload(w, r, uploadID, objectPath) {
+        return
+    }
+
+    if err := h.store.AbortUpload(r.Context(), uploadID); err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprintf(w, "Aborted upload %s", uploadID)
+}
+
+// checkUpload reports whether uploadID is an upload of the object at
+// objectPath, writing a not found response if it is not.
+func (h *Handler) checkUpload(w http.ResponseWriter, r *http.Request, uploadID, objectPath string) bool {
+    upload, err := h.store.GetUpload(r.Context(), uploadID)
+    if err == nil && upload.ObjectPath != objectPath {
+        err = fmt.Errorf("%w: %s", store.ErrUploadNotFound, uploadID)
+    }
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return false
+    }
+    return true
+}
+
+func newPartResponse(part *store.Part) partResponse {
+    return partResponse{
+        PartNumber: part.PartNumber,
+        ETag:       `"` + part.Hash + `"`,
+        Size:       part.Size,
+        CreatedAt:  part.CreatedAt,
+    }
+}
@@ -0,0 +1,180 @@
+// api/multipart_test.go
+package api
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestMultipartUpload(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    objectURL := fmt.Sprintf("%s/objects/%s", server.URL, url.QueryEscape("uploads/big.bin"))
+    req, _ := http.NewRequest(http.MethodPost, objectURL+"?uploads", nil)
+    req.Header.Set("Content-Type", "application/x-binary")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var upload uploadResponse
+    json.NewDecoder(resp.Body).Decode(&upload)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated || upload.UploadID == "" {
+        t.Fatalf("Expected upload to be initiated, got %d %+v", resp.StatusCode, upload)
+    }
+
+    uploadURL := objectURL + "?uploadId=" + upload.UploadID
+    var complete completeRequest
+    for i, content := range []string{"hello ", "multipart ", "world"} {
+        req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("%s&partNumber=%d", uploadURL, i+1), strings.NewReader(content))
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var part partResponse
+        json.NewDecoder(resp.Body).Decode(&part)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            t.Fatalf("Expected part %d to be uploaded, got %d", i+1, resp.StatusCode)
+        }
+        complete.Parts = append(complete.Parts, struct {
+            PartNumber int    `json:"part_number"`
+            ETag       string `json:"etag"`
+        }{part.PartNumber, part.ETag})
+    }
+
+    resp, err = http.Get(uploadURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var parts []partResponse
+    json.NewDecoder(resp.Body).Decode(&parts)
+    resp.Body.Close()
+    if len(parts) != 3 {
+        t.Errorf("Expected 3 parts, got %+v", parts)
+    }
+
+    // An upload is only reachable through the path it was initiated for.
+    resp, _ = http.Get(fmt.Sprintf("%s/objects/other?uploadId=%s", server.URL, upload.UploadID))
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Errorf("Expected status %d for another path, got %d", http.StatusNotFound, resp.StatusCode)
+    }
+
+    body, _ := json.Marshal(complete)
+    resp, err = http.Post(uploadURL, "application/json", bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var completed completeResponse
+    json.NewDecoder(resp.Body).Decode(&completed)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || completed.Size != int64(len("hello multipart world")) || !strings.HasSuffix(completed.Checksum, "-3") {
+        t.Errorf("Unexpected completion: %d %+v", resp.StatusCode, completed)
+    }
+
+    resp, err = http.Get(objectURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(data) != "hello multipart world" || resp.Header.Get("Content-Type") != "application/x-binary" {
+        t.Errorf("Unexpected assembled object: %q with Content-Type %q", data, resp.Header.Get("Content-Type"))
+    }
+    if resp.Header.Get("ETag") != completed.ETag {
+        t.Errorf("Expected ETag %s, got %s", completed.ETag, resp.Header.Get("ETag"))
+    }
+
+    // Aborting a finished upload reports it as unknown.
+    req, _ = http.NewRequest(http.MethodDelete, uploadURL, nil)
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+    }
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    ctx := context.Background()
+    client := newS3Client(server.URL)
+    bucket, key := aws.String("default"), aws.String("videos/clip.mp4")
+
+    created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: bucket, Key: key, ContentType: aws.String("video/mp4")})
+    if err != nil {
+        t.Fatalf("CreateMultipartUpload failed: %v", err)
+    }
+
+    var completed []types.CompletedPart
+    for i, content := range []string{strings.Repeat("a", 1024), strings.Repeat("b", 10)} {
+        part, err := client.UploadPart(ctx, &s3.UploadPartInput{
+            Bucket:     bucket,
+            Key:        key,
+            UploadId:   created.UploadId,
+            PartNumber: aws.Int32(int32(i + 1)),
+            Body:       strings.NewReader(content),
+        })
+        if err != nil {
+            t.Fatalf("UploadPart %d failed: %v", i+1, err)
+        }
+        completed = append(completed, types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(int32(i + 1))})
+    }
+
+    listed, err := client.ListParts(ctx, &s3.ListPartsInput{Bucket: bucket, Key: key, UploadId: created.UploadId})
+    if err != nil {
+        t.Fatalf("ListParts failed: %v", err)
+    }
+    if len(listed.Parts) != 2 {
+        t.Errorf("Expected 2 parts, got %d", len(listed.Parts))
+    }
+
+    _, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+        Bucket:          bucket,
+        Key:             key,
+        UploadId:        created.UploadId,
+        MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+    })
+    if err != nil {
+        t.Fatalf("CompleteMultipartUpload failed: %v", err)
+    }
+
+    get, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: key, Range: aws.String("bytes=1020-1027")})
+    if err != nil {
+        t.Fatalf("GetObject failed: %v", err)
+    }
+    data, _ := io.ReadAll(get.Body)
+    get.Body.Close()
+    if string(data) != "aaaabbbb" || aws.ToString(get.ContentType) != "video/mp4" {
+        t.Errorf("Unexpected range of assembled object: %q with Content-Type %q", data, aws.ToString(get.ContentType))
+    }
+
+    aborted, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: bucket, Key: key})
+    if err != nil {
+        t.Fatalf("CreateMultipartUpload failed: %v", err)
+    }
+    if _, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: bucket, Key: key, UploadId: aborted.UploadId}); err != nil {
+        t.Fatalf("AbortMultipartUpload failed: %v", err)
+    }
+    _, err = client.ListParts(ctx, &s3.ListPartsInput{Bucket: bucket, Key: key, UploadId: aborted.UploadId})
+    if err == nil || !strings.Contains(err.Error(), "NoSuchUpload") {
+        t.Errorf("Expected NoSuchUpload after abort, got %v", err)
+    }
+}
@@ -0,0 +1,239 @@
+// api/policy.go
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// authorize wraps next so that requests on objects signed with an API key
+// are only served if the policies of the key and the bucket allow them, and
+// requests that create, change or delete buckets only if the key is an
+// admin key. Other requests, and requests that are not authenticated, go
+// through.
+func authorize(s *store.Store, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        key := requestKey(r)
+        if key != nil && !key.Admin && managesBuckets(r) {
+            writeAuthError(w, r, accessDenied("AccessDenied", "API key %s is not an admin key", key.AccessKeyID))
+            return
+        }
+        access, ok := objectAccess(s, r)
+        if key == nil || !ok {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        access.AccessKeyID, access.Admin = key.AccessKeyID, key.Admin
+        decision, err := s.Authorize(r.Context(), access)
+        if err != nil {
+            writeAuthError(w, r, &authError{status: http.StatusInternalServerError, code: "InternalError", message: err.Error()})
+            return
+        }
+        if !decision.Allowed {
+            writeAuthError(w, r, accessDenied("AccessDenied", "%s %s: %s", access.Action, access.Path, decision.Reason))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// objectAccess describes the access r makes to objects, in the terms
+// policies are written in. It reports false for requests that do not act on
+// objects. Objects addressed by ID are authorized by their path.
+func objectAccess(s *store.Store, r *http.Request) (store.AccessRequest, bool) {
+    query := r.URL.Query()
+    if strings.HasPrefix(r.URL.Path+"/", s3Prefix) {
+        bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, s3Prefix), "/")
+        switch {
+        case bucket == "":
+            return store.AccessRequest{}, false
+        case key != "":
+            return store.AccessRequest{Bucket: bucket, Path: key, Action: objectAction(r)}, true
+        case r.Method == http.MethodGet && !query.Has("location"):
+            return store.AccessRequest{Bucket: bucket, Path: query.Get("prefix"), Action: store.ActionList}, true
+        }
+        return store.AccessRequest{}, false
+    }
+
+    bucket, rest := store.DefaultBucket, strings.TrimPrefix(r.URL.Path, "/")
+    if nested, ok := strings.CutPrefix(r.URL.Path, "/buckets/"); ok {
+        if bucket, rest, ok = strings.Cut(nested, "/"); !ok {
+            return store.AccessRequest{}, false
+        }
+    }
+
+    switch {
+    case rest == "objects" && r.Method == http.MethodGet:
+        return store.AccessRequest{Bucket: bucket, Path: query.Get("prefix"), Action: store.ActionList}, true
+    case rest == "objects":
+        return store.AccessRequest{Bucket: bucket, Path: query.Get("path"), Action: store.ActionWrite}, true
+    case rest == "changes":
+        return store.AccessRequest{Bucket: bucket, Path: query.Get("prefix"), Action: store.ActionList}, true
+    case strings.HasPrefix(rest, "objects/"):
+        objectPath := strings.TrimPrefix(rest, "objects/")
+        if metadata, err := s.InBucket(bucket).StatObject(r.Context(), objectPath); err == nil {
+            objectPath = metadata.ObjectPath
+        }
+        return store.AccessRequest{Bucket: bucket, Path: objectPath, Action: objectAction(r)}, true
+    }
+    return store.AccessRequest{}, false
+}
+
+// managesBuckets reports whether r creates, changes or deletes a bucket.
+// Bucket settings such as lifecycle rules act on every object in the bucket,
+// so they are not left to object policies.
+func managesBuckets(r *http.Request) bool {
+    if r.Method == http.MethodGet || r.Method == http.MethodHead {
+        return false
+    }
+    if r.URL.Path == "/buckets" {
+        return true
+    }
+    if bucket, ok := strings.CutPrefix(r.URL.Path, "/buckets/"); ok {
+        return !strings.Contains(bucket, "/")
+    }
+    if rest, ok := strings.CutPrefix(r.URL.Path, s3Prefix); ok {
+        bucket, key, _ := strings.Cut(rest, "/")
+        return bucket != "" && key == ""
+    }
+    return false
+}
+
+// objectAction returns the action a request on an object performs.
+// Multipart uploads and restores write the object whatever their method.
+func objectAction(r *http.Request) string {
+    query := r.URL.Query()
+    switch {
+    case query.Has("uploadId"), query.Has("uploads"), query.Has("restore"):
+        return store.ActionWrite
+    case r.Method == http.MethodGet, r.Method == http.MethodHead:
+        return store.ActionRead
+    case r.Method == http.MethodDelete:
+        return store.ActionDelete
+    default:
+        return store.ActionWrite
+    }
+}
+
+// servePolicy serves the policy attached to an API key or a bucket: GET
+// returns it, PUT replaces it and DELETE detaches it.
+func (h *Handler) servePolicy(w http.ResponseWriter, r *http.Request, kind, subject string) {
+    switch r.Method {
+    case http.MethodGet:
+        policy, err := h.store.GetPolicy(r.Context(), kind, subject)
+        if err != nil {
+            writeError(w, r, err, statusForError(err))
+            return
+        }
+        writeJSON(w, http.StatusOK, policy)
+    case http.MethodPut:
+        var policy store.Policy
+        if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+            http.Error(w, "Invalid policy JSON: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err := h.store.PutPolicy(r.Context(), kind, subject, &policy); err != nil {
+            writeError(w, r, err, statusForError(err))
+            return
+        }
+        writeJSON(w, http.StatusOK, &policy)
+    case http.MethodDelete:
+        if err := h.store.DeletePolicy(r.Context(), kind, subject); err != nil {
+            writeError(w, r, err, statusForError(err))
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// handleBucketPolicy serves /admin/buckets/{bucket}/policy.
+func (h *Handler) handleBucketPolicy(w http.ResponseWriter, r *http.Request) {
+    bucket, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, adminPrefix+"buckets/"), "/")
+    if rest != "policy" {
+        http.NotFound(w, r)
+        return
+    }
+    h.servePolicy(w, r, store.PolicyBucket, bucket)
+}
+
+type explainRequest struct {
+    AccessKeyID string `json:"access_key_id"`
+    Method      string `json:"method"`
+    // URL is the path and query of the request to explain.
+    URL string `json:"url"`
+}
+
+type explainResponse struct {
+    Allowed bool                 `json:"allowed"`
+    Reason  string               `json:"reason"`
+    Bucket  string               `json:"bucket"`
+    Path    string               `json:"path"`
+    Action  string               `json:"action"`
+    Rule    *matchedRuleResponse `json:"rule,omitempty"`
+}
+
+type matchedRuleResponse struct {
+    Kind      string          `json:"kind"`
+    Subject   string          `json:"subject"`
+    Index     int             `json:"index"`
+    Statement store.Statement `json:"statement"`
+}
+
+// handleExplain serves /admin/policies/explain, which POST evaluates the
+// policies for a request as if it had been signed with the given key,
+// without making it, and reports which statement decided.
+func (h *Handler) handleExplain(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    var req explainRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid explain JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    key, err := h.store.GetAPIKey(r.Context(), req.AccessKeyID)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    explained, err := http.NewRequest(req.Method, req.URL, nil)
+    if err != nil {
+        http.Error(w, "Invalid request to explain: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    access, ok := objectAccess(h.store, explained)
+    if !ok {
+        http.Error(w, "The request does not act on objects", http.StatusBadRequest)
+        return
+    }
+
+    access.AccessKeyID, access.Admin = key.AccessKeyID, key.Admin
+    decision, err := h.store.Authorize(r.Context(), access)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := explainResponse{
+        Allowed: decision.Allowed,
+        Reason:  decision.Reason,
+        Bucket:  access.Bucket,
+        Path:    access.Path,
+        Action:  access.Action,
+    }
+    if key.Disabled {
+        resp.Allowed, resp.Reason = false, "the key is disabled"
+    }
+    if rule := decision.Rule; rule != nil {
+        resp.Rule = &matchedRuleResponse{Kind: rule.Kind, Subject: rule.Subject, Index: rule.Index, Statement: rule.Statement}
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
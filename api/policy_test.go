@@ -0,0 +1,111 @@
+// api/policy_test.go
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+func TestPolicies(t *testing.T) {
+    ctx := t.Context()
+    server, s, admin := setupAuthTestServer(t)
+    now := time.Now()
+
+    user, err := s.CreateAPIKey(ctx, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    secretID, err := s.CreateObject(ctx, "public/secret.txt", []byte("secret"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    policyURL := server.URL + "/admin/keys/" + user.AccessKeyID + "/policy"
+    if status, _ := doRequest(t, signedRequest(t, http.MethodGet, policyURL, "", admin, now)); status != http.StatusNotFound {
+        t.Errorf("Expected a key without a policy to get %d, got %d", http.StatusNotFound, status)
+    }
+    if status, _ := doRequest(t, signedRequest(t, http.MethodPut, policyURL, `{"statements":[{"effect":"perhaps"}]}`, admin, now)); status != http.StatusBadRequest {
+        t.Errorf("Expected an invalid policy to get %d, got %d", http.StatusBadRequest, status)
+    }
+    policy := `{"statements":[
+        {"effect":"allow","actions":["read","write"],"paths":["public/**"]},
+        {"effect":"deny","actions":["*"],"paths":["public/secret*"]}
+    ]}`
+    status, body := doRequest(t, signedRequest(t, http.MethodPut, policyURL, policy, admin, now))
+    if status != http.StatusOK {
+        t.Fatalf("Expected attaching a policy to get %d, got %d: %s", http.StatusOK, status, body)
+    }
+    status, body = doRequest(t, signedRequest(t, http.MethodGet, policyURL, "", admin, now))
+    if status != http.StatusOK || !strings.Contains(body, "public/secret*") {
+        t.Errorf("Expected the attached policy, got %d: %s", status, body)
+    }
+
+    tests := []struct {
+        method, url string
+        want        int
+    }{
+        {http.MethodPost, "/objects?path=public/a.txt", http.StatusCreated},
+        {http.MethodGet, "/objects/public/a.txt", http.StatusOK},
+        {http.MethodDelete, "/objects/public/a.txt", http.StatusForbidden},
+        {http.MethodPost, "/objects?path=private/a.txt", http.StatusForbidden},
+        {http.MethodGet, "/objects/public/secret.txt", http.StatusForbidden},
+        // An object addressed by ID is authorized by its path.
+        {http.MethodGet, "/objects/" + secretID, http.StatusForbidden},
+        {http.MethodGet, "/objects?prefix=public/", http.StatusForbidden},
+        {http.MethodGet, "/s3/default/public/a.txt", http.StatusOK},
+        {http.MethodGet, "/s3/default/public/secret.txt", http.StatusForbidden},
+        // Only admin keys manage buckets.
+        {http.MethodGet, "/buckets", http.StatusOK},
+        {http.MethodPost, "/buckets", http.StatusForbidden},
+        {http.MethodPut, "/buckets/default", http.StatusForbidden},
+        {http.MethodDelete, "/buckets/default", http.StatusForbidden},
+        {http.MethodPut, "/s3/logs", http.StatusForbidden},
+        {http.MethodDelete, "/s3/default", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        status, body := doRequest(t, signedRequest(t, tt.method, server.URL+tt.url, "content", user, now))
+        if status != tt.want {
+            t.Errorf("%s %s: expected %d, got %d: %s", tt.method, tt.url, tt.want, status, body)
+        }
+    }
+    if status, body := doRequest(t, signedRequest(t, http.MethodPut, server.URL+"/s3/logs", "", admin, now)); status != http.StatusOK {
+        t.Errorf("Expected an admin key to create a bucket, got %d: %s", status, body)
+    }
+    _, body = doRequest(t, signedRequest(t, http.MethodGet, server.URL+"/s3/default/public/secret.txt", "", user, now))
+    if !strings.Contains(body, "<Code>AccessDenied</Code>") {
+        t.Errorf("Expected an S3 AccessDenied error, got %s", body)
+    }
+
+    // A bucket policy applies to every key, admin keys included.
+    bucketPolicyURL := server.URL + "/admin/buckets/default/policy"
+    status, body = doRequest(t, signedRequest(t, http.MethodPut, bucketPolicyURL, `{"statements":[{"effect":"deny","actions":["delete"],"paths":["public/**"]}]}`, admin, now))
+    if status != http.StatusOK {
+        t.Fatalf("Expected attaching a bucket policy to get %d, got %d: %s", http.StatusOK, status, body)
+    }
+    if status, _ := doRequest(t, signedRequest(t, http.MethodDelete, server.URL+"/objects/public/a.txt", "", admin, now)); status != http.StatusForbidden {
+        t.Errorf("Expected the bucket policy to deny the admin key, got %d", status)
+    }
+
+    explain := `{"access_key_id":"` + user.AccessKeyID + `","method":"GET","url":"/objects/public/secret.txt"}`
+    status, body = doRequest(t, signedRequest(t, http.MethodPost, server.URL+"/admin/policies/explain", explain, admin, now))
+    if status != http.StatusOK {
+        t.Fatalf("Expected explain to get %d, got %d: %s", http.StatusOK, status, body)
+    }
+    var explained explainResponse
+    json.Unmarshal([]byte(body), &explained)
+    if explained.Allowed || explained.Rule == nil || explained.Rule.Kind != store.PolicyKey || explained.Rule.Index != 1 {
+        t.Errorf("Expected the key policy's deny statement to decide, got %+v", explained)
+    }
+
+    if status, _ := doRequest(t, signedRequest(t, http.MethodDelete, bucketPolicyURL, "", admin, now)); status != http.StatusNoContent {
+        t.Errorf("Expected detaching the bucket policy to get %d, got %d", http.StatusNoContent, status)
+    }
+    if status, _ := doRequest(t, signedRequest(t, http.MethodDelete, server.URL+"/objects/public/a.txt", "", admin, now)); status != http.StatusNoContent && status != http.StatusOK {
+        t.Errorf("Expected the admin key to delete once the bucket policy is detached, got %d", status)
+    }
+}
@@ -0,0 +1,199 @@
+// api/presign.go
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// Query parameters of presigned URLs. The method a URL allows is part of
+// its signature rather than of its query.
+const (
+    presignExpiresParam       = "X-Presign-Expires"
+    presignContentLengthParam = "X-Presign-Content-Length"
+    presignContentTypeParam   = "X-Presign-Content-Type"
+    presignSignatureParam     = "X-Presign-Signature"
+)
+
+// PresignURL returns a URL under base that allows the request p describes
+// without credentials until p.Expires. An empty bucket is the default
+// bucket.
+func PresignURL(s *store.Store, base string, p store.Presign) (string, error) {
+    if p.Bucket == "" {
+        p.Bucket = store.DefaultBucket
+    }
+    signature, err := s.SignPresign(p)
+    if err != nil {
+        return "", err
+    }
+
+    u, err := url.Parse(base)
+    if err != nil {
+        return "", err
+    }
+    route := "/objects/" + p.Path
+    if p.Bucket != store.DefaultBucket {
+        route = "/buckets/" + p.Bucket + route
+    }
+    u.Path = strings.TrimSuffix(u.Path, "/") + route
+    query := url.Values{}
+    query.Set(presignExpiresParam, strconv.FormatInt(p.Expires.Unix(), 10))
+    if p.ContentLength > 0 {
+        query.Set(presignContentLengthParam, strconv.FormatInt(p.ContentLength, 10))
+    }
+    if p.ContentType != "" {
+        query.Set(presignContentTypeParam, p.ContentType)
+    }
+    query.Set(presignSignatureParam, signature)
+    u.RawQuery = query.Encode()
+    return u.String(), nil
+}
+
+// isPresigned reports whether r was made with a presigned URL.
+func isPresigned(r *http.Request) bool {
+    return r.URL.Query().Has(presignSignatureParam)
+}
+
+// presignedObject returns the bucket and object path a request to a
+// presigned URL addresses, or false if it does not address an object.
+func presignedObject(r *http.Request) (bucket, objectPath string, ok bool) {
+    if objectPath, ok := strings.CutPrefix(r.URL.Path, "/objects/"); ok {
+        return store.DefaultBucket, objectPath, true
+    }
+    nested, ok := strings.CutPrefix(r.URL.Path, "/buckets/")
+    if !ok {
+        return "", "", false
+    }
+    bucket, rest, _ := strings.Cut(nested, "/")
+    objectPath, ok = strings.CutPrefix(rest, "objects/")
+    return bucket, objectPath, ok
+}
+
+// servePresigned serves a request made with a presigned URL, which may
+// only read or update the object it was signed for.
+func (h *Handler) servePresigned(w http.ResponseWriter, r *http.Request, objectPath string) {
+    bucket, _, _ := presignedObject(r)
+    if err := h.verifyPresign(r, bucket, objectPath); err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+        h.getObject(w, r, objectPath)
+    case http.MethodHead:
+        h.headObject(w, r, objectPath)
+    case http.MethodPut:
+        h.updateObject(w, r, objectPath)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// verifyPresign checks that the signature of r's presigned URL allows r,
+// including the content constraints the URL was signed with.
+func (h *Handler) verifyPresign(r *http.Request, bucket, objectPath string) error {
+    query := r.URL.Query()
+    for name := range query {
+        switch name {
+        case presignExpiresParam, presignContentLengthParam, presignContentTypeParam, presignSignatureParam:
+        default:
+            return fmt.Errorf("%w: unexpected query parameter %s", store.ErrPresignMismatch, name)
+        }
+    }
+
+    p := store.Presign{
+        Method:      r.Method,
+        Bucket:      bucket,
+        Path:        objectPath,
+        ContentType: query.Get(presignContentTypeParam),
+    }
+    expires, err := strconv.ParseInt(query.Get(presignExpiresParam), 10, 64)
+    if err != nil {
+        return fmt.Errorf("%w: invalid %s", store.ErrPresignMismatch, presignExpiresParam)
+    }
+    p.Expires = time.Unix(expires, 0)
+    if v := query.Get(presignContentLengthParam); v != "" {
+        if p.ContentLength, err = strconv.ParseInt(v, 10, 64); err != nil {
+            return fmt.Errorf("%w: invalid %s", store.ErrPresignMismatch, presignContentLengthParam)
+        }
+    }
+    if err := h.store.VerifyPresign(p, query.Get(presignSignatureParam)); err != nil {
+        return err
+    }
+
+    if p.ContentLength > 0 && r.ContentLength != p.ContentLength {
+        return fmt.Errorf("%w: the content must be %d bytes long", store.ErrPresignMismatch, p.ContentLength)
+    }
+    if p.ContentType != "" && r.Header.Get("Content-Type") != p.ContentType {
+        return fmt.Errorf("%w: the content type must be %s", store.ErrPresignMismatch, p.ContentType)
+    }
+    return nil
+}
+
+type presignRequest struct {
+    Method string `json:"method"`
+    Bucket string `json:"bucket"`
+    Path   string `json:"path"`
+    // ExpiresSeconds is how long the URL is valid. Zero means
+    // store.DefaultPresignExpiry.
+    ExpiresSeconds int    `json:"expires_seconds"`
+    ContentLength  int64  `json:"content_length"`
+    ContentType    string `json:"content_type"`
+}
+
+type presignResponse struct {
+    URL       string    `json:"url"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handlePresign serves /admin/presign, which POST returns a presigned URL
+// on the host the request was made to.
+func (h *Handler) handlePresign(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    var req presignRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid presign JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    expiry := store.DefaultPresignExpiry
+    if req.ExpiresSeconds != 0 {
+        expiry = time.Duration(req.ExpiresSeconds) * time.Second
+    }
+    p := store.Presign{
+        Method:        strings.ToUpper(req.Method),
+        Bucket:        req.Bucket,
+        Path:          req.Path,
+        Expires:       time.Now().Add(expiry).Truncate(time.Second),
+        ContentLength: req.ContentLength,
+        ContentType:   req.ContentType,
+    }
+    if p.Bucket != "" {
+        if _, err := h.store.GetBucket(r.Context(), p.Bucket); err != nil {
+            writeError(w, r, err, statusForError(err))
+            return
+        }
+    }
+
+    scheme := "http"
+    if r.TLS != nil {
+        scheme = "https"
+    }
+    presigned, err := PresignURL(h.store, scheme+"://"+r.Host, p)
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, presignResponse{URL: presigned, ExpiresAt: p.Expires})
+}
@@ -0,0 +1,127 @@
+// api/presign_test.go
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+func TestPresignedURLs(t *testing.T) {
+    ctx := t.Context()
+    server, s, admin := setupAuthTestServer(t)
+    now := time.Now()
+
+    if _, err := s.CreateObject(ctx, "shared/report.txt", []byte("report")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.CreateObject(ctx, "uploads/artifact.bin", []byte("old")); err != nil {
+        t.Fatal(err)
+    }
+
+    presign := func(body string) string {
+        t.Helper()
+        status, resp := doRequest(t, signedRequest(t, http.MethodPost, server.URL+"/admin/presign", body, admin, now))
+        if status != http.StatusOK {
+            t.Fatalf("Expected presigning to get %d, got %d: %s", http.StatusOK, status, resp)
+        }
+        var presigned presignResponse
+        json.Unmarshal([]byte(resp), &presigned)
+        return presigned.URL
+    }
+
+    getURL := presign(`{"method":"GET","path":"shared/report.txt","expires_seconds":60}`)
+    if !strings.HasPrefix(getURL, server.URL+"/objects/shared/report.txt?") {
+        t.Errorf("Unexpected presigned URL %s", getURL)
+    }
+    resp, err := http.Get(getURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("Expected a presigned GET without credentials to succeed, got %d", resp.StatusCode)
+    }
+    if resp, err := http.Head(getURL); err != nil || resp.StatusCode != http.StatusOK {
+        t.Errorf("Expected a presigned GET URL to allow HEAD, got %v %v", resp, err)
+    }
+
+    // The URL allows nothing but the request it was signed for.
+    refused := []struct {
+        method, url string
+    }{
+        {http.MethodPut, getURL},
+        {http.MethodDelete, getURL},
+        {http.MethodGet, strings.Replace(getURL, "report.txt", "other.txt", 1)},
+        {http.MethodGet, getURL + "&versions"},
+        {http.MethodGet, strings.Replace(getURL, presignExpiresParam+"=", presignExpiresParam+"=1", 1)},
+    }
+    for _, tt := range refused {
+        req, _ := http.NewRequest(tt.method, tt.url, nil)
+        if status, body := doRequest(t, req); status != http.StatusForbidden {
+            t.Errorf("%s %s: expected %d, got %d: %s", tt.method, tt.url, http.StatusForbidden, status, body)
+        }
+    }
+    // Presigned parameters do not get past authentication elsewhere.
+    if status, _ := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/admin/keys?"+presignSignatureParam+"=x", "")); status != http.StatusForbidden {
+        t.Errorf("Expected a presign parameter not to bypass authentication, got %d", status)
+    }
+
+    putURL := presign(`{"method":"PUT","path":"uploads/artifact.bin","content_length":3,"content_type":"application/octet-stream"}`)
+    req := mustRequest(t, http.MethodPut, putURL, "too long")
+    req.Header.Set("Content-Type", "application/octet-stream")
+    if status, body := doRequest(t, req); status != http.StatusForbidden || !strings.Contains(body, "3 bytes") {
+        t.Errorf("Expected the content length to be enforced, got %d: %s", status, body)
+    }
+    req = mustRequest(t, http.MethodPut, putURL, "new")
+    req.Header.Set("Content-Type", "text/plain")
+    if status, _ := doRequest(t, req); status != http.StatusForbidden {
+        t.Errorf("Expected the content type to be enforced, got %d", status)
+    }
+    req = mustRequest(t, http.MethodPut, putURL, "new")
+    req.Header.Set("Content-Type", "application/octet-stream")
+    if status, body := doRequest(t, req); status != http.StatusOK {
+        t.Fatalf("Expected the presigned PUT to succeed, got %d: %s", status, body)
+    }
+    if data, _ := s.ReadObject(ctx, "uploads/artifact.bin"); string(data) != "new" {
+        t.Errorf("Expected the object to be updated, got %q", data)
+    }
+
+    // URLs for other buckets address the bucket's routes.
+    if err := s.CreateBucket(ctx, &store.Bucket{Name: "builds"}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.InBucket("builds").CreateObject(ctx, "log.txt", []byte("log")); err != nil {
+        t.Fatal(err)
+    }
+    bucketURL := presign(`{"method":"GET","bucket":"builds","path":"log.txt"}`)
+    u, _ := url.Parse(bucketURL)
+    if u.Path != "/buckets/builds/objects/log.txt" {
+        t.Errorf("Unexpected presigned URL %s", bucketURL)
+    }
+    if status, body := doRequest(t, mustRequest(t, http.MethodGet, bucketURL, "")); status != http.StatusOK || body != "log" {
+        t.Errorf("Expected the presigned GET to read the bucket's object, got %d: %s", status, body)
+    }
+
+    for _, body := range []string{
+        `{"method":"DELETE","path":"a.txt"}`,
+        `{"method":"GET","path":"a.txt","expires_seconds":99999999}`,
+    } {
+        if status, _ := doRequest(t, signedRequest(t, http.MethodPost, server.URL+"/admin/presign", body, admin, now)); status != http.StatusBadRequest {
+            t.Errorf("Expected %s to be refused, got %d", body, status)
+        }
+    }
+}
+
+func mustRequest(t *testing.T, method, url, body string) *http.Request {
+    req, err := http.NewRequest(method, url, strings.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return req
+}
@@ -0,0 +1,232 @@
+// api/retention.go
+package api
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+// Object lock headers, as defined by S3. Both APIs accept them; S3
+// responses describe an object's lock with them.
+const (
+    lockModeHeader         = "X-Amz-Object-Lock-Mode"
+    lockRetainUntilHeader  = "X-Amz-Object-Lock-Retain-Until-Date"
+    lockLegalHoldHeader    = "X-Amz-Object-Lock-Legal-Hold"
+    bypassGovernanceHeader = "X-Amz-Bypass-Governance-Retention"
+)
+
+// Legal hold statuses, as S3 spells them.
+const (
+    legalHoldOn  = "ON"
+    legalHoldOff = "OFF"
+)
+
+// lockOptions returns the retention and legal hold a request asks a new
+// object to be locked with.
+func lockOptions(r *http.Request) (store.Retention, bool, error) {
+    var retention store.Retention
+    mode, until := r.Header.Get(lockModeHeader), r.Header.Get(lockRetainUntilHeader)
+    if mode != "" || until != "" {
+        t, err := time.Parse(time.RFC3339, until)
+        if err != nil {
+            return retention, false, fmt.Errorf("%w: %s must be an RFC 3339 date", store.ErrInvalidRetention, lockRetainUntilHeader)
+        }
+        retention = store.Retention{Mode: strings.ToLower(mode), RetainUntil: t}
+    }
+    hold, err := parseLegalHold(r.Header.Get(lockLegalHoldHeader))
+    return retention, hold, err
+}
+
+func parseLegalHold(status string) (bool, error) {
+    switch strings.ToUpper(status) {
+    case "", legalHoldOff:
+        return false, nil
+    case legalHoldOn:
+        return true, nil
+    }
+    return false, fmt.Errorf("%w: legal hold must be %s or %s", store.ErrInvalidRetention, legalHoldOn, legalHoldOff)
+}
+
+// bypassGovernance reports whether a request asks to bypass governance
+// retention and is allowed to, see mayWeakenLocks.
+func bypassGovernance(r *http.Request) bool {
+    return strings.EqualFold(r.Header.Get(bypassGovernanceHeader), "true") && mayWeakenLocks(r)
+}
+
+// mayWeakenLocks reports whether a request may bypass governance retention
+// or release a legal hold: only admin keys may, or every request when
+// authentication is disabled. Presigned requests never may.
+func mayWeakenLocks(r *http.Request) bool {
+    if isPresigned(r) {
+        return false
+    }
+    key := requestKey(r)
+    return key == nil || key.Admin
+}
+
+// writeLockHeaders describes the retention and legal hold of an object.
+func writeLockHeaders(w http.ResponseWriter, metadata *store.Metadata) {
+    if metadata.Retention.Mode != "" {
+        w.Header().Set(lockModeHeader, strings.ToUpper(metadata.Retention.Mode))
+        w.Header().Set(lockRetainUntilHeader, metadata.Retention.RetainUntil.UTC().Format(time.RFC3339))
+    }
+    if metadata.LegalHold {
+        w.Header().Set(lockLegalHoldHeader, legalHoldOn)
+    }
+}
+
+type retentionBody struct {
+    // Mode is "governance" or "compliance", or empty for no retention.
+    Mode        string    `json:"mode,omitempty"`
+    RetainUntil time.Time `json:"retain_until,omitzero"`
+}
+
+type legalHoldBody struct {
+    LegalHold bool `json:"legal_hold"`
+}
+
+// handleRetention serves the retention of an object: GET returns it and PUT
+// replaces it.
+func (h *Handler) handleRetention(w http.ResponseWriter, r *http.Request, objectPath string) {
+    var metadata *store.Metadata
+    var err error
+    switch r.Method {
+    case http.MethodGet:
+        metadata, err = h.store.StatObject(r.Context(), objectPath)
+    case http.MethodPut:
+        var req retentionBody
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+            return
+        }
+        retention := store.Retention{Mode: req.Mode, RetainUntil: req.RetainUntil}
+        metadata, err = h.store.SetRetention(r.Context(), objectPath, retention, bypassGovernance(r))
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, retentionBody{Mode: metadata.Retention.Mode, RetainUntil: metadata.Retention.RetainUntil})
+}
+
+// handleLegalHold serves the legal hold of an object: GET returns it and PUT
+// places or releases it. Only requests that may bypass governance retention
+// can release a hold.
+func (h *Handler) handleLegalHold(w http.ResponseWriter, r *http.Request, objectPath string) {
+    var metadata *store.Metadata
+    var err error
+    switch r.Method {
+    case http.MethodGet:
+        metadata, err = h.store.StatObject(r.Context(), objectPath)
+    case http.MethodPut:
+        var req legalHoldBody
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+            return
+        }
+        metadata, err = h.store.SetLegalHold(r.Context(), objectPath, req.LegalHold, mayWeakenLocks(r))
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, legalHoldBody{LegalHold: metadata.LegalHold})
+}
+
+type s3Retention struct {
+    XMLName         xml.Name `xml:"Retention"`
+    Xmlns           string   `xml:"xmlns,attr,omitempty"`
+    Mode            string   `xml:"Mode,omitempty"`
+    RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+type s3LegalHold struct {
+    XMLName xml.Name `xml:"LegalHold"`
+    Xmlns   string   `xml:"xmlns,attr,omitempty"`
+    Status  string   `xml:"Status"`
+}
+
+// serveRetention serves GetObjectRetention and PutObjectRetention.
+func (h *S3Handler) serveRetention(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    metadata, ok := h.statObject(w, r, bucket, key)
+    if !ok {
+        return
+    }
+
+    if r.Method == http.MethodPut {
+        var req s3Retention
+        if err := xml.NewDecoder(s3Body(r)).Decode(&req); err != nil {
+            writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+            return
+        }
+        var retention store.Retention
+        if req.Mode != "" || req.RetainUntilDate != "" {
+            until, err := time.Parse(time.RFC3339, req.RetainUntilDate)
+            if err != nil {
+                writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "RetainUntilDate must be an ISO 8601 date.")
+                return
+            }
+            retention = store.Retention{Mode: strings.ToLower(req.Mode), RetainUntil: until}
+        }
+        if _, err := h.store.InBucket(bucket).SetRetention(r.Context(), metadata.ObjectID, retention, bypassGovernance(r)); err != nil {
+            writeS3StoreError(w, r, err)
+            return
+        }
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+
+    if metadata.Retention.Mode == "" {
+        writeS3Error(w, r, http.StatusNotFound, "NoSuchObjectLockConfiguration", "The specified object does not have a retention configuration.")
+        return
+    }
+    writeS3XML(w, http.StatusOK, s3Retention{
+        Xmlns:           s3Namespace,
+        Mode:            strings.ToUpper(metadata.Retention.Mode),
+        RetainUntilDate: metadata.Retention.RetainUntil.UTC().Format(time.RFC3339),
+    })
+}
+
+// serveLegalHold serves GetObjectLegalHold and PutObjectLegalHold.
+func (h *S3Handler) serveLegalHold(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    metadata, ok := h.statObject(w, r, bucket, key)
+    if !ok {
+        return
+    }
+
+    if r.Method == http.MethodPut {
+        var req s3LegalHold
+        if err := xml.NewDecoder(s3Body(r)).Decode(&req); err != nil {
+            writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+            return
+        }
+        hold, err := parseLegalHold(req.Status)
+        if err == nil {
+            _, err = h.store.InBucket(bucket).SetLegalHold(r.Context(), metadata.ObjectID, hold, mayWeakenLocks(r))
+        }
+        if err != nil {
+            writeS3StoreError(w, r, err)
+            return
+        }
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+
+    status := legalHoldOff
+    if metadata.LegalHold {
+        status = legalHoldOn
+    }
+    writeS3XML(w, http.StatusOK, s3LegalHold{Xmlns: s3Namespace, Status: status})
+}
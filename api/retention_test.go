@@ -0,0 +1,173 @@
+// api/retention_test.go
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+    "github.com/aws/smithy-go"
+    "github.com/corylehan/object-store/store"
+)
+
+func TestRetentionRoutes(t *testing.T) {
+    ctx := t.Context()
+    server, s, admin := setupAuthTestServer(t)
+    now := time.Now()
+
+    user, err := s.CreateAPIKey(ctx, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    everything := &store.Policy{Statements: []store.Statement{{Effect: store.EffectAllow, Actions: []string{"*"}, Paths: []string{"**"}}}}
+    if err := s.PutPolicy(ctx, store.PolicyKey, user.AccessKeyID, everything); err != nil {
+        t.Fatal(err)
+    }
+    do := func(method, path, body string, key *store.APIKey, bypass bool) (int, string) {
+        t.Helper()
+        req := signedRequest(t, method, server.URL+path, body, key, now)
+        if bypass {
+            req.Header.Set(bypassGovernanceHeader, "true")
+        }
+        return doRequest(t, req)
+    }
+
+    until := now.Add(time.Hour).UTC().Truncate(time.Second)
+    req := signedRequest(t, http.MethodPost, server.URL+"/objects?path=locked.txt", "v1", user, now)
+    req.Header.Set(lockModeHeader, "GOVERNANCE")
+    req.Header.Set(lockRetainUntilHeader, until.Format(time.RFC3339))
+    if status, body := doRequest(t, req); status != http.StatusCreated {
+        t.Fatalf("Expected %d creating, got %d: %s", http.StatusCreated, status, body)
+    }
+
+    status, body := do(http.MethodGet, "/objects/locked.txt?retention", "", user, false)
+    var retention retentionBody
+    json.Unmarshal([]byte(body), &retention)
+    if status != http.StatusOK || retention.Mode != store.RetentionGovernance || !retention.RetainUntil.Equal(until) {
+        t.Errorf("Unexpected retention %d %s", status, body)
+    }
+
+    // Only admin keys bypass governance retention.
+    if status, _ := do(http.MethodDelete, "/objects/locked.txt", "", user, false); status != http.StatusConflict {
+        t.Errorf("Expected deleting a retained object to get %d, got %d", http.StatusConflict, status)
+    }
+    if status, _ := do(http.MethodPut, "/objects/locked.txt", "v2", user, true); status != http.StatusConflict {
+        t.Errorf("Expected a non-admin key not to bypass governance, got %d", status)
+    }
+    if status, body := do(http.MethodPut, "/objects/locked.txt", "v2", admin, true); status != http.StatusOK {
+        t.Errorf("Expected an admin key to bypass governance, got %d: %s", status, body)
+    }
+
+    if status, body := do(http.MethodPut, "/objects/locked.txt?legal-hold", `{"legal_hold":true}`, user, false); status != http.StatusOK || !strings.Contains(body, `"legal_hold":true`) {
+        t.Errorf("Unexpected legal hold response %d %s", status, body)
+    }
+    if status, _ := do(http.MethodDelete, "/objects/locked.txt", "", admin, true); status != http.StatusConflict {
+        t.Errorf("Expected legal hold to refuse even a bypassing delete, got %d", status)
+    }
+    if status, body := do(http.MethodGet, "/objects/locked.txt?legal-hold", "", user, false); status != http.StatusOK || !strings.Contains(body, `"legal_hold":true`) {
+        t.Errorf("Unexpected legal hold %d %s", status, body)
+    }
+
+    if status, _ := do(http.MethodPut, "/objects/locked.txt?retention", `{}`, user, true); status != http.StatusConflict {
+        t.Errorf("Expected a non-admin key not to lift governance retention, got %d", status)
+    }
+    if status, body := do(http.MethodPut, "/objects/locked.txt?retention", `{}`, admin, true); status != http.StatusOK || body != "{}\n" {
+        t.Errorf("Expected an admin key to lift governance retention, got %d: %s", status, body)
+    }
+    if status, _ := do(http.MethodPut, "/objects/locked.txt?retention", `{"mode":"forever","retain_until":"2100-01-01T00:00:00Z"}`, admin, false); status != http.StatusBadRequest {
+        t.Errorf("Expected an unknown mode to get %d, got %d", http.StatusBadRequest, status)
+    }
+
+    // Only admin keys release a legal hold.
+    if status, _ := do(http.MethodPut, "/objects/locked.txt?legal-hold", `{"legal_hold":false}`, user, false); status != http.StatusConflict {
+        t.Errorf("Expected a non-admin key not to release the legal hold, got %d", status)
+    }
+    if status, _ := do(http.MethodDelete, "/objects/locked.txt", "", user, false); status != http.StatusConflict {
+        t.Errorf("Expected the legal hold to remain, got %d", status)
+    }
+    if status, body := do(http.MethodPut, "/objects/locked.txt?legal-hold", `{"legal_hold":false}`, admin, false); status != http.StatusOK || !strings.Contains(body, `"legal_hold":false`) {
+        t.Errorf("Expected an admin key to release the legal hold, got %d: %s", status, body)
+    }
+}
+
+func TestS3ObjectLock(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    ctx := context.Background()
+    client := newS3Client(server.URL)
+    bucket, key := aws.String("default"), aws.String("audit.log")
+    until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+    _, err := client.PutObject(ctx, &s3.PutObjectInput{
+        Bucket:                    bucket,
+        Key:                       key,
+        Body:                      strings.NewReader("entries"),
+        ObjectLockMode:            types.ObjectLockModeCompliance,
+        ObjectLockRetainUntilDate: aws.Time(until),
+    })
+    if err != nil {
+        t.Fatalf("PutObject failed: %v", err)
+    }
+
+    head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: key})
+    if err != nil {
+        t.Fatalf("HeadObject failed: %v", err)
+    }
+    if head.ObjectLockMode != types.ObjectLockModeCompliance || !aws.ToTime(head.ObjectLockRetainUntilDate).Equal(until) {
+        t.Errorf("Unexpected lock %v until %v", head.ObjectLockMode, head.ObjectLockRetainUntilDate)
+    }
+
+    _, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: key, BypassGovernanceRetention: aws.Bool(true)})
+    var apiErr smithy.APIError
+    if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+        t.Errorf("Expected AccessDenied deleting under compliance retention, got %v", err)
+    }
+
+    retention, err := client.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{Bucket: bucket, Key: key})
+    if err != nil {
+        t.Fatalf("GetObjectRetention failed: %v", err)
+    }
+    if retention.Retention.Mode != types.ObjectLockRetentionModeCompliance {
+        t.Errorf("Unexpected retention mode %v", retention.Retention.Mode)
+    }
+    _, err = client.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+        Bucket:    bucket,
+        Key:       key,
+        Retention: &types.ObjectLockRetention{Mode: types.ObjectLockRetentionModeCompliance, RetainUntilDate: aws.Time(until.Add(-time.Minute))},
+    })
+    if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+        t.Errorf("Expected AccessDenied shortening compliance retention, got %v", err)
+    }
+
+    _, err = client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+        Bucket:    bucket,
+        Key:       key,
+        LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOn},
+    })
+    if err != nil {
+        t.Fatalf("PutObjectLegalHold failed: %v", err)
+    }
+    hold, err := client.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{Bucket: bucket, Key: key})
+    if err != nil {
+        t.Fatalf("GetObjectLegalHold failed: %v", err)
+    }
+    if hold.LegalHold.Status != types.ObjectLockLegalHoldStatusOn {
+        t.Errorf("Unexpected legal hold %v", hold.LegalHold.Status)
+    }
+
+    if _, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("plain.txt"), Body: strings.NewReader("x")}); err != nil {
+        t.Fatal(err)
+    }
+    _, err = client.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{Bucket: bucket, Key: aws.String("plain.txt")})
+    if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "NoSuchObjectLockConfiguration" {
+        t.Errorf("Expected NoSuchObjectLockConfiguration, got %v", err)
+    }
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/xml"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/corylehan/object-store/store"
+)
+
+type s3InitiateMultipartUploadResult struct {
+    XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+    Xmlns    string   `xml:"xmlns,attr"`
+    Bucket   string   `xml:"Bucket"`
+    Key      string   `xml:"Key"`
+    UploadID string   `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+    Parts []struct {
+        PartNumber int    `xml:"PartNumber"`
+        ETag       string `xml:"ETag"`
+    } `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+    XMLName        xml.Name `xml:"CompleteMultipartUploadResult"`
+    Xmlns          string   `xml:"xmlns,attr"`
+    Location       string   `xml:"Location"`
+    Bucket         string   `xml:"Bucket"`
+    Key            string   `xml:"Key"`
+    ETag           string   `xml:"ETag"`
+    ChecksumSHA256 string   `xml:"ChecksumSHA256"`
+}
+
+type s3ListPartsResult struct {
+    XMLName  xml.Name `xml:"ListPartsResult"`
+    Xmlns    string   `xml:"xmlns,attr"`
+    Bucket   string   `xml:"Bucket"`
+    Key      string   `xml:"Key"`
+    UploadID string   `xml:"UploadId"`
+    Parts    []s3Part `xml:"Part"`
+}
+
+type s3Part struct {
+    PartNumber   int    `xml:"PartNumber"`
+    LastModified string `xml:"LastModified"`
+    ETag         string `xml:"ETag"`
+    Size         int64  `xml:"Size"`
+}
+
+func (h *S3Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    upload, err := h.store.InBucket(bucket).InitiateUpload(r.Context(), key, store.WriteOptions{Headers: s3StoredHeaders(r)})
+    if err != nil {
+        writeS3StoreError(w, r, err)
+        return
+    }
+
+    writeS3XML(w, http.StatusOK, s3InitiateMultipartUploadResult{
+        Xmlns:    s3Namespace,
+        Bucket:   bucket,
+        Key:      key,
+        UploadID: upload.UploadID,
+    })
+}
+
+func (h *S3Handler) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    query := r.URL.Query()
+    uploadID := query.Get("uploadId")
+    partNumber, err := strconv.Atoi(query.Get("partNumber"))
+    if err != nil {
+        writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer.")
+        return
+    }
+    scoped, ok := h.checkUpload(w, r, bucket, key, uploadID)
+    if !ok {
+        return
+    }
+
+    part, err := scoped.UploadPart(r.Context(), uploadID, partNumber, s3Body(r))
+    if err != nil {
+        writeS3StoreError(w, r, err)
+        return
+    }
+    w.Header().Set("ETag", `"`+part.Hash+`"`)
+    w.WriteHeader(http.StatusOK)
+}
+
+func (h *S3Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    uploadID := r.URL.Query().Get("uploadId")
+    scoped, ok := h.checkUpload(w, r, bucket, key, uploadID)
+    if !ok {
+        return
+    }
+
+    var req s3CompleteMultipartUpload
+    if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+        return
+    }
+    if len(req.Parts) == 0 {
+        writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided did not list any parts.")
+        return
+    }
+    parts := make([]store.CompletedPart, 0, len(req.Parts))
+    for _, p := range req.Parts {
+        parts = append(parts, store.CompletedPart{PartNumber: p.PartNumber, Hash: strings.Trim(p.ETag, `"`)})
+    }
+
+    metadata, checksum, err := scoped.CompleteUpload(r.Context(), uploadID, parts)
+    if err != nil {
+        writeS3StoreError(w, r, err)
+        return
+    }
+    etag, err := entityTag(r.Context(), h.store, metadata.BlobID)
+    if err != nil {
+        writeS3StoreError(w, r, err)
+        return
+    }
+
+    writeS3XML(w, http.StatusOK, s3CompleteMultipartUploadResult{
+        Xmlns:          s3Namespace,
+        Location:       s3Prefix + bucket + "/" + key,
+        Bucket:         bucket,
+        Key:            key,
+        ETag:           etag,
+        ChecksumSHA256: s3CompositeChecksum(checksum),
+    })
+}
+
+func (h *S3Handler) listParts(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    uploadID := r.URL.Query().Get("uploadId")
+    scoped, ok := h.checkUpload(w, r, bucket, key, uploadID)
+    if !ok {
+        return
+    }
+
+    parts, err := scoped.ListParts(r.Context(), uploadID)
+    if err != nil {
+        writeS3StoreError(w, r, err)
+        return
+    }
+
+    result := s3ListPartsResult{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadID: uploadID}
+    for _, part := range parts {
+        result.Parts = append(result.Parts, s3Part{
+            PartNumber:   part.PartNumber,
+            LastModified: part.CreatedAt.UTC().Format(s3TimeFormat),
+            ETag:         `"` + part.Hash + `"`,
+            Size:         part.Size,
+        })
+    }
+    writeS3XML(w, http.StatusOK, result)
+}
+
+func (h *S3Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+    uploadID := r.URL.Query().Get("uploadId")
+    scoped, ok := h.checkUpload(w, r, bucket, key, uploadID)
+    if !ok {
+        return
+    }
+
+    if err := scoped.AbortUpload(r.Context(), uploadID); err != nil {
+        writeS3StoreError(w, r, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// checkUpload reports whether uploadID is an upload of key in bucket,
+// writing an error response if it is not. It returns the store scoped to the
+// bucket.
+func (h *S3Handler) checkUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) (*store.Store, bool) {
+    if _, err := h.store.GetBucket(r.Context(), bucket); err != nil {
+        writeS3StoreError(w, r, err)
+        return nil, false
+    }
+
+    scoped := h.store.InBucket(bucket)
+    upload, err := scoped.GetUpload(r.Context(), uploadID)
+    if err == nil && upload.ObjectPath != key {
+        err = fmt.Errorf("%w: %s", store.ErrUploadNotFound, uploadID)
+    }
+    if err != nil {
+        writeS3StoreError(w, r, err)
+        return nil, false
+    }
+    return scoped, true
+}
+
+// s3CompositeChecksum converts a composite checksum from hex to the base64
+// form S3 uses, keeping the part count suffix.
+func s3CompositeChecksum(checksum string) string {
+    digest, count, _ := strings.Cut(checksum, "-")
+    raw, err := hex.DecodeString(digest)
+    if err != nil {
+        return ""
+    }
+    return base64.StdEncoding.EncodeToString(raw) + "-" + count
+}
@@ -0,0 +1,168 @@
+// api/s3_test.go
+package api
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "strings"
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/credentials"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func newS3Client(serverURL string) *s3.Client {
+    return s3.New(s3.Options{
+        BaseEndpoint: aws.String(serverURL + strings.TrimSuffix(s3Prefix, "/")),
+        Region:       "us-east-1",
+        UsePathStyle: true,
+        Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+    })
+}
+
+func TestS3BucketsAndObjects(t *testing.T) {
+    server, _ := setupTestServer(t)
+    defer server.Close()
+
+    ctx := context.Background()
+    client := newS3Client(server.URL)
+    bucket := aws.String("artifacts")
+
+    if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: bucket}); err != nil {
+        t.Fatalf("CreateBucket failed: %v", err)
+    }
+
+    buckets, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+    if err != nil {
+        t.Fatalf("ListBuckets failed: %v", err)
+    }
+    var names []string
+    for _, b := range buckets.Buckets {
+        names = append(names, aws.ToString(b.Name))
+    }
+    if strings.Join(names, ",") != "artifacts,default" {
+        t.Errorf("Unexpected buckets: %v", names)
+    }
+
+    objects := map[string]string{
+        "readme.txt":        "read me",
+        "builds/1/app.bin":  "build one",
+        "builds/2/app.bin":  "build two",
+        "builds/latest.txt": "2",
+    }
+    for key, content := range objects {
+        _, err := client.PutObject(ctx, &s3.PutObjectInput{
+            Bucket: bucket,
+            Key:    aws.String(key),
+            Body:   strings.NewReader(content),
+        })
+        if err != nil {
+            t.Fatalf("PutObject %s failed: %v", key, err)
+        }
+    }
+
+    head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("builds/1/app.bin")})
+    if err != nil {
+        t.Fatalf("HeadObject failed: %v", err)
+    }
+    if aws.ToInt64(head.ContentLength) != int64(len("build one")) {
+        t.Errorf("Expected content length %d, got %d", len("build one"), aws.ToInt64(head.ContentLength))
+    }
+
+    // Overwrite an existing key
+    _, err = client.PutObject(ctx, &s3.PutObjectInput{
+        Bucket:      bucket,
+        Key:         aws.String("readme.txt"),
+        Body:        strings.NewReader("read me again"),
+        ContentType: aws.String("text/plain"),
+        Metadata:    map[string]string{"reviewer": "ops"},
+    })
+    if err != nil {
+        t.Fatalf("PutObject overwrite failed: %v", err)
+    }
+
+    get, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("readme.txt")})
+    if err != nil {
+        t.Fatalf("GetObject failed: %v", err)
+    }
+    body, _ := io.ReadAll(get.Body)
+    get.Body.Close()
+    if !bytes.Equal(body, []byte("read me again")) {
+        t.Errorf("Expected content %q, got %q", "read me again", body)
+    }
+    if aws.ToString(get.ContentType) != "text/plain" || get.Metadata["reviewer"] != "ops" {
+        t.Errorf("Expected stored Content-Type and metadata, got %q and %v", aws.ToString(get.ContentType), get.Metadata)
+    }
+
+    list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: bucket, Delimiter: aws.String("/")})
+    if err != nil {
+        t.Fatalf("ListObjectsV2 failed: %v", err)
+    }
+    if len(list.Contents) != 1 || aws.ToString(list.Contents[0].Key) != "readme.txt" {
+        t.Errorf("Unexpected contents: %+v", list.Contents)
+    }
+    if len(list.CommonPrefixes) != 1 || aws.ToString(list.CommonPrefixes[0].Prefix) != "builds/" {
+        t.Errorf("Unexpected common prefixes: %+v", list.CommonPrefixes)
+    }
+
+    var keys []string
+    paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+        Bucket:  bucket,
+        Prefix:  aws.String("builds/"),
+        MaxKeys: aws.Int32(1),
+    })
+    for paginator.HasMorePages() {
+        page, err := paginator.NextPage(ctx)
+        if err != nil {
+            t.Fatalf("ListObjectsV2 page failed: %v", err)
+        }
+        for _, obj := range page.Contents {
+            keys = append(keys, aws.ToString(obj.Key))
+        }
+    }
+    expected := []string{"builds/1/app.bin", "builds/2/app.bin", "builds/latest.txt"}
+    if strings.Join(keys, ",") != strings.Join(expected, ",") {
+        t.Errorf("Expected paginated keys %v, got %v", expected, keys)
+    }
+
+    _, err = client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: bucket})
+    if err == nil {
+        t.Error("Expected DeleteBucket to fail on a non-empty bucket")
+    }
+
+    for key := range objects {
+        if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String(key)}); err != nil {
+            t.Fatalf("DeleteObject %s failed: %v", key, err)
+        }
+    }
+
+    _, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("readme.txt")})
+    var noSuchKey *types.NoSuchKey
+    if !errors.As(err, &noSuchKey) {
+        t.Errorf("Expected NoSuchKey, got %v", err)
+    }
+
+    if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: bucket}); err != nil {
+        t.Fatalf("DeleteBucket failed: %v", err)
+    }
+
+    _, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("x"), Body: strings.NewReader("x")})
+    if err == nil || !strings.Contains(err.Error(), "NoSuchBucket") {
+        t.Errorf("Expected NoSuchBucket, got %v", err)
+    }
+}
+
+func TestAWSChunkedReader(t *testing.T) {
+    encoded := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"
+    data, err := io.ReadAll(newAWSChunkedReader(strings.NewReader(encoded)))
+    if err != nil {
+        t.Fatalf("Decoding failed: %v", err)
+    }
+    if string(data) != "hello world" {
+        t.Errorf("Expected %q, got %q", "hello world", data)
+    }
+}
@@ -3,15 +3,29 @@ package api
 
 import (
     "fmt"
+    "log"
+    "log/slog"
     "net/http"
+    "time"
 
     "github.com/corylehan/object-store/store"
 )
 
+// adminPrefix is the path prefix of the administrative endpoints, which
+// only admin API keys may use when requests are authenticated.
+const adminPrefix = "/admin/"
+
 type Server struct {
     Port   int
     Store  *store.Store
     Router *http.ServeMux
+    // Handler serves requests: it is Router wrapped in the middleware every
+    // request goes through, outermost the access log and the metrics
+    // instrumentation.
+    Handler http.Handler
+    // Logger receives the access log, a record per request. NewServer
+    // sets it to slog.Default().
+    Logger *slog.Logger
 }
 
 func NewServer(port int, s *store.Store) *Server {
@@ -19,15 +33,47 @@ func NewServer(port int, s *store.Store) *Server {
         Port:   port,
         Store:  s,
         Router: http.NewServeMux(),
+        Logger: slog.Default(),
     }
 
     h := NewHandler(s)
     server.Router.HandleFunc("/objects", h.handleObjects)
     server.Router.HandleFunc("/objects/", h.handleObject)
+    server.Router.HandleFunc("/changes", h.handleChanges)
+    server.Router.HandleFunc("/buckets", h.handleBuckets)
+    server.Router.HandleFunc("/buckets/", h.handleBucket)
+    server.Router.HandleFunc(adminPrefix+"scrub", h.handleScrub)
+    server.Router.HandleFunc(adminPrefix+"lifecycle", h.handleLifecycle)
+    server.Router.HandleFunc(adminPrefix+"keys", h.handleKeys)
+    server.Router.HandleFunc(adminPrefix+"keys/", h.handleKey)
+    server.Router.HandleFunc(adminPrefix+"buckets/", h.handleBucketPolicy)
+    server.Router.HandleFunc(adminPrefix+"policies/explain", h.handleExplain)
+    server.Router.HandleFunc(adminPrefix+"presign", h.handlePresign)
+    server.Router.HandleFunc(adminPrefix+"webhooks/dead-letters", h.handleDeadLetters)
+    server.Router.HandleFunc(adminPrefix+"webhooks/dead-letters/", h.handleDeadLetters)
+    server.Router.Handle(s3Prefix, NewS3Handler(s))
+
+    metrics := newHTTPMetrics(s)
+    server.Router.Handle(metricsPath, metrics.handler())
 
+    server.Handler = server.Router
+    if auth := s.Config().Auth; auth.Enabled {
+        a := &authenticator{store: s, window: auth.ReplayWindow(), publicMetrics: auth.PublicMetrics, now: time.Now}
+        server.Handler = a.authenticate(authorize(s, server.Handler))
+    }
+    server.Handler = server.logRequests(metrics.instrument(server.Router, server.Handler))
     return server
 }
 
 func (s *Server) ListenAndServe() error {
-    return http.ListenAndServe(fmt.Sprintf(":%d", s.Port), s.Router)
+    return http.ListenAndServe(fmt.Sprintf(":%d", s.Port), s.Handler)
+}
+
+// StartServer serves the API for s on the default port until the listener
+// fails, logging requests to accessLog.
+func StartServer(s *store.Store, accessLog *slog.Logger) {
+    server := NewServer(8080, s)
+    server.Logger = accessLog
+    log.Printf("Listening on :%d", server.Port)
+    log.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,69 @@
+// api/webhooks.go
+package api
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/corylehan/object-store/store"
+)
+
+type deadLetterResponse struct {
+    ID        int64       `json:"id"`
+    WebhookID string      `json:"webhook_id"`
+    Event     store.Event `json:"event"`
+    Attempts  int         `json:"attempts"`
+    LastError string      `json:"last_error"`
+    CreatedAt time.Time   `json:"created_at"`
+}
+
+// handleDeadLetters serves /admin/webhooks/dead-letters, which GET lists
+// the webhook deliveries that ran out of attempts, and
+// /admin/webhooks/dead-letters/{id}, which POST queues for delivery again.
+func (h *Handler) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+    id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, adminPrefix+"webhooks/dead-letters"), "/")
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        h.listDeadLetters(w, r)
+    case id != "" && r.Method == http.MethodPost:
+        h.retryDeadLetter(w, r, id)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func (h *Handler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+    deliveries, err := h.store.ListDeadDeliveries(r.Context())
+    if err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+
+    resp := make([]deadLetterResponse, 0, len(deliveries))
+    for _, d := range deliveries {
+        resp = append(resp, deadLetterResponse{
+            ID:        d.ID,
+            WebhookID: d.WebhookID,
+            Event:     d.Event,
+            Attempts:  d.Attempts,
+            LastError: d.LastError,
+            CreatedAt: d.CreatedAt,
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) retryDeadLetter(w http.ResponseWriter, r *http.Request, id string) {
+    deliveryID, err := strconv.ParseInt(id, 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+        return
+    }
+    if err := h.store.RetryDelivery(r.Context(), deliveryID); err != nil {
+        writeError(w, r, err, statusForError(err))
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+// api/webhooks_test.go
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/corylehan/object-store/store"
+)
+
+func TestDeadLetterRoutes(t *testing.T) {
+    ctx := t.Context()
+    receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "gone", http.StatusGone)
+    }))
+    defer receiver.Close()
+
+    tempDir := t.TempDir()
+    configFile := filepath.Join(tempDir, "config.json")
+    config := store.Config{
+        StorageDirectory: filepath.Join(tempDir, "storage"),
+        Webhooks:         []store.WebhookConfig{{ID: "mirror", URL: receiver.URL, MaxAttempts: 1}},
+    }
+    configData, _ := json.Marshal(config)
+    os.WriteFile(configFile, configData, 0644)
+    s, err := store.NewStore(configFile, filepath.Join(tempDir, "metadata.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+    server := httptest.NewServer(NewServer(0, s).Router)
+    defer server.Close()
+
+    if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.DeliverWebhooks(ctx); err != nil {
+        t.Fatal(err)
+    }
+
+    status, body := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/admin/webhooks/dead-letters", ""))
+    var dead []deadLetterResponse
+    json.Unmarshal([]byte(body), &dead)
+    if status != http.StatusOK || len(dead) != 1 || dead[0].WebhookID != "mirror" || dead[0].Event.Type != store.EventObjectCreated || dead[0].Attempts != 1 {
+        t.Fatalf("Unexpected dead letters %d %s", status, body)
+    }
+
+    retryURL := server.URL + "/admin/webhooks/dead-letters/"
+    if status, body := doRequest(t, mustRequest(t, http.MethodPost, retryURL+"999", "")); status != http.StatusNotFound {
+        t.Errorf("Expected %d retrying an unknown delivery, got %d: %s", http.StatusNotFound, status, body)
+    }
+    if status, _ := doRequest(t, mustRequest(t, http.MethodPost, retryURL+"abc", "")); status != http.StatusBadRequest {
+        t.Errorf("Expected %d for an invalid ID, got %d", http.StatusBadRequest, status)
+    }
+    if status, body := doRequest(t, mustRequest(t, http.MethodPost, retryURL+"1", "")); status != http.StatusNoContent {
+        t.Errorf("Expected %d retrying, got %d: %s", http.StatusNoContent, status, body)
+    }
+    if _, body := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/admin/webhooks/dead-letters", "")); body != "[]\n" {
+        t.Errorf("Expected no dead letters after the retry, got %s", body)
+    }
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/corylehan/object-store/api"
+	"github.com/corylehan/object-store/store"
+)
+
+// runCommand runs the command named by args[0] against s, writing its output
+// to w.
+func runCommand(ctx context.Context, s *store.Store, args []string, w io.Writer) error {
+	switch args[0] {
+	case "gc":
+		return runGC(ctx, s, args[1:], w)
+	case "rotate-key":
+		return runRotateKey(ctx, s, args[1:], w)
+	case "create-api-key":
+		return runCreateAPIKey(ctx, s, args[1:], w)
+	case "presign":
+		return runPresign(s, args[1:], w)
+	case "lifecycle":
+		return runLifecycle(ctx, s, args[1:], w)
+	case "dead-letters":
+		return runDeadLetters(ctx, s, args[1:], w)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runGC reports the drift between the backend and the blob records,
+// repairing it if asked to.
+func runGC(ctx context.Context, s *store.Store, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	var opts store.GCOptions
+	flags.BoolVar(&opts.DeleteOrphans, "delete-orphans", false, "delete blobs without a record that are older than the grace period")
+	flags.BoolVar(&opts.MarkMissing, "mark-missing", false, "mark blob records whose content is gone as missing")
+	flags.DurationVar(&opts.Grace, "grace", 0, "override the configured grace period for orphans")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := s.CollectGarbage(ctx, opts)
+	if err != nil {
+		return err
+	}
+	printGCReport(w, report)
+	return nil
+}
+
+func printGCReport(w io.Writer, report *store.GCReport) {
+	for _, name := range report.Orphans {
+		fmt.Fprintf(w, "orphan %s\n", name)
+	}
+	for _, id := range report.Dangling {
+		fmt.Fprintf(w, "dangling %s\n", id)
+	}
+	fmt.Fprintf(w, "%d orphans (%d deleted), %d dangling (%d marked missing)\n",
+		len(report.Orphans), len(report.Deleted), len(report.Dangling), len(report.Marked))
+}
+
+// runRotateKey makes a new master key active and rewraps every data key with
+// it, optionally removing the master keys no longer in use.
+func runRotateKey(ctx context.Context, s *store.Store, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	retire := flags.Bool("retire", false, "remove master keys that no longer wrap any data key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keys := s.Keyring()
+	if keys == nil {
+		return fmt.Errorf("encryption is not configured")
+	}
+	keyID, err := keys.Rotate()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "active key %s\n", keyID)
+
+	n, err := s.RewrapKeys(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "rewrapped %d data keys\n", n)
+
+	if *retire {
+		retired, err := s.RetireUnusedKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, id := range retired {
+			fmt.Fprintf(w, "retired key %s\n", id)
+		}
+	}
+	return nil
+}
+
+// runCreateAPIKey creates an API key and prints its secret. It is how the
+// first admin key is made once authentication is enabled.
+func runCreateAPIKey(ctx context.Context, s *store.Store, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("create-api-key", flag.ContinueOnError)
+	admin := flags.Bool("admin", false, "allow the key to use the admin endpoints")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := s.CreateAPIKey(ctx, *admin)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "access key ID %s\nsecret %s\n", key.AccessKeyID, key.Secret)
+	return nil
+}
+
+// runPresign prints a presigned URL for the object path given as argument.
+func runPresign(s *store.Store, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("presign", flag.ContinueOnError)
+	method := flags.String("method", "GET", "the method the URL allows, GET or PUT")
+	bucket := flags.String("bucket", store.DefaultBucket, "the bucket of the object")
+	expires := flags.Duration("expires", store.DefaultPresignExpiry, "how long the URL is valid")
+	contentLength := flags.Int64("content-length", 0, "the only content length a PUT may send")
+	contentType := flags.String("content-type", "", "the only content type a PUT may send")
+	base := flags.String("base", "http://localhost:8080", "the URL the server is reached at")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: presign [flags] <object path>")
+	}
+
+	presigned, err := api.PresignURL(s, *base, store.Presign{
+		Method:        strings.ToUpper(*method),
+		Bucket:        *bucket,
+		Path:          flags.Arg(0),
+		Expires:       time.Now().Add(*expires),
+		ContentLength: *contentLength,
+		ContentType:   *contentType,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, presigned)
+	return nil
+}
+
+// runLifecycle runs a lifecycle pass, or with -dry-run reports what it would
+// remove.
+func runLifecycle(ctx context.Context, s *store.Store, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("lifecycle", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what the lifecycle rules would remove without removing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := s.ApplyLifecycle(ctx, store.LifecycleOptions{DryRun: *dryRun})
+	if report != nil {
+		for _, action := range report.Actions {
+			fmt.Fprintln(w, describeLifecycleAction(action))
+		}
+		verb := "removed"
+		if report.DryRun {
+			verb = "would remove"
+		}
+		fmt.Fprintf(w, "%s %d\n", verb, len(report.Actions))
+	}
+	return err
+}
+
+// describeLifecycleAction describes an action of a lifecycle pass on one
+// line.
+func describeLifecycleAction(action store.LifecycleAction) string {
+	switch action.Kind {
+	case store.LifecycleAbortUpload:
+		return fmt.Sprintf("%s %s/%s upload %s", action.Kind, action.Bucket, action.ObjectPath, action.UploadID)
+	default:
+		return fmt.Sprintf("%s %s/%s version %s", action.Kind, action.Bucket, action.ObjectPath, action.VersionID)
+	}
+}
+
+// runDeadLetters lists the webhook deliveries that ran out of attempts, or
+// with -retry queues one again.
+func runDeadLetters(ctx context.Context, s *store.Store, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("dead-letters", flag.ContinueOnError)
+	retry := flags.Int64("retry", 0, "queue the dead-lettered delivery with this ID again")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *retry != 0 {
+		if err := s.RetryDelivery(ctx, *retry); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "queued delivery %d\n", *retry)
+		return nil
+	}
+
+	deliveries, err := s.ListDeadDeliveries(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		fmt.Fprintf(w, "%d %s %s %s/%s after %d attempts: %s\n", d.ID, d.WebhookID, d.Event.Type, d.Event.Bucket, d.Event.ObjectPath, d.Attempts, d.LastError)
+	}
+	return nil
+}
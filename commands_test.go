@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corylehan/object-store/store"
+)
+
+func newCommandTestStore(t *testing.T) *store.Store {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.json")
+	configContent := fmt.Sprintf(`{"storage_directory":"%s","encryption":{"keyfile":"%s"},"presign":{"secret":"secret"},"webhooks":[{"id":"unreachable","url":"http://127.0.0.1:1","max_attempts":1}]}`,
+		filepath.Join(tempDir, "storage"), filepath.Join(tempDir, "keys.json"))
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(configFile, filepath.Join(tempDir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestGCCommand(t *testing.T) {
+	ctx := t.Context()
+	s := newCommandTestStore(t)
+	if err := s.Backend.Put(ctx, "stray", strings.NewReader("stray"), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(ctx, s, []string{"gc"}, &out); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "orphan stray\n") || !strings.Contains(out.String(), "1 orphans (0 deleted)") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// A grace period shorter than the orphan's age lets it be deleted.
+	time.Sleep(10 * time.Millisecond)
+	out.Reset()
+	if err := runCommand(ctx, s, []string{"gc", "-delete-orphans", "-grace", "1ms"}, &out); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "1 orphans (1 deleted)") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	if err := runCommand(ctx, s, []string{"nope"}, &out); err == nil {
+		t.Error("Expected an unknown command to fail")
+	}
+}
+
+func TestRotateKeyCommand(t *testing.T) {
+	ctx := t.Context()
+	s := newCommandTestStore(t)
+	old, err := s.Keyring().ActiveKeyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(ctx, s, []string{"rotate-key", "-retire"}, &out); err != nil {
+		t.Fatalf("rotate-key failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "rewrapped 1 data keys\n") || !strings.Contains(out.String(), "retired key "+old+"\n") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	data, err := s.ReadObject(ctx, "a.txt")
+	if err != nil || string(data) != "a" {
+		t.Errorf("Expected content readable after rotation, got %q, %v", data, err)
+	}
+}
+
+func TestCreateAPIKeyCommand(t *testing.T) {
+	ctx := t.Context()
+	s := newCommandTestStore(t)
+
+	var out bytes.Buffer
+	if err := runCommand(ctx, s, []string{"create-api-key", "-admin"}, &out); err != nil {
+		t.Fatalf("create-api-key failed: %v", err)
+	}
+	var accessKeyID, secret string
+	if _, err := fmt.Sscanf(out.String(), "access key ID %s\nsecret %s\n", &accessKeyID, &secret); err != nil {
+		t.Fatalf("Unexpected output %q: %v", out.String(), err)
+	}
+
+	key, err := s.GetAPIKey(ctx, accessKeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Admin || key.Secret != secret {
+		t.Errorf("Expected an admin key with the printed secret, got %+v", key)
+	}
+}
+
+func TestPresignCommand(t *testing.T) {
+	ctx := t.Context()
+	s := newCommandTestStore(t)
+
+	var out bytes.Buffer
+	args := []string{"presign", "-method", "put", "-bucket", "default", "-expires", "1h", "-content-length", "3", "-base", "https://store.example", "a/b.txt"}
+	if err := runCommand(ctx, s, args, &out); err != nil {
+		t.Fatalf("presign failed: %v", err)
+	}
+	u, err := url.Parse(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Host != "store.example" || u.Path != "/objects/a/b.txt" || query.Get("X-Presign-Content-Length") != "3" {
+		t.Errorf("Unexpected presigned URL %s", u)
+	}
+
+	expires, _ := strconv.ParseInt(query.Get("X-Presign-Expires"), 10, 64)
+	p := store.Presign{Method: "PUT", Bucket: "default", Path: "a/b.txt", Expires: time.Unix(expires, 0), ContentLength: 3}
+	if err := s.VerifyPresign(p, query.Get("X-Presign-Signature")); err != nil {
+		t.Errorf("Expected the printed URL to verify, got %v", err)
+	}
+
+	if err := runCommand(ctx, s, []string{"presign", "-method", "DELETE", "a/b.txt"}, &out); !errors.Is(err, store.ErrInvalidPresign) {
+		t.Errorf("Expected ErrInvalidPresign, got %v", err)
+	}
+}
+
+func TestLifecycleCommand(t *testing.T) {
+	ctx := t.Context()
+	s := newCommandTestStore(t)
+	bucket, _ := s.GetBucket(ctx, store.DefaultBucket)
+	bucket.Lifecycle = []store.LifecycleRule{{Prefix: "tmp/", ExpireDays: 1}}
+	if err := s.UpdateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "tmp/fresh.bin", []byte("fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	for args, want := range map[string]string{"-dry-run": "would remove 0\n", "": "removed 0\n"} {
+		var out bytes.Buffer
+		if err := runCommand(ctx, s, append([]string{"lifecycle"}, strings.Fields(args)...), &out); err != nil {
+			t.Fatalf("lifecycle %s failed: %v", args, err)
+		}
+		if out.String() != want {
+			t.Errorf("lifecycle %s: expected %q, got %q", args, want, out.String())
+		}
+	}
+	if _, err := s.StatObject(ctx, "tmp/fresh.bin"); err != nil {
+		t.Errorf("Expected an object younger than the rule to be kept, got %v", err)
+	}
+
+	action := store.LifecycleAction{Kind: store.LifecycleAbortUpload, Bucket: "default", ObjectPath: "tmp/big.bin", UploadID: "u1"}
+	if got := describeLifecycleAction(action); got != "abort_upload default/tmp/big.bin upload u1" {
+		t.Errorf("Unexpected description %q", got)
+	}
+}
+
+func TestDeadLettersCommand(t *testing.T) {
+	ctx := t.Context()
+	s := newCommandTestStore(t)
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.DeliverWebhooks(ctx); err != nil || result.Dead != 1 {
+		t.Fatalf("Expected the delivery to the unreachable webhook to be dead-lettered, got %+v, %v", result, err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(ctx, s, []string{"dead-letters"}, &out); err != nil {
+		t.Fatalf("dead-letters failed: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "1 unreachable ObjectCreated default/a.txt after 1 attempts: ") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := runCommand(ctx, s, []string{"dead-letters", "-retry", "1"}, &out); err != nil {
+		t.Fatalf("dead-letters -retry failed: %v", err)
+	}
+	if out.String() != "queued delivery 1\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	if err := runCommand(ctx, s, []string{"dead-letters", "-retry", "1"}, io.Discard); !errors.Is(err, store.ErrDeliveryNotFound) {
+		t.Errorf("Expected ErrDeliveryNotFound retrying a queued delivery, got %v", err)
+	}
+}
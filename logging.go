@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/corylehan/object-store/store"
+)
+
+// openLog returns a logger writing JSON records to the sinks config names,
+// together with the files it opened, which the caller must close.
+func openLog(config store.LogConfig) (*slog.Logger, []io.Closer, error) {
+	var level slog.Level
+	if config.Level != "" {
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q", config.Level)
+		}
+	}
+
+	sinks := config.Sinks
+	if len(sinks) == 0 {
+		sinks = []string{"stderr"}
+	}
+	var writers []io.Writer
+	var files []io.Closer
+	for _, sink := range sinks {
+		switch sink {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		default:
+			f, err := openRotatingFile(sink, config.MaxSize(), config.Backups())
+			if err != nil {
+				closeAll(files)
+				return nil, nil, err
+			}
+			writers = append(writers, f)
+			files = append(files, f)
+		}
+	}
+
+	handler := slog.NewJSONHandler(io.MultiWriter(writers...), &slog.HandlerOptions{Level: level})
+	return slog.New(handler), files, nil
+}
+
+func closeAll(files []io.Closer) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// rotatingFile is a log file that is moved aside once it would grow past
+// maxSize. The file at path is the current one, path.1 the one before it,
+// and so on up to path.N, N being the number of backups kept.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past
+// maxSize. Records are written whole, so none is split across files.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one, dropping the oldest, moves the
+// current file to path.1 and starts a new one.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	for i := rf.backups - 1; i >= 1; i-- {
+		err := os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+	if err := os.Rename(rf.path, rf.backupPath(1)); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
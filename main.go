@@ -3,13 +3,18 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/corylehan/object-store/api"
 	"github.com/corylehan/object-store/store"
 )
 
 func main() {
+	ctx := context.Background()
 	configFile := "./config.json"
 	dbPath := "./metadata.db"
 
@@ -17,7 +22,135 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create Store: %v", err)
 	}
-	defer s.MetadataStore.db.Close()
+	defer s.Close()
 
-	api.StartServer(s)
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, s, os.Args[1:], os.Stdout); err != nil {
+			log.Printf("%s: %v", os.Args[1], err)
+			s.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger, files, err := openLog(s.Config().Log)
+	if err != nil {
+		log.Fatalf("Failed to open log: %v", err)
+	}
+	defer closeAll(files)
+	slog.SetDefault(logger)
+	accessLog := logger
+	if config := s.Config().AccessLog; len(config.Sinks) > 0 {
+		if accessLog, files, err = openLog(config); err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		defer closeAll(files)
+	}
+
+	if err := s.Recover(ctx); err != nil {
+		log.Fatalf("Failed to recover Store: %v", err)
+	}
+
+	go reapUploads(ctx, s, time.Hour)
+	go scrubBlobs(ctx, s, time.Hour)
+	go collectGarbage(ctx, s, 24*time.Hour)
+	go applyLifecycle(ctx, s, time.Hour)
+	go deliverWebhooks(ctx, s, 5*time.Second)
+	go pruneChanges(ctx, s, time.Hour)
+
+	api.StartServer(s, accessLog)
+}
+
+// reapUploads aborts stale multipart uploads every interval.
+func reapUploads(ctx context.Context, s *store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := s.ReapStaleUploads(ctx)
+		if err != nil {
+			log.Printf("Failed to reap stale uploads: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Reaped %d stale uploads", n)
+		}
+	}
+}
+
+// scrubBlobs verifies the blobs that are due for verification every interval.
+func scrubBlobs(ctx context.Context, s *store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		result, err := s.ScrubBlobs(ctx)
+		if err != nil {
+			log.Printf("Failed to scrub blobs: %v", err)
+			continue
+		}
+		for _, id := range result.Corrupt {
+			log.Printf("Quarantined corrupt blob %s", id)
+		}
+		for _, id := range result.Missing {
+			log.Printf("Blob %s is missing", id)
+		}
+	}
+}
+
+// collectGarbage deletes orphaned blobs past the grace period and marks
+// dangling blob records missing every interval.
+func collectGarbage(ctx context.Context, s *store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := s.CollectGarbage(ctx, store.GCOptions{DeleteOrphans: true, MarkMissing: true})
+		if err != nil {
+			log.Printf("Failed to collect garbage: %v", err)
+			continue
+		}
+		for _, name := range report.Deleted {
+			log.Printf("Deleted orphaned blob %s", name)
+		}
+		for _, id := range report.Marked {
+			log.Printf("Marked blob %s missing", id)
+		}
+	}
+}
+
+// applyLifecycle enforces the buckets' lifecycle rules every interval.
+func applyLifecycle(ctx context.Context, s *store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		// A pass that fails part way still reports what it removed.
+		report, err := s.ApplyLifecycle(ctx, store.LifecycleOptions{})
+		if report != nil {
+			for _, action := range report.Actions {
+				log.Printf("Lifecycle rule %q: %s", action.Rule, describeLifecycleAction(action))
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to apply lifecycle rules: %v", err)
+		}
+	}
+}
+
+// deliverWebhooks attempts the webhook deliveries that are due every
+// interval.
+func deliverWebhooks(ctx context.Context, s *store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		result, err := s.DeliverWebhooks(ctx)
+		if err != nil {
+			log.Printf("Failed to deliver webhooks: %v", err)
+			continue
+		}
+		if result.Dead > 0 {
+			log.Printf("Dead-lettered %d webhook deliveries", result.Dead)
+		}
+	}
+}
+
+// pruneChanges deletes the changes past their retention every interval.
+func pruneChanges(ctx context.Context, s *store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := s.PruneChanges(ctx)
+		if err != nil {
+			log.Printf("Failed to prune changes: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Pruned %d changes", n)
+		}
+	}
 }
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	key, err := s.CreateAPIKey(ctx, true)
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	if len(key.AccessKeyID) != 20 || key.Secret == "" {
+		t.Errorf("Unexpected key %+v", key)
+	}
+	if _, err := s.CreateAPIKey(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.ListAPIKeys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Expected two keys, got %d: %v", len(keys), err)
+	}
+
+	disabled, err := s.SetAPIKeyDisabled(ctx, key.AccessKeyID, true)
+	if err != nil || !disabled.Disabled {
+		t.Fatalf("Failed to disable key: %v", err)
+	}
+	if got, _ := s.GetAPIKey(ctx, key.AccessKeyID); !got.Disabled || !got.Admin {
+		t.Errorf("Expected a disabled admin key, got %+v", got)
+	}
+
+	rotated, err := s.RotateAPIKey(ctx, key.AccessKeyID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Secret == key.Secret || rotated.PreviousSecret != key.Secret {
+		t.Error("Expected rotation to keep the previous secret")
+	}
+	got, _ := s.GetAPIKey(ctx, key.AccessKeyID)
+	if secrets := got.Secrets(time.Now()); len(secrets) != 2 || secrets[0] != rotated.Secret {
+		t.Errorf("Expected both secrets within the grace period, got %v", secrets)
+	}
+	if secrets := got.Secrets(time.Now().Add(2 * time.Hour)); len(secrets) != 1 {
+		t.Errorf("Expected the previous secret to expire, got %v", secrets)
+	}
+
+	rotated, err = s.RotateAPIKey(ctx, key.AccessKeyID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets := rotated.Secrets(time.Now()); len(secrets) != 1 {
+		t.Errorf("Expected a rotation without grace to drop the previous secret, got %v", secrets)
+	}
+
+	if _, err := s.GetAPIKey(ctx, "nope"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound, got %v", err)
+	}
+	if _, err := s.RotateAPIKey(ctx, "nope", 0); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrBlobNotFound is returned by a Backend when no blob has the requested
+// name.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Backend stores the content of blobs under their blob IDs. Store stages
+// incoming content in the local storage directory to hash it, then hands it
+// to the configured Backend.
+type Backend interface {
+	// Put stores size bytes read from r under name, replacing any blob with
+	// that name.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	// Get returns a reader for the blob with the given name. The reader
+	// also implements io.Seeker when the backend can read from an offset.
+	// The caller must close it.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// Stat describes the blob with the given name.
+	Stat(ctx context.Context, name string) (*BlobInfo, error)
+	// Delete removes the blob with the given name.
+	Delete(ctx context.Context, name string) error
+	// List returns the names of all stored blobs.
+	List(ctx context.Context) ([]string, error)
+}
+
+// BlobInfo describes a blob stored by a Backend.
+type BlobInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend names accepted in Config.
+const (
+	BackendLocal  = "local"
+	BackendMemory = "memory"
+	BackendS3     = "s3"
+)
+
+// newBackend returns the backend selected by the configuration of fs. The
+// local backend is fs itself.
+func newBackend(fs *FileStorage) (Backend, error) {
+	switch fs.config.Backend {
+	case "", BackendLocal:
+		return fs, nil
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	case BackendS3:
+		return NewS3Backend(fs.config.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", fs.config.Backend)
+	}
+}
+
+// putTemp moves a staged file into backend under name. The local backend
+// takes the file over with a rename; other backends copy it.
+func putTemp(ctx context.Context, backend Backend, fs *FileStorage, tmp *TempFile, name string) error {
+	if backend == Backend(fs) {
+		return fs.Commit(tmp, name)
+	}
+
+	f, err := fs.openTemp(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := backend.Put(ctx, name, f, tmp.Size); err != nil {
+		return err
+	}
+	return fs.Discard(tmp)
+}
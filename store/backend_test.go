@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackends(t *testing.T) {
+	s3Server := newFakeS3()
+	defer s3Server.Close()
+
+	backends := []struct {
+		name string
+		new  func(t *testing.T) Backend
+	}{
+		{"Local", func(t *testing.T) Backend { return resetFileStorage(t, t.TempDir()) }},
+		{"Memory", func(t *testing.T) Backend { return NewMemoryBackend() }},
+		{"S3", func(t *testing.T) Backend {
+			b, err := NewS3Backend(S3Config{
+				Endpoint:        s3Server.URL,
+				Bucket:          "blobs",
+				Prefix:          filepath.Base(t.Name()) + "/",
+				AccessKeyID:     "test",
+				SecretAccessKey: "test",
+				UsePathStyle:    true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}},
+	}
+
+	for _, tc := range backends {
+		t.Run(tc.name, func(t *testing.T) {
+			testBackend(t, tc.new(t))
+		})
+	}
+}
+
+func testBackend(t *testing.T, b Backend) {
+	ctx := t.Context()
+	data := []byte("0123456789")
+	if err := b.Put(ctx, "blob", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	info, err := b.Stat(ctx, "blob")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Name != "blob" || info.Size != int64(len(data)) {
+		t.Errorf("Unexpected blob info: %+v", info)
+	}
+
+	rc, err := b.Get(ctx, "blob")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected content %q, got %q", data, got)
+	}
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		if _, err := rs.Seek(6, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		got, _ = io.ReadAll(rs)
+		if string(got) != "6789" {
+			t.Errorf("Expected content %q after seeking, got %q", "6789", got)
+		}
+	}
+	rc.Close()
+
+	replaced := []byte("replaced")
+	if err := b.Put(ctx, "blob", bytes.NewReader(replaced), int64(len(replaced))); err != nil {
+		t.Fatalf("Put over an existing blob failed: %v", err)
+	}
+	if err := b.Put(ctx, "other", bytes.NewReader(nil), 0); err != nil {
+		t.Fatalf("Put of an empty blob failed: %v", err)
+	}
+
+	names, err := b.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "blob" || names[1] != "other" {
+		t.Errorf("Expected blobs [blob other], got %v", names)
+	}
+
+	if err := b.Delete(ctx, "blob"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := b.Get(ctx, "blob"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound from Get, got %v", err)
+	}
+	if _, err := b.Stat(ctx, "blob"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound from Stat, got %v", err)
+	}
+	if err := b.Delete(ctx, "blob"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound from Delete, got %v", err)
+	}
+}
+
+func TestStoreWithMemoryBackend(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStoreWithConfig(t, Config{Backend: BackendMemory})
+
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("in memory")); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+	data, err := s.ReadObject(ctx, "a.txt")
+	if err != nil || string(data) != "in memory" {
+		t.Errorf("Expected content %q, got %q (%v)", "in memory", data, err)
+	}
+
+	names, err := s.FileStorage.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("Expected no blobs on local disk, found %v", names)
+	}
+	if names, _ := s.Backend.List(ctx); len(names) != 1 {
+		t.Errorf("Expected 1 blob in the memory backend, found %v", names)
+	}
+
+	if err := s.DeleteObject(ctx, "a.txt"); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if names, _ := s.Backend.List(ctx); len(names) != 0 {
+		t.Errorf("Expected the blob to be released, found %v", names)
+	}
+}
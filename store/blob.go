@@ -0,0 +1,361 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// storeBlob makes the content staged in tmp available as a blob and calls
+// record to reference it from the metadata store. If content with the same
+// hash is already stored with the same protection, that blob is reused and
+// tmp is discarded.
+//
+// A new blob is compressed with codec unless codec is empty or compressing
+// does not make it smaller. It is then encrypted under a new data key if
+// customerKey is set, which then wraps the data key, or if the store has a
+// keyring. Content encrypted under a customer key is never shared; reused
+// content keeps the codec it was first stored with.
+//
+// blobMu is held while a blob to reuse is looked up, and again from a second
+// lookup until record returns, so that releaseBlobs cannot delete a reused
+// blob's file between the lookup and the new reference. It is not held while
+// a new blob is written to the backend. The same content may be stored
+// meanwhile, in which case the second lookup finds it and the new blob is
+// dropped.
+//
+// A new blob is written under an intent, so that if the server stops before
+// record commits, recovery deletes the unreferenced file. Until the intent
+// is deleted, releaseBlobs and other writes of the same content leave the
+// file alone.
+func (s *Store) storeBlob(ctx context.Context, tmp *TempFile, codec string, customerKey []byte, record func(blob *Blob) error) error {
+	encrypted := customerKey != nil || s.keys != nil
+	hash := tmp.Hash
+	if customerKey != nil {
+		hash = ""
+	}
+
+	blob, intent, err := s.reserveBlob(ctx, tmp, hash, encrypted, record)
+	if blob == nil || err != nil {
+		return err
+	}
+
+	// Once the intent is recorded, the bookkeeping that follows runs even if
+	// ctx is canceled, so that nothing is left for recovery to clean up.
+	cleanupCtx := context.WithoutCancel(ctx)
+	if codec != "" {
+		compressed, err := s.compressTemp(ctx, tmp, codec)
+		if err != nil {
+			s.FileStorage.Discard(tmp)
+			s.abandonBlob(cleanupCtx, blob, intent)
+			return err
+		}
+		if compressed != nil {
+			s.FileStorage.Discard(tmp)
+			tmp = compressed
+			blob.Codec = codec
+			blob.EncodedSize = tmp.Size
+		}
+	}
+
+	if encrypted {
+		sealed, err := s.sealTemp(ctx, tmp, blob, customerKey)
+		s.FileStorage.Discard(tmp)
+		if err != nil {
+			s.abandonBlob(cleanupCtx, blob, intent)
+			return err
+		}
+		tmp = sealed
+	}
+
+	if err := putTemp(ctx, s.Backend, s.FileStorage, tmp, blob.ID); err != nil {
+		s.FileStorage.Discard(tmp)
+		s.abandonBlob(cleanupCtx, blob, intent)
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	reused, err := s.recordBlob(ctx, hash, encrypted, blob, record)
+	if reused || err != nil {
+		s.dropBlob(cleanupCtx, blob, intent)
+		return err
+	}
+	return s.MetadataStore.DeleteIntent(cleanupCtx, intent.ID)
+}
+
+// reserveBlob calls record with the blob already holding tmp's content, if
+// hash is set and there is one, and then returns a nil blob. Otherwise it
+// returns a new blob for the content and the intent recorded to put it.
+func (s *Store) reserveBlob(ctx context.Context, tmp *TempFile, hash string, encrypted bool, record func(blob *Blob) error) (*Blob, *Intent, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if reused, err := s.recordBlob(ctx, hash, encrypted, nil, record); reused || err != nil {
+		s.FileStorage.Discard(tmp)
+		return nil, nil, err
+	}
+
+	// Blobs stored before content addressing are named after their old
+	// file, which may coincide with this hash without holding this content.
+	// Encrypted blobs are not named after their hash, which would reveal
+	// which content they hold.
+	blob := &Blob{ID: tmp.Hash, Hash: tmp.Hash, Size: tmp.Size, EncodedSize: tmp.Size}
+	if _, err := s.MetadataStore.GetBlob(ctx, blob.ID); err == nil || encrypted {
+		blob.ID = newID()
+	}
+
+	intent := &Intent{Kind: IntentPutBlob, BlobID: blob.ID, CreatedAt: time.Now()}
+	if err := s.MetadataStore.CreateIntent(ctx, intent); err != nil {
+		s.FileStorage.Discard(tmp)
+		return nil, nil, err
+	}
+	return blob, intent, nil
+}
+
+// recordBlob calls record with the blob already holding the content with
+// hash, if hash is set and there is one, and reports whether it did.
+// Otherwise it calls record with blob, unless blob is nil. The caller holds
+// blobMu.
+func (s *Store) recordBlob(ctx context.Context, hash string, encrypted bool, blob *Blob, record func(blob *Blob) error) (bool, error) {
+	if hash != "" {
+		existing, err := s.MetadataStore.GetBlobByHash(ctx, hash, encrypted)
+		if err == nil {
+			return true, record(existing)
+		}
+		if !errors.Is(err, ErrMetadataNotFound) {
+			return false, err
+		}
+	}
+	if blob == nil {
+		return false, nil
+	}
+	return false, record(blob)
+}
+
+// abandonBlob deletes the intent of a new blob that failed to be written.
+func (s *Store) abandonBlob(ctx context.Context, blob *Blob, intent *Intent) {
+	if err := s.MetadataStore.DeleteIntent(ctx, intent.ID); err != nil {
+		logger(ctx).Warn("failed to delete the intent of a failed write", "blob_id", blob.ID, "error", err)
+	}
+}
+
+// dropBlob deletes a new blob that was not recorded, along with the intent
+// it was written under. The file is kept if blobInUse says so. The caller
+// holds blobMu.
+func (s *Store) dropBlob(ctx context.Context, blob *Blob, intent *Intent) {
+	inUse, err := s.blobInUse(ctx, blob.ID, intent.ID)
+	if err != nil {
+		logger(ctx).Warn("failed to check whether an unused blob is shared", "blob_id", blob.ID, "error", err)
+		return
+	}
+	if !inUse {
+		if err := s.Backend.Delete(ctx, blob.ID); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			logger(ctx).Warn("failed to delete an unused blob", "blob_id", blob.ID, "error", err)
+			return
+		}
+	}
+	if err := s.MetadataStore.DeleteIntent(ctx, intent.ID); err != nil {
+		logger(ctx).Warn("failed to delete the intent of an unused blob", "blob_id", blob.ID, "error", err)
+	}
+}
+
+// blobInUse reports whether a blob's file must be kept because the blob is
+// recorded or a write other than the one under intentID is putting it. The
+// caller holds blobMu, under which such writes record their intents.
+func (s *Store) blobInUse(ctx context.Context, blobID string, intentID int64) (bool, error) {
+	if _, err := s.MetadataStore.GetBlob(ctx, blobID); !errors.Is(err, ErrMetadataNotFound) {
+		return err == nil, err
+	}
+	return s.MetadataStore.HasPutIntent(ctx, blobID, intentID)
+}
+
+// sealTemp encrypts the content staged in tmp under a new data key, which it
+// wraps for blob with customerKey if set and the active master key
+// otherwise. It returns the encrypted content, staged.
+func (s *Store) sealTemp(ctx context.Context, tmp *TempFile, blob *Blob, customerKey []byte) (*TempFile, error) {
+	dataKey := newKey()
+	var err error
+	if customerKey != nil {
+		blob.KeyID = CustomerKeyID
+		blob.WrappedKey, err = wrapKey(customerKey, dataKey, blob.ID)
+	} else {
+		blob.KeyID, blob.WrappedKey, err = s.keys.wrap(dataKey, blob.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	f, err := s.FileStorage.openTemp(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := newEncryptReader(f, dataKey, tmp.Size)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.FileStorage.WriteTemp(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt file: %w", err)
+	}
+	return sealed, nil
+}
+
+// openBlob returns a reader for the plaintext of a blob. keyID and codec are
+// the KeyID and Codec recorded for the content; opts.CustomerKey is required
+// if keyID is CustomerKeyID. Compressed content is decompressed, unless
+// opts accepts its codec, in which case the reader is an *EncodedReader.
+func (s *Store) openBlob(ctx context.Context, blobID, keyID, codec string, opts ReadOptions) (io.ReadCloser, error) {
+	var blob *Blob
+	var dataKey []byte
+	if keyID != "" || codec != "" {
+		var err error
+		if blob, err = s.MetadataStore.GetBlob(ctx, blobID); err != nil {
+			return nil, fmt.Errorf("failed to get blob: %w", err)
+		}
+	}
+	if keyID != "" {
+		var err error
+		if dataKey, err = s.unwrapDataKey(blob, opts.CustomerKey); err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := s.Backend.Get(ctx, blobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", s.checkBlob(ctx, blobID, err))
+	}
+	rc = newContextReadCloser(ctx, rc)
+	if dataKey != nil {
+		dr, err := newDecryptReader(rc, dataKey, blob.EncodedSize)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		rc = dr
+	}
+
+	switch {
+	case codec == "":
+		return rc, nil
+	case acceptsCodec(opts, codec):
+		return &EncodedReader{ReadCloser: rc, Codec: codec, Size: blob.EncodedSize}, nil
+	default:
+		return decompressReader(rc, codec)
+	}
+}
+
+// unwrapDataKey returns the data key of an encrypted blob.
+func (s *Store) unwrapDataKey(blob *Blob, customerKey []byte) ([]byte, error) {
+	if blob.KeyID == CustomerKeyID {
+		if customerKey == nil {
+			return nil, fmt.Errorf("%w: %s", ErrCustomerKeyRequired, blob.ID)
+		}
+		dataKey, err := unwrapKey(customerKey, blob.WrappedKey, blob.ID)
+		if errors.Is(err, errDecrypt) {
+			return nil, fmt.Errorf("%w: %s", ErrCustomerKeyMismatch, blob.ID)
+		}
+		return dataKey, err
+	}
+
+	if s.keys == nil {
+		return nil, fmt.Errorf("%w: %s (no keyfile is configured)", ErrKeyNotFound, blob.KeyID)
+	}
+	dataKey, err := s.keys.unwrap(blob.KeyID, blob.WrappedKey, blob.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of blob %s: %w", blob.ID, err)
+	}
+	return dataKey, nil
+}
+
+// releaseBlobs deletes the blobs among blobIDs that no version references
+// anymore, along with their files. Each deletion is made under an intent, so
+// that if the server stops after the blob's record is gone, recovery deletes
+// the file. It runs even if ctx is canceled, since the references it
+// follows up on are already gone.
+func (s *Store) releaseBlobs(ctx context.Context, blobIDs ...string) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	for _, blobID := range blobIDs {
+		if err := s.releaseBlob(ctx, blobID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) releaseBlob(ctx context.Context, blobID string) error {
+	intent := &Intent{Kind: IntentDeleteBlob, BlobID: blobID, CreatedAt: time.Now()}
+	if err := s.MetadataStore.CreateIntent(ctx, intent); err != nil {
+		return err
+	}
+
+	freed, err := s.MetadataStore.DeleteBlobIfUnreferenced(ctx, blobID)
+	if err != nil {
+		return err
+	}
+	if freed {
+		// A write of the same content may be putting the file again.
+		inUse, err := s.blobInUse(ctx, blobID, 0)
+		if err != nil {
+			return err
+		}
+		if !inUse {
+			if err := s.Backend.Delete(ctx, blobID); err != nil && !errors.Is(err, ErrBlobNotFound) {
+				return fmt.Errorf("failed to delete file: %w", err)
+			}
+		}
+	}
+	return s.MetadataStore.DeleteIntent(ctx, intent.ID)
+}
+
+// recoverIntents finishes or undoes the blob operations that were in
+// progress when the server last stopped. Both kinds of intent resolve the
+// same way: a blob that has a record is kept, because its write committed or
+// its deletion never took effect; a blob without one is deleted, because its
+// write was never recorded or its deletion was interrupted.
+//
+// It must run before the store serves requests.
+func (s *Store) recoverIntents(ctx context.Context) error {
+	intents, err := s.MetadataStore.ListIntents(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, intent := range intents {
+		_, err := s.MetadataStore.GetBlob(ctx, intent.BlobID)
+		if errors.Is(err, ErrMetadataNotFound) {
+			err = s.Backend.Delete(ctx, intent.BlobID)
+			if errors.Is(err, ErrBlobNotFound) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to recover %s intent for blob %s: %w", intent.Kind, intent.BlobID, err)
+		}
+		if err := s.MetadataStore.DeleteIntent(ctx, intent.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContentHash returns the hex-encoded SHA-256 of a blob's content. Blobs
+// stored before content addressing were never hashed; for those it returns
+// the blob ID, which like a hash is never reused for different content.
+func (s *Store) ContentHash(ctx context.Context, blobID string) (string, error) {
+	blob, err := s.MetadataStore.GetBlob(ctx, blobID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get blob: %w", err)
+	}
+	if blob.Hash == "" {
+		return blob.ID, nil
+	}
+	return blob.Hash, nil
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBlobDeduplication(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	countFiles := func() int {
+		t.Helper()
+		names, err := s.FileStorage.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list files: %v", err)
+		}
+		return len(names)
+	}
+
+	content := []byte("identical content")
+	firstID, err := s.CreateObject(ctx, "first.txt", content)
+	if err != nil {
+		t.Fatalf("Failed to create first object: %v", err)
+	}
+	secondID, err := s.CreateObject(ctx, "second.txt", content)
+	if err != nil {
+		t.Fatalf("Failed to create object with identical content: %v", err)
+	}
+	if firstID == secondID {
+		t.Errorf("Objects at different paths share the ID %s", firstID)
+	}
+	if n := countFiles(); n != 1 {
+		t.Errorf("Expected identical content to share one file, found %d", n)
+	}
+
+	if _, err := s.CreateObject(ctx, "first.txt", []byte("other")); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("Expected ErrObjectExists, got %v", err)
+	}
+
+	first, err := s.StatObject(ctx, "first.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat object: %v", err)
+	}
+	blob, err := s.MetadataStore.GetBlob(ctx, first.BlobID)
+	if err != nil {
+		t.Fatalf("Failed to get blob: %v", err)
+	}
+	if blob.RefCount != 2 {
+		t.Errorf("Expected blob to be referenced twice, got %d", blob.RefCount)
+	}
+
+	if err := s.UpdateObject(ctx, "second.txt", []byte("changed content")); err != nil {
+		t.Fatalf("Failed to update object: %v", err)
+	}
+	second, err := s.StatObject(ctx, "second.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat object: %v", err)
+	}
+	if second.BlobID == first.BlobID {
+		t.Error("Updated object still points at its old blob")
+	}
+	if n := countFiles(); n != 2 {
+		t.Errorf("Expected 2 files after update, found %d", n)
+	}
+
+	// The old content is still referenced by first.txt and by the noncurrent
+	// version of second.txt.
+	if err := s.DeleteObject(ctx, "first.txt"); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	versions, err := s.ListVersions(ctx, "second.txt")
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	rc, err := s.OpenObjectVersion(ctx, "second.txt", versions[1].VersionID)
+	if err != nil {
+		t.Fatalf("Failed to open noncurrent version: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != string(content) {
+		t.Errorf("Noncurrent version content = %q, want %q", data, content)
+	}
+
+	if err := s.DeleteObject(ctx, "second.txt"); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if n := countFiles(); n != 0 {
+		t.Errorf("Expected all blobs to be freed, found %d files", n)
+	}
+	if _, err := s.MetadataStore.GetBlob(ctx, first.BlobID); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("Expected freed blob row to be deleted, got %v", err)
+	}
+}
+
+// stallingBackend wraps a Backend and stalls the first Put until released.
+type stallingBackend struct {
+	Backend
+	stalling atomic.Bool
+	stalled  chan struct{}
+	release  chan struct{}
+}
+
+func newStallingBackend(backend Backend) *stallingBackend {
+	return &stallingBackend{Backend: backend, stalled: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (b *stallingBackend) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if b.stalling.CompareAndSwap(false, true) {
+		close(b.stalled)
+		<-b.release
+	}
+	return b.Backend.Put(ctx, name, r, size)
+}
+
+func TestConcurrentBlobWrites(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	if _, err := s.CreateObject(ctx, "old.txt", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	backend := newStallingBackend(s.Backend)
+	s.Backend = backend
+
+	content := []byte("shared content")
+	done := make(chan error)
+	go func() {
+		_, err := s.CreateObject(ctx, "slow.txt", content)
+		done <- err
+	}()
+	<-backend.stalled
+
+	// Other writes and deletions go ahead while the first write stalls.
+	if _, err := s.CreateObject(ctx, "fast.txt", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, "old.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	close(backend.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The stalled write finds the content stored meanwhile and reuses it.
+	slow, err := s.StatObject(ctx, "slow.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := s.StatObject(ctx, "fast.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow.BlobID != fast.BlobID {
+		t.Errorf("Expected both objects to share a blob, got %s and %s", slow.BlobID, fast.BlobID)
+	}
+	if blobs := listBlobs(t, s); len(blobs) != 1 {
+		t.Errorf("Expected one blob, got %v", blobs)
+	}
+	checkNoIntents(t, s)
+}
+
+func TestReleaseSparesBlobBeingPut(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := s.StatObject(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another write of the same content is putting the file again.
+	intent := &Intent{Kind: IntentPutBlob, BlobID: metadata.BlobID, CreatedAt: time.Now()}
+	if err := s.MetadataStore.CreateIntent(ctx, intent); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if blobs := listBlobs(t, s); len(blobs) != 1 {
+		t.Errorf("Expected the file to be kept for the write in progress, got %v", blobs)
+	}
+	if _, err := s.MetadataStore.GetBlob(ctx, metadata.BlobID); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("Expected the blob record to be deleted, got %v", err)
+	}
+}
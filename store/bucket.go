@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrBucketNotFound is returned when a bucket does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists is returned when creating a bucket that already exists.
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNotEmpty is returned when deleting a bucket that still holds objects.
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+	// ErrInvalidBucketName is returned for names that are not valid S3 bucket names.
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	// ErrInvalidBucketSettings is returned for negative quotas or retention
+	// periods, unknown compression codecs or retention modes and invalid
+	// lifecycle rules.
+	ErrInvalidBucketSettings = errors.New("invalid bucket settings")
+	// ErrDefaultBucket is returned when deleting the default bucket.
+	ErrDefaultBucket = errors.New("the default bucket cannot be deleted")
+)
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// CreateBucket registers a new, empty bucket with the given settings.
+func (s *Store) CreateBucket(ctx context.Context, bucket *Bucket) error {
+	if !bucketNamePattern.MatchString(bucket.Name) {
+		return fmt.Errorf("%w: %s", ErrInvalidBucketName, bucket.Name)
+	}
+	if err := validateBucketSettings(bucket); err != nil {
+		return err
+	}
+
+	if _, err := s.MetadataStore.GetBucket(ctx, bucket.Name); err == nil {
+		return fmt.Errorf("%w: %s", ErrBucketExists, bucket.Name)
+	}
+
+	bucket.CreatedAt = time.Now()
+	return s.MetadataStore.CreateBucket(ctx, bucket)
+}
+
+// GetBucket returns the bucket with the given name.
+func (s *Store) GetBucket(ctx context.Context, name string) (*Bucket, error) {
+	bucket, err := s.MetadataStore.GetBucket(ctx, name)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, name)
+	}
+	return bucket, err
+}
+
+// ListBuckets returns all buckets ordered by name.
+func (s *Store) ListBuckets(ctx context.Context) ([]*Bucket, error) {
+	return s.MetadataStore.ListBuckets(ctx)
+}
+
+// UpdateBucket replaces the settings of an existing bucket.
+func (s *Store) UpdateBucket(ctx context.Context, bucket *Bucket) error {
+	if _, err := s.GetBucket(ctx, bucket.Name); err != nil {
+		return err
+	}
+	if err := validateBucketSettings(bucket); err != nil {
+		return err
+	}
+	return s.MetadataStore.UpdateBucket(ctx, bucket)
+}
+
+// DeleteBucket removes an empty bucket.
+func (s *Store) DeleteBucket(ctx context.Context, name string) error {
+	if name == DefaultBucket {
+		return ErrDefaultBucket
+	}
+	if _, err := s.GetBucket(ctx, name); err != nil {
+		return err
+	}
+
+	objects, err := s.InBucket(name).ListObjects(ctx, ListOptions{Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(objects.Objects) > 0 {
+		return fmt.Errorf("%w: %s", ErrBucketNotEmpty, name)
+	}
+	// Noncurrent versions and pending uploads would be orphaned as well.
+	hasData, err := s.MetadataStore.HasBucketData(ctx, name)
+	if err != nil {
+		return err
+	}
+	if hasData {
+		return fmt.Errorf("%w: %s", ErrBucketNotEmpty, name)
+	}
+
+	return s.MetadataStore.DeleteBucket(ctx, name)
+}
+
+// BucketUsage returns the total size of a bucket's current objects.
+func (s *Store) BucketUsage(ctx context.Context, name string) (int64, error) {
+	return s.MetadataStore.BucketUsage(ctx, name)
+}
+
+func validateBucketSettings(bucket *Bucket) error {
+	if bucket.QuotaBytes < 0 || bucket.DefaultRetentionDays < 0 {
+		return fmt.Errorf("%w: quota and retention must not be negative", ErrInvalidBucketSettings)
+	}
+	if bucket.DefaultRetentionDays > maxDays {
+		return fmt.Errorf("%w: default retention must not exceed %d days", ErrInvalidBucketSettings, maxDays)
+	}
+	switch bucket.Compression {
+	case "", CodecGzip, CodecZstd:
+	default:
+		return fmt.Errorf("%w: unknown compression codec %q", ErrInvalidBucketSettings, bucket.Compression)
+	}
+	switch bucket.DefaultRetentionMode {
+	case "", RetentionGovernance, RetentionCompliance:
+	default:
+		return fmt.Errorf("%w: unknown retention mode %q", ErrInvalidBucketSettings, bucket.DefaultRetentionMode)
+	}
+	return validateLifecycle(bucket.Lifecycle)
+}
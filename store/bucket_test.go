@@ -0,0 +1,241 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	return newTestStoreWithConfig(t, Config{})
+}
+
+// newTestStoreWithConfig creates a store in a temporary directory, which it
+// sets as config's storage directory.
+func newTestStoreWithConfig(t *testing.T, config Config) *Store {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.json")
+	config.StorageDirectory = filepath.Join(tempDir, "storage")
+	configData, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, configData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStore(configFile, filepath.Join(tempDir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBuckets(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	if err := s.CreateBucket(ctx, &Bucket{Name: "Invalid_Name"}); !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("Expected ErrInvalidBucketName, got %v", err)
+	}
+
+	if err := s.CreateBucket(ctx, &Bucket{Name: "logs", Versioning: true}); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if err := s.CreateBucket(ctx, &Bucket{Name: "logs"}); !errors.Is(err, ErrBucketExists) {
+		t.Errorf("Expected ErrBucketExists, got %v", err)
+	}
+
+	buckets, err := s.ListBuckets(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %v", err)
+	}
+	if len(buckets) != 2 || buckets[0].Name != DefaultBucket || buckets[1].Name != "logs" {
+		t.Errorf("Unexpected buckets: %+v", buckets)
+	}
+
+	logs := s.InBucket("logs")
+	if _, err := logs.CreateObject(ctx, "app.log", []byte("log line")); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+	if _, err := s.ReadObject(ctx, "app.log"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Object should not be visible in the default bucket, got %v", err)
+	}
+	if _, err := s.CreateObject(ctx, "app.log", []byte("other log line")); err != nil {
+		t.Errorf("Same path in another bucket should not collide: %v", err)
+	}
+
+	if err := s.DeleteBucket(ctx, "logs"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v", err)
+	}
+	if err := logs.DeleteObject(ctx, "app.log"); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if err := s.DeleteBucket(ctx, "logs"); err != nil {
+		t.Fatalf("Failed to delete bucket: %v", err)
+	}
+	if _, err := s.GetBucket(ctx, "logs"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if _, err := logs.CreateObject(ctx, "late.log", []byte("x")); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if err := s.DeleteBucket(ctx, DefaultBucket); !errors.Is(err, ErrDefaultBucket) {
+		t.Errorf("Expected ErrDefaultBucket, got %v", err)
+	}
+}
+
+func TestDeleteBucketWithLeftovers(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	if err := s.CreateBucket(ctx, &Bucket{Name: "logs", Versioning: true}); err != nil {
+		t.Fatal(err)
+	}
+	logs := s.InBucket("logs")
+
+	// A pending multipart upload keeps the bucket in use.
+	upload, err := logs.InitiateUpload(ctx, "big.log", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBucket(ctx, "logs"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty with a pending upload, got %v", err)
+	}
+	if err := logs.AbortUpload(ctx, upload.UploadID); err != nil {
+		t.Fatal(err)
+	}
+
+	// So do versions left without a current object.
+	objectID, err := logs.CreateObject(ctx, "app.log", []byte("log line"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MetadataStore.db.ExecContext(ctx, "DELETE FROM metadata WHERE object_id = ?", objectID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBucket(ctx, "logs"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty with leftover versions, got %v", err)
+	}
+	if _, err := s.GetBucket(ctx, "logs"); err != nil {
+		t.Errorf("Expected the bucket to remain, got %v", err)
+	}
+}
+
+func TestBucketSettings(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	if err := s.CreateBucket(ctx, &Bucket{Name: "scratch", QuotaBytes: 10}); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	scratch := s.InBucket("scratch")
+
+	if _, err := scratch.CreateObject(ctx, "a", []byte("12345678")); err != nil {
+		t.Fatalf("Failed to create object within quota: %v", err)
+	}
+	if _, err := scratch.CreateObject(ctx, "b", []byte("123")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	if err := scratch.UpdateObject(ctx, "a", []byte("1234567890")); err != nil {
+		t.Errorf("Update within quota failed: %v", err)
+	}
+
+	// Unversioned buckets keep only the current version
+	versions, err := scratch.ListVersions(ctx, "a")
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("Expected a single version in an unversioned bucket, got %d", len(versions))
+	}
+	names, err := s.FileStorage.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(names) != 1 {
+		t.Errorf("Expected the replaced version's file to be deleted, found %v", names)
+	}
+
+	err = s.UpdateBucket(ctx, &Bucket{Name: "scratch", Versioning: true, QuotaBytes: -1})
+	if !errors.Is(err, ErrInvalidBucketSettings) {
+		t.Errorf("Expected ErrInvalidBucketSettings, got %v", err)
+	}
+	err = s.UpdateBucket(ctx, &Bucket{Name: "scratch", Compression: "lzma"})
+	if !errors.Is(err, ErrInvalidBucketSettings) {
+		t.Errorf("Expected ErrInvalidBucketSettings for an unknown codec, got %v", err)
+	}
+	err = s.UpdateBucket(ctx, &Bucket{Name: "scratch", DefaultRetentionDays: 200000})
+	if !errors.Is(err, ErrInvalidBucketSettings) {
+		t.Errorf("Expected ErrInvalidBucketSettings for a default retention overflowing a duration, got %v", err)
+	}
+	if err := s.UpdateBucket(ctx, &Bucket{Name: "scratch", Versioning: true, DefaultRetentionDays: 30}); err != nil {
+		t.Fatalf("Failed to update bucket: %v", err)
+	}
+	bucket, err := s.GetBucket(ctx, "scratch")
+	if err != nil {
+		t.Fatalf("Failed to get bucket: %v", err)
+	}
+	if !bucket.Versioning || bucket.QuotaBytes != 0 || bucket.DefaultRetentionDays != 30 {
+		t.Errorf("Bucket settings were not updated: %+v", bucket)
+	}
+}
+
+func TestConcurrentWritesWithinQuota(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	if err := s.CreateBucket(ctx, &Bucket{Name: "scratch", QuotaBytes: 100}); err != nil {
+		t.Fatal(err)
+	}
+	scratch := s.InBucket("scratch")
+
+	// Every write fits on its own, but only ten fit together.
+	const n = 30
+	errs := make(chan error, n)
+	for i := range n {
+		go func() {
+			_, err := scratch.CreateObject(ctx, fmt.Sprintf("%d.txt", i), fmt.Appendf(nil, "%010d", i))
+			errs <- err
+		}()
+	}
+	for range n {
+		if err := <-errs; err != nil && !errors.Is(err, ErrQuotaExceeded) {
+			t.Error(err)
+		}
+	}
+	if usage, err := s.BucketUsage(ctx, "scratch"); err != nil || usage > 100 {
+		t.Errorf("Expected usage within the quota, got %d (%v)", usage, err)
+	}
+}
+
+func TestRestoreWithinQuota(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	if err := s.CreateBucket(ctx, &Bucket{Name: "scratch", Versioning: true, QuotaBytes: 10}); err != nil {
+		t.Fatal(err)
+	}
+	scratch := s.InBucket("scratch")
+	if _, err := scratch.CreateObject(ctx, "a", []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if err := scratch.UpdateObject(ctx, "a", []byte("12")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scratch.CreateObject(ctx, "b", []byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := scratch.ListVersions(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scratch.RestoreVersion(ctx, "a", versions[1].VersionID); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected restoring the larger version to exceed the quota, got %v", err)
+	}
+	if data, _ := scratch.ReadObject(ctx, "a"); string(data) != "12" {
+		t.Errorf("Expected the current content to remain, got %q", data)
+	}
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrChangesExpired is returned when asked for changes that were pruned from
+// the change feed. A consumer that gets it must resynchronize.
+var ErrChangesExpired = errors.New("changes expired")
+
+// maxChangeLimit caps the number of changes ListChanges returns at once.
+const maxChangeLimit = 1000
+
+// ChangeOptions selects the changes ListChanges returns.
+type ChangeOptions struct {
+	// Since is the sequence number of the last change the consumer has
+	// seen; only later changes are returned. Zero asks for every change
+	// since the first, and fails with ErrChangesExpired once any change
+	// has been pruned.
+	Since int64
+	// Prefix limits the changes to objects whose paths start with it.
+	Prefix string
+	// Limit caps the number of changes returned. Zero or anything above
+	// 1000 means 1000.
+	Limit int
+}
+
+// ListChanges returns the changes to the bucket's objects after
+// opts.Since, oldest first. Every change to an object's metadata is
+// recorded with a sequence number that increases with each change, so a
+// consumer can resume from the last change it saw. It returns
+// ErrChangesExpired if changes after opts.Since were pruned.
+func (s *Store) ListChanges(ctx context.Context, opts ChangeOptions) ([]*Event, error) {
+	oldest, err := s.MetadataStore.OldestChange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Since+1 < oldest {
+		return nil, fmt.Errorf("%w: the oldest change kept is %d", ErrChangesExpired, oldest)
+	}
+	if opts.Limit <= 0 || opts.Limit > maxChangeLimit {
+		opts.Limit = maxChangeLimit
+	}
+	return s.MetadataStore.ListChanges(ctx, s.BucketName(), opts.Prefix, opts.Since, opts.Limit)
+}
+
+// ChangeNotify returns a channel that is closed when the next change is
+// recorded. Receive it before calling ListChanges so that no change goes
+// unnoticed in between.
+func (s *Store) ChangeNotify() <-chan struct{} {
+	return s.MetadataStore.changes.wait()
+}
+
+// PruneChanges deletes the changes older than the configured change
+// retention and returns how many it deleted.
+func (s *Store) PruneChanges(ctx context.Context) (int64, error) {
+	return s.MetadataStore.PruneChanges(ctx, time.Now().Add(-s.Config().ChangeRetention()))
+}
+
+// changeNotifier wakes the goroutines waiting for changes.
+type changeNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{ch: make(chan struct{})}
+}
+
+// wait returns a channel that notify closes.
+func (n *changeNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *changeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListChanges(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	objectID, err := s.CreateObject(ctx, "logs/a.txt", []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "data/b.txt", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateObject(ctx, objectID, []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetLegalHold(ctx, objectID, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetLegalHold(ctx, objectID, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, objectID); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := s.ListChanges(ctx, ChangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []string{EventObjectCreated, EventObjectCreated, EventObjectUpdated, EventObjectLockChanged, EventObjectLockChanged, EventObjectDeleted}
+	if len(changes) != len(wantTypes) {
+		t.Fatalf("Expected %d changes, got %d", len(wantTypes), len(changes))
+	}
+	for i, change := range changes {
+		if change.Type != wantTypes[i] || change.Seq != int64(i+1) {
+			t.Errorf("Unexpected change %d: %+v", i, change)
+		}
+	}
+
+	// Resuming from a sequence number returns only later changes.
+	changes, err = s.ListChanges(ctx, ChangeOptions{Since: 3, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Seq != 4 || changes[1].Seq != 5 {
+		t.Errorf("Unexpected changes after 3: %+v", changes)
+	}
+
+	changes, err = s.ListChanges(ctx, ChangeOptions{Prefix: "data/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].ObjectPath != "data/b.txt" {
+		t.Errorf("Unexpected changes under data/: %+v", changes)
+	}
+
+	// Other buckets have changes of their own, in the same sequence.
+	if err := s.CreateBucket(ctx, &Bucket{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InBucket("other").CreateObject(ctx, "c.txt", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = s.InBucket("other").ListChanges(ctx, ChangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Seq != 7 || changes[0].Bucket != "other" {
+		t.Errorf("Unexpected changes in other bucket: %+v", changes)
+	}
+}
+
+func TestPruneChanges(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := s.CreateObject(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.PruneChanges(ctx); err != nil || n != 0 {
+		t.Fatalf("Expected no changes past retention, got %d, %v", n, err)
+	}
+	if n, err := s.MetadataStore.PruneChanges(ctx, time.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Fatalf("Expected two changes pruned, got %d, %v", n, err)
+	}
+
+	// Consumers that saw every change can carry on; the others must
+	// resynchronize.
+	if changes, err := s.ListChanges(ctx, ChangeOptions{Since: 2}); err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes after 2, got %+v, %v", changes, err)
+	}
+	if _, err := s.ListChanges(ctx, ChangeOptions{Since: 1}); !errors.Is(err, ErrChangesExpired) {
+		t.Errorf("Expected ErrChangesExpired, got %v", err)
+	}
+
+	if _, err := s.CreateObject(ctx, "c.txt", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := s.ListChanges(ctx, ChangeOptions{Since: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Seq != 3 {
+		t.Errorf("Expected the sequence to carry on after pruning, got %+v", changes)
+	}
+}
+
+func TestChangeNotify(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	notify := s.ChangeNotify()
+	select {
+	case <-notify:
+		t.Fatal("Expected no notification before a change")
+	default:
+	}
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-notify:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a notification after a change")
+	}
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs content can be compressed with at rest. The names match the HTTP
+// content codings, so that compressed content can be served as stored.
+const (
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+	// CodecIdentity requests that content be stored uncompressed whatever
+	// the bucket's default.
+	CodecIdentity = "identity"
+)
+
+// ErrInvalidCodec is returned when a write or a bucket names an unknown
+// codec.
+var ErrInvalidCodec = errors.New("invalid compression codec")
+
+// checkCodec reports whether codec can be requested: a codec, CodecIdentity,
+// or empty for the default.
+func checkCodec(codec string) error {
+	switch codec {
+	case "", CodecIdentity, CodecGzip, CodecZstd:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidCodec, codec)
+}
+
+// writeCodec returns the codec new content is compressed with: the one
+// opts requests, else the bucket's default. Content the client encoded
+// itself is stored as sent.
+func writeCodec(bucket *Bucket, opts WriteOptions) string {
+	if opts.Headers.ContentEncoding != "" {
+		return ""
+	}
+	codec := opts.Compression
+	if codec == "" {
+		codec = bucket.Compression
+	}
+	if codec == CodecIdentity {
+		return ""
+	}
+	return codec
+}
+
+// EncodedReader is returned in place of the plain content when a read
+// accepts the codec the content is stored with. It reads the content still
+// compressed.
+type EncodedReader struct {
+	io.ReadCloser
+	// Codec is the codec the content is compressed with.
+	Codec string
+	// Size is the size of the compressed content.
+	Size int64
+}
+
+// compressTemp compresses the content staged in tmp with codec. It returns
+// nil if compressing does not make the content smaller, in which case it is
+// better stored as it is.
+func (s *Store) compressTemp(ctx context.Context, tmp *TempFile, codec string) (*TempFile, error) {
+	f, err := s.FileStorage.openTemp(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(compress(pw, f, codec))
+	}()
+	compressed, err := s.FileStorage.WriteTemp(ctx, pr)
+	pr.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress file: %w", err)
+	}
+
+	if compressed.Size >= tmp.Size {
+		s.FileStorage.Discard(compressed)
+		return nil, nil
+	}
+	return compressed, nil
+}
+
+// compress writes the content read from r to w, compressed with codec.
+func compress(w io.Writer, r io.Reader, codec string) error {
+	var zw io.WriteCloser
+	switch codec {
+	case CodecGzip:
+		zw = gzip.NewWriter(w)
+	case CodecZstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		zw = enc
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidCodec, codec)
+	}
+
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// decompressReader returns a reader for the content read from rc, which is
+// compressed with codec. Closing it closes rc. Content that cannot be
+// decompressed fails with errDecode; errors reading rc are returned as they
+// are.
+func decompressReader(rc io.ReadCloser, codec string) (io.ReadCloser, error) {
+	d := &decompressor{src: &sourceReader{r: rc}, rc: rc}
+	var err error
+	switch codec {
+	case CodecGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(d.src); err == nil {
+			d.zr, d.close = zr, func() { zr.Close() }
+		}
+	case CodecZstd:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(d.src, zstd.WithDecoderConcurrency(1)); err == nil {
+			d.zr, d.close = zr, zr.Close
+		}
+	default:
+		err = fmt.Errorf("%w: %q", ErrInvalidCodec, codec)
+	}
+	if err != nil {
+		rc.Close()
+		return nil, d.check(err)
+	}
+	return d, nil
+}
+
+// errDecode is returned when compressed content is corrupt.
+var errDecode = errors.New("failed to decompress content")
+
+type decompressor struct {
+	src   *sourceReader
+	zr    io.Reader
+	close func()
+	rc    io.ReadCloser
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	n, err := d.zr.Read(p)
+	return n, d.check(err)
+}
+
+// check tells errors in the compressed content apart from errors reading
+// it.
+func (d *decompressor) check(err error) error {
+	switch {
+	case err == nil || err == io.EOF || errors.Is(err, ErrInvalidCodec):
+		return err
+	case d.src.err != nil:
+		return d.src.err
+	default:
+		return fmt.Errorf("%w: %v", errDecode, err)
+	}
+}
+
+func (d *decompressor) Close() error {
+	d.close()
+	return d.rc.Close()
+}
+
+// sourceReader remembers the last error reading compressed content.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// acceptsCodec reports whether codec is among the codecs a read accepts.
+func acceptsCodec(opts ReadOptions, codec string) bool {
+	return slices.Contains(opts.AcceptCodecs, codec)
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		name := "Plain"
+		s := newTestStoreWithConfig(t, Config{ScrubBytesPerSecond: -1})
+		if encrypted {
+			name = "Encrypted"
+			s = newEncryptedTestStore(t)
+		}
+		t.Run(name, func(t *testing.T) {
+			testCompression(t, s)
+		})
+	}
+}
+
+func testCompression(t *testing.T, s *Store) {
+	ctx := t.Context()
+	content := []byte(strings.Repeat("compressible content ", 10000))
+
+	bucket, _ := s.GetBucket(ctx, DefaultBucket)
+	bucket.Compression = CodecZstd
+	if err := s.UpdateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := s.CreateObject(ctx, "by-default.txt", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ := s.StatObject(ctx, id)
+	if metadata.Codec != CodecZstd || metadata.Size != int64(len(content)) {
+		t.Errorf("Expected zstd content of size %d, got %q of size %d", len(content), metadata.Codec, metadata.Size)
+	}
+	raw, err := os.ReadFile(s.localPath(metadata.BlobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) >= len(content)/10 {
+		t.Errorf("Expected content to be stored compressed, got %d bytes", len(raw))
+	}
+	if data, err := s.ReadObject(ctx, id); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected content to be decompressed on read: %v", err)
+	}
+
+	// A write can override the bucket's default.
+	gzipped := WriteOptions{Compression: CodecGzip}
+	if _, err := s.CreateObjectWith(ctx, "gzip.txt", bytes.NewReader(content[1:]), gzipped); err != nil {
+		t.Fatal(err)
+	}
+	rc, metadata, err := s.GetObjectWith(ctx, "gzip.txt", ReadOptions{AcceptCodecs: []string{CodecGzip}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, ok := rc.(*EncodedReader)
+	if !ok || encoded.Codec != CodecGzip {
+		t.Fatalf("Expected gzip content to be returned compressed, got %T", rc)
+	}
+	compressed, _ := io.ReadAll(encoded)
+	rc.Close()
+	if int64(len(compressed)) != encoded.Size || metadata.Codec != CodecGzip {
+		t.Errorf("Expected %d compressed bytes, got %d", encoded.Size, len(compressed))
+	}
+	plain, err := decompressReader(io.NopCloser(bytes.NewReader(compressed)), CodecGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(plain); !bytes.Equal(data, content[1:]) {
+		t.Error("Expected compressed content to decompress to the original")
+	}
+
+	identity := WriteOptions{Compression: CodecIdentity}
+	if _, err := s.CreateObjectWith(ctx, "identity.txt", bytes.NewReader(content[2:]), identity); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ := s.StatObject(ctx, "identity.txt"); metadata.Codec != "" {
+		t.Errorf("Expected identity to store content uncompressed, got %q", metadata.Codec)
+	}
+
+	// Content the client encoded itself, and content that does not shrink,
+	// is stored as sent.
+	encodedByClient := WriteOptions{Headers: Headers{ContentEncoding: "br"}}
+	if _, err := s.CreateObjectWith(ctx, "client.txt", bytes.NewReader(content[3:]), encodedByClient); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ := s.StatObject(ctx, "client.txt"); metadata.Codec != "" {
+		t.Errorf("Expected client-encoded content to be stored as sent, got %q", metadata.Codec)
+	}
+	if _, err := s.CreateObject(ctx, "random.bin", randomContent(t, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ := s.StatObject(ctx, "random.bin"); metadata.Codec != "" {
+		t.Errorf("Expected incompressible content to be stored uncompressed, got %q", metadata.Codec)
+	}
+
+	_, err = s.CreateObjectWith(ctx, "bad.txt", bytes.NewReader(content), WriteOptions{Compression: "lzma"})
+	if !errors.Is(err, ErrInvalidCodec) {
+		t.Errorf("Expected ErrInvalidCodec, got %v", err)
+	}
+
+	result, err := s.ScrubBlobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Corrupt) != 0 || len(result.Missing) != 0 {
+		t.Errorf("Expected compressed blobs to verify, got %+v", result)
+	}
+}
+
+func TestCorruptCompressedContent(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStoreWithConfig(t, Config{ScrubBytesPerSecond: -1})
+	content := []byte(strings.Repeat("compressible content ", 10000))
+	id, err := s.CreateObjectWith(ctx, "gzip.txt", bytes.NewReader(content), WriteOptions{Compression: CodecGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ := s.StatObject(ctx, id)
+
+	path := s.localPath(metadata.BlobID)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)/2] ^= 0xff
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.ScrubBlobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Corrupt) != 1 || result.Corrupt[0] != metadata.BlobID {
+		t.Errorf("Expected damaged compressed blob to be found corrupt, got %+v", result)
+	}
+}
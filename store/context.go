@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"io"
+)
+
+// newContextReader returns a reader that fails with ctx's error once ctx is
+// done, so that streaming stops when the request is canceled or runs past
+// its deadline. The reader seeks and closes if r does.
+func newContextReader(ctx context.Context, r io.Reader) io.Reader {
+	cr := &contextReader{ctx: ctx, r: r}
+	if _, ok := r.(io.Seeker); ok {
+		return &contextReadSeeker{cr}
+	}
+	return cr
+}
+
+// newContextReadCloser is newContextReader for readers the caller closes.
+func newContextReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	return newContextReader(ctx, rc).(io.ReadCloser)
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func (r *contextReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type contextReadSeeker struct {
+	*contextReader
+}
+
+func (r *contextReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.r.(io.Seeker).Seek(offset, whence)
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancelingReader cancels its context once it has been read from.
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), 4)])
+	r.cancel()
+	return n, err
+}
+
+func TestCanceledWrite(t *testing.T) {
+	s := newTestStore(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	body := &cancelingReader{r: strings.NewReader("a body cut short by its client"), cancel: cancel}
+	if _, err := s.CreateObjectWith(ctx, "a.txt", body, WriteOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	ctx = t.Context()
+	result, err := s.ListObjects(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 0 {
+		t.Errorf("Expected no objects, got %+v", result.Objects)
+	}
+	intents, err := s.MetadataStore.ListIntents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 0 {
+		t.Errorf("Expected no intents left behind, got %+v", intents)
+	}
+}
+
+func TestCanceledRead(t *testing.T) {
+	s := newTestStore(t)
+	data := bytes.Repeat([]byte("x"), 1<<16)
+	if _, err := s.CreateObject(t.Context(), "a.txt", data); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	rc, _, err := s.GetObject(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := io.ReadFull(rc, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.ReadAll(rc); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestExpiredDeadline(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.CreateObject(t.Context(), "a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithDeadline(t.Context(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := s.StatObject(ctx, "a.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,551 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CustomerKeyID is the KeyID of content whose data key is wrapped by a key
+// the client supplies with each request rather than by a master key.
+const CustomerKeyID = "customer"
+
+// KeySize is the size in bytes of master, data and customer keys, which are
+// all AES-256 keys.
+const KeySize = 32
+
+var (
+	// ErrInvalidKey is returned when a customer-supplied key is not an
+	// AES-256 key.
+	ErrInvalidKey = errors.New("invalid encryption key")
+	// ErrCustomerKeyRequired is returned when reading content encrypted
+	// under a customer-supplied key without supplying it.
+	ErrCustomerKeyRequired = errors.New("customer key required")
+	// ErrCustomerKeyMismatch is returned when the customer-supplied key is
+	// not the one the content was encrypted under.
+	ErrCustomerKeyMismatch = errors.New("customer key does not match")
+	// ErrKeyNotFound is returned when content is wrapped by a master key
+	// that is not in the keyring.
+	ErrKeyNotFound = errors.New("master key not found")
+)
+
+// errDecrypt is returned when content fails authentication while it is
+// decrypted.
+var errDecrypt = errors.New("content failed authentication")
+
+// EncryptionConfig configures encryption at rest.
+type EncryptionConfig struct {
+	// KeyFile is the path of the keyfile holding the master keys. Content
+	// is encrypted at rest when it is set. The keyfile is created with a
+	// new master key if it does not exist.
+	KeyFile string `json:"keyfile,omitempty"`
+}
+
+// segmentSize is the amount of plaintext sealed at a time. Content is
+// encrypted in segments so that it can be read from any offset.
+const segmentSize = 64 << 10
+
+// Keyring holds the master keys that wrap data keys, as stored in a keyfile.
+// It reloads the keyfile when it changes, so that a running server picks up
+// keys rotated from the command line.
+type Keyring struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	file    keyFile
+}
+
+// keyFile is the JSON layout of a keyfile.
+type keyFile struct {
+	// Active is the ID of the key that wraps new data keys.
+	Active string `json:"active"`
+	// Keys maps key IDs to base64-encoded keys.
+	Keys map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads the keyfile at path, creating it with a new master key
+// if it does not exist.
+func LoadKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.load()
+	if errors.Is(err, os.ErrNotExist) {
+		k.file = keyFile{Keys: make(map[string]string)}
+		_, err = k.addKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the master key that wraps new data keys.
+func (k *Keyring) ActiveKeyID() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.refresh(); err != nil {
+		return "", err
+	}
+	return k.file.Active, nil
+}
+
+// Rotate adds a new master key and makes it the active key. It returns the
+// new key's ID. Data keys wrapped by earlier keys stay readable until they
+// are rewrapped.
+func (k *Keyring) Rotate() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.refresh(); err != nil {
+		return "", err
+	}
+	return k.addKey()
+}
+
+// Retire removes a master key other than the active one from the keyring.
+func (k *Keyring) Retire(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.refresh(); err != nil {
+		return err
+	}
+	if keyID == k.file.Active {
+		return fmt.Errorf("cannot retire the active key %s", keyID)
+	}
+	delete(k.file.Keys, keyID)
+	return k.save()
+}
+
+// KeyIDs returns the IDs of the keys in the keyring.
+func (k *Keyring) KeyIDs() ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(k.file.Keys))
+	for id := range k.file.Keys {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// wrap wraps a data key with the active master key, binding it to blobID.
+func (k *Keyring) wrap(dataKey []byte, blobID string) (string, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.refresh(); err != nil {
+		return "", nil, err
+	}
+	key, err := k.key(k.file.Active)
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := wrapKey(key, dataKey, blobID)
+	return k.file.Active, wrapped, err
+}
+
+// unwrap unwraps a data key wrapped by the master key keyID.
+func (k *Keyring) unwrap(keyID string, wrapped []byte, blobID string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	key, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapKey(key, wrapped, blobID)
+}
+
+func (k *Keyring) key(keyID string) ([]byte, error) {
+	encoded, ok := k.file.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("invalid master key %s in %s", keyID, k.path)
+	}
+	return key, nil
+}
+
+// refresh reloads the keyfile if it changed since it was read.
+func (k *Keyring) refresh() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	if info.ModTime().Equal(k.modTime) {
+		return nil
+	}
+	return k.load()
+}
+
+func (k *Keyring) load() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid keyfile %s: %w", k.path, err)
+	}
+	if _, ok := file.Keys[file.Active]; !ok {
+		return fmt.Errorf("invalid keyfile %s: active key %q not found", k.path, file.Active)
+	}
+	k.file, k.modTime = file, info.ModTime()
+	return nil
+}
+
+func (k *Keyring) addKey() (string, error) {
+	id := newID()[:16]
+	k.file.Keys[id] = base64.StdEncoding.EncodeToString(newKey())
+	k.file.Active = id
+	if err := k.save(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// save replaces the keyfile atomically, so that a crash never leaves it
+// partly written.
+func (k *Keyring) save() error {
+	data, err := json.MarshalIndent(k.file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keyfile: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), k.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	k.modTime = info.ModTime()
+	return nil
+}
+
+func newKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate key: %v", err))
+	}
+	return key
+}
+
+// wrapKey seals dataKey with kek, authenticating blobID so that a wrapped key
+// cannot be moved to another blob. The result is the nonce followed by the
+// sealed key.
+func wrapKey(kek, dataKey []byte, blobID string) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(blobID)), nil
+}
+
+func unwrapKey(kek, wrapped []byte, blobID string) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errDecrypt
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(blobID))
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentCount returns the number of segments size bytes of plaintext are
+// sealed in. Empty content is sealed as one empty segment, so that
+// truncation to nothing is detected.
+func segmentCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + segmentSize - 1) / segmentSize
+}
+
+// encryptedSize returns the size of size bytes of plaintext once encrypted.
+func encryptedSize(size int64) int64 {
+	return size + segmentCount(size)*16
+}
+
+// segmentNonce returns the nonce of segment i. Data keys are never reused
+// across blobs, so the segment index is unique for the key. The final
+// segment is flagged, so that truncation at a segment boundary is detected.
+func segmentNonce(i int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(i))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader seals the size bytes of plaintext read from r segment by
+// segment.
+type encryptReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	count int64
+	next  int64
+	buf   []byte
+	out   []byte
+}
+
+func newEncryptReader(r io.Reader, dataKey []byte, size int64) (*encryptReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{r: r, aead: aead, count: segmentCount(size), buf: make([]byte, segmentSize)}, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	if len(e.out) == 0 {
+		if e.next == e.count {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.r, e.buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		final := e.next == e.count-1
+		e.out = e.aead.Seal(e.out[:0], segmentNonce(e.next, final), e.buf[:n], nil)
+		e.next++
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// decryptReader opens sealed content segment by segment. It can seek if the
+// underlying reader can.
+type decryptReader struct {
+	src    io.ReadCloser
+	aead   cipher.AEAD
+	size   int64
+	offset int64
+
+	// segment is the index of the segment held in plain, or -1.
+	segment int64
+	plain   []byte
+	sealed  []byte
+}
+
+func newDecryptReader(src io.ReadCloser, dataKey []byte, size int64) (*decryptReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		src:     src,
+		aead:    aead,
+		size:    size,
+		segment: -1,
+		sealed:  make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	i := d.offset / segmentSize
+	if i != d.segment {
+		if err := d.load(i); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain[d.offset-i*segmentSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+// load reads and opens segment i, seeking to it unless it is the segment
+// that follows the one last read.
+func (d *decryptReader) load(i int64) error {
+	if i != d.segment+1 {
+		seeker, ok := d.src.(io.Seeker)
+		if !ok {
+			return errors.New("encrypted content cannot seek")
+		}
+		if _, err := seeker.Seek(i*int64(len(d.sealed)), io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	count := segmentCount(d.size)
+	sealedLen := len(d.sealed)
+	if i == count-1 {
+		sealedLen = int(d.size-i*segmentSize) + d.aead.Overhead()
+	}
+	if _, err := io.ReadFull(d.src, d.sealed[:sealedLen]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errDecrypt
+		}
+		return err
+	}
+	plain, err := d.aead.Open(d.plain[:0], segmentNonce(i, i == count-1), d.sealed[:sealedLen], nil)
+	if err != nil {
+		return errDecrypt
+	}
+	d.plain, d.segment = plain, i
+	return nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid seek to negative offset %d", offset)
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.src.Close()
+}
+
+// RewrapKeys rewraps with the active master key every data key wrapped by an
+// older master key. Content is not rewritten. It returns the number of data
+// keys rewrapped.
+func (s *Store) RewrapKeys(ctx context.Context) (int, error) {
+	if s.keys == nil {
+		return 0, errors.New("encryption is not configured")
+	}
+	active, err := s.keys.ActiveKeyID()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		blobs, err := s.MetadataStore.ListBlobsToRewrap(ctx, active, scrubBatchSize)
+		if err != nil {
+			return n, err
+		}
+		if len(blobs) == 0 {
+			return n, nil
+		}
+
+		for _, blob := range blobs {
+			dataKey, err := s.keys.unwrap(blob.KeyID, blob.WrappedKey, blob.ID)
+			if err != nil {
+				return n, fmt.Errorf("failed to unwrap data key of blob %s: %w", blob.ID, err)
+			}
+			keyID, wrapped, err := s.keys.wrap(dataKey, blob.ID)
+			if err != nil {
+				return n, fmt.Errorf("failed to wrap data key of blob %s: %w", blob.ID, err)
+			}
+			if err := s.MetadataStore.RewrapBlob(ctx, blob.ID, keyID, wrapped); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+}
+
+// RetireUnusedKeys removes from the keyring every master key other than the
+// active one that no data key is wrapped by anymore. It returns the IDs of
+// the keys removed.
+func (s *Store) RetireUnusedKeys(ctx context.Context) ([]string, error) {
+	if s.keys == nil {
+		return nil, errors.New("encryption is not configured")
+	}
+	active, err := s.keys.ActiveKeyID()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := s.keys.KeyIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var retired []string
+	for _, id := range ids {
+		if id == active {
+			continue
+		}
+		inUse, err := s.MetadataStore.KeyInUse(ctx, id)
+		if err != nil {
+			return retired, err
+		}
+		if inUse {
+			continue
+		}
+		if err := s.keys.Retire(id); err != nil {
+			return retired, err
+		}
+		retired = append(retired, id)
+	}
+	return retired, nil
+}
@@ -0,0 +1,264 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newEncryptedTestStore(t *testing.T) *Store {
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	return newTestStoreWithConfig(t, Config{Encryption: EncryptionConfig{KeyFile: keyFile}, ScrubBytesPerSecond: -1})
+}
+
+func randomContent(t *testing.T, n int) []byte {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSegmentedEncryption(t *testing.T) {
+	key := newKey()
+	for _, size := range []int{0, 1, segmentSize, segmentSize + 1, 3*segmentSize - 7} {
+		plain := randomContent(t, size)
+		r, err := newEncryptReader(bytes.NewReader(plain), key, int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(sealed)) != encryptedSize(int64(size)) {
+			t.Errorf("Size %d: expected %d sealed bytes, got %d", size, encryptedSize(int64(size)), len(sealed))
+		}
+
+		d, err := newDecryptReader(nopSeekCloser{bytes.NewReader(sealed)}, key, int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := io.ReadAll(d)
+		if err != nil || !bytes.Equal(opened, plain) {
+			t.Errorf("Size %d: round trip failed: %v", size, err)
+		}
+
+		if size > 1 {
+			offset := int64(size / 2)
+			if _, err := d.Seek(offset, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			rest, err := io.ReadAll(d)
+			if err != nil || !bytes.Equal(rest, plain[offset:]) {
+				t.Errorf("Size %d: read after seek failed: %v", size, err)
+			}
+		}
+
+		// Dropping the final segment's tag is detected.
+		d, _ = newDecryptReader(nopSeekCloser{bytes.NewReader(sealed[:len(sealed)-1])}, key, int64(size))
+		if _, err := io.ReadAll(d); size > 0 && !errors.Is(err, errDecrypt) {
+			t.Errorf("Size %d: expected truncation to be detected, got %v", size, err)
+		}
+	}
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	ctx := t.Context()
+	s := newEncryptedTestStore(t)
+	content := randomContent(t, 3*segmentSize/2)
+
+	id, err := s.CreateObject(ctx, "secret.bin", content)
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	metadata, err := s.StatObject(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, _ := s.Keyring().ActiveKeyID()
+	if metadata.KeyID != active {
+		t.Errorf("Expected key ID %s, got %q", active, metadata.KeyID)
+	}
+	if hash, _ := s.ContentHash(ctx, metadata.BlobID); metadata.BlobID == hash {
+		t.Error("Expected encrypted blob not to be named after its hash")
+	}
+
+	raw, err := os.ReadFile(s.localPath(metadata.BlobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(raw)) != encryptedSize(int64(len(content))) || bytes.Contains(raw, content[:64]) {
+		t.Error("Expected content to be stored encrypted")
+	}
+
+	rc, _, err := s.GetObject(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeker, ok := rc.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("Expected encrypted content to be seekable")
+	}
+	seeker.Seek(segmentSize+10, io.SeekStart)
+	tail, err := io.ReadAll(seeker)
+	rc.Close()
+	if err != nil || !bytes.Equal(tail, content[segmentSize+10:]) {
+		t.Errorf("Ranged read of encrypted content failed: %v", err)
+	}
+
+	// The same content is shared between encrypted objects.
+	copyID, err := s.CreateObject(ctx, "copy.bin", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, _ := s.StatObject(ctx, copyID)
+	if copied.BlobID != metadata.BlobID {
+		t.Error("Expected identical encrypted content to share a blob")
+	}
+
+	// A corrupted ciphertext fails authentication.
+	raw[100] ^= 0xff
+	if err := os.WriteFile(s.localPath(metadata.BlobID), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.ScrubBlobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Corrupt) != 1 || result.Corrupt[0] != metadata.BlobID {
+		t.Errorf("Expected tampered blob to be found corrupt, got %+v", result)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := t.Context()
+	s := newEncryptedTestStore(t)
+	old, _ := s.Keyring().ActiveKeyID()
+	if _, err := s.CreateObject(ctx, "rotated.txt", []byte("rotate me")); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := s.Keyring().Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.RewrapKeys(ctx); err != nil || n != 1 {
+		t.Fatalf("Expected 1 data key rewrapped, got %d, %v", n, err)
+	}
+	metadata, _ := s.StatObject(ctx, "rotated.txt")
+	if metadata.KeyID != active {
+		t.Errorf("Expected key ID %s after rotation, got %s", active, metadata.KeyID)
+	}
+
+	retired, err := s.RetireUnusedKeys(ctx)
+	if err != nil || len(retired) != 1 || retired[0] != old {
+		t.Fatalf("Expected key %s to be retired, got %v, %v", old, retired, err)
+	}
+
+	// The keyfile is all that is needed to read the content again.
+	keys, err := LoadKeyring(s.FileStorage.config.Encryption.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.keys = keys
+	data, err := s.ReadObject(ctx, "rotated.txt")
+	if err != nil || string(data) != "rotate me" {
+		t.Errorf("Expected content readable after rotation, got %q, %v", data, err)
+	}
+}
+
+func TestCustomerKeys(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	key, other := newKey(), newKey()
+
+	if _, err := s.CreateObjectWith(ctx, "bad.txt", bytes.NewReader([]byte("x")), WriteOptions{CustomerKey: []byte("short")}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+
+	id, err := s.CreateObjectWith(ctx, "mine.txt", bytes.NewReader([]byte("mine")), WriteOptions{CustomerKey: key})
+	if err != nil {
+		t.Fatalf("CreateObjectWith failed: %v", err)
+	}
+	if _, _, err := s.GetObject(ctx, id); !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Errorf("Expected ErrCustomerKeyRequired, got %v", err)
+	}
+	if _, _, err := s.GetObjectWith(ctx, id, ReadOptions{CustomerKey: other}); !errors.Is(err, ErrCustomerKeyMismatch) {
+		t.Errorf("Expected ErrCustomerKeyMismatch, got %v", err)
+	}
+	rc, metadata, err := s.GetObjectWith(ctx, id, ReadOptions{CustomerKey: key})
+	if err != nil {
+		t.Fatalf("GetObjectWith failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "mine" || metadata.KeyID != CustomerKeyID {
+		t.Errorf("Unexpected content %q with key ID %q", data, metadata.KeyID)
+	}
+
+	// Content under a customer key is never shared.
+	theirs, err := s.CreateObjectWith(ctx, "theirs.txt", bytes.NewReader([]byte("mine")), WriteOptions{CustomerKey: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirMetadata, _ := s.StatObject(ctx, theirs)
+	if theirMetadata.BlobID == metadata.BlobID {
+		t.Error("Expected content under different customer keys not to share a blob")
+	}
+	plain, err := s.CreateObject(ctx, "plain.txt", []byte("mine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainMetadata, _ := s.StatObject(ctx, plain)
+	if plainMetadata.BlobID == metadata.BlobID || plainMetadata.KeyID != "" {
+		t.Error("Expected plaintext content not to share a customer-keyed blob")
+	}
+
+	result, err := s.ScrubBlobs(ctx)
+	if err != nil || len(result.Corrupt)+len(result.Missing) != 0 {
+		t.Errorf("Expected customer-keyed blobs to pass scrubbing, got %+v, %v", result, err)
+	}
+}
+
+func TestConcurrentEncryptedWrites(t *testing.T) {
+	ctx := t.Context()
+	s := newEncryptedTestStore(t)
+	backend := newStallingBackend(s.Backend)
+	s.Backend = backend
+
+	content := randomContent(t, 100000)
+	done := make(chan error)
+	go func() {
+		_, err := s.CreateObject(ctx, "slow.bin", content)
+		done <- err
+	}()
+	<-backend.stalled
+
+	// Another write is sealed and stored while the first one stalls.
+	if _, err := s.CreateObject(ctx, "fast.bin", content); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, objectPath := range []string{"slow.bin", "fast.bin"} {
+		data, err := s.ReadObject(ctx, objectPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("Content of %s does not match", objectPath)
+		}
+	}
+	if blobs := listBlobs(t, s); len(blobs) != 1 {
+		t.Errorf("Expected one blob, got %v", blobs)
+	}
+	checkNoIntents(t, s)
+}
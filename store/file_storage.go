@@ -1,23 +1,158 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
+// tempDirName is the subdirectory of the storage directory used to stage
+// incoming content before it is renamed into place.
+const tempDirName = ".tmp"
+
+// uploadsDirName is the subdirectory of the storage directory holding the
+// parts of multipart uploads in progress, one directory per upload.
+const uploadsDirName = ".uploads"
+
+// quarantineDirName is the subdirectory of the storage directory that holds
+// the content of blobs found corrupt, for inspection.
+const quarantineDirName = ".quarantine"
+
+// DefaultScrubInterval is how often each blob is verified, unless configured
+// otherwise.
+const DefaultScrubInterval = 7 * 24 * time.Hour
+
+// DefaultScrubRate is the rate in bytes per second at which the scrubber
+// reads content, unless configured otherwise.
+const DefaultScrubRate = 8 << 20
+
+// DefaultGCGrace is how old a blob without a record must be before the
+// garbage collector deletes it, unless configured otherwise.
+const DefaultGCGrace = 24 * time.Hour
+
+// DefaultUploadExpiry is how long a multipart upload may stay incomplete
+// before it is reaped, unless configured otherwise.
+const DefaultUploadExpiry = 24 * time.Hour
+
+// DefaultChangeRetention is how long changes are kept in the change feed,
+// unless configured otherwise.
+const DefaultChangeRetention = 7 * 24 * time.Hour
+
 // Config holds the configuration for the FileStorage.
 type Config struct {
 	StorageDirectory string `json:"storage_directory"`
+	// Backend selects where blobs are stored: BackendLocal (the default)
+	// keeps them in StorageDirectory, BackendMemory in memory and BackendS3
+	// in the bucket configured by S3. StorageDirectory is used to stage
+	// uploads whatever the backend.
+	Backend string   `json:"backend,omitempty"`
+	S3      S3Config `json:"s3,omitzero"`
+	// UploadExpiryHours is the age after which incomplete multipart uploads
+	// are reaped. Zero means DefaultUploadExpiry.
+	UploadExpiryHours int `json:"upload_expiry_hours,omitempty"`
+	// ScrubIntervalHours is how often each blob's content is verified. Zero
+	// means DefaultScrubInterval.
+	ScrubIntervalHours int `json:"scrub_interval_hours,omitempty"`
+	// ScrubBytesPerSecond limits the rate at which the scrubber reads
+	// content. Zero means DefaultScrubRate; a negative value disables the
+	// limit.
+	ScrubBytesPerSecond int64 `json:"scrub_bytes_per_second,omitempty"`
+	// GCGraceHours is how old a blob without a record must be before the
+	// garbage collector deletes it. Zero means DefaultGCGrace.
+	GCGraceHours int `json:"gc_grace_hours,omitempty"`
+	// ChangeRetentionHours is how long changes are kept in the change feed.
+	// Zero means DefaultChangeRetention.
+	ChangeRetentionHours int `json:"change_retention_hours,omitempty"`
+	// Encryption configures encryption at rest. Content is stored in
+	// plaintext unless a keyfile is set.
+	Encryption EncryptionConfig `json:"encryption,omitzero"`
+	// Auth configures request authentication. Requests are not
+	// authenticated unless it is enabled.
+	Auth AuthConfig `json:"auth,omitzero"`
+	// Presign configures presigned URLs, which are refused unless a secret
+	// is set.
+	Presign PresignConfig `json:"presign,omitzero"`
+	// Webhooks are the endpoints changes to objects are delivered to.
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+	// Log configures the server's log, and AccessLog the log of the
+	// requests it serves. Requests are logged to Log unless AccessLog has
+	// sinks of its own.
+	Log       LogConfig `json:"log,omitzero"`
+	AccessLog LogConfig `json:"access_log,omitzero"`
 }
 
-// FileStorage represents a simple object storage system.
+// UploadExpiry returns the age after which incomplete multipart uploads are
+// reaped.
+func (c Config) UploadExpiry() time.Duration {
+	if c.UploadExpiryHours <= 0 {
+		return DefaultUploadExpiry
+	}
+	return time.Duration(c.UploadExpiryHours) * time.Hour
+}
+
+// ScrubInterval returns how often each blob's content is verified.
+func (c Config) ScrubInterval() time.Duration {
+	if c.ScrubIntervalHours <= 0 {
+		return DefaultScrubInterval
+	}
+	return time.Duration(c.ScrubIntervalHours) * time.Hour
+}
+
+// ScrubRate returns the rate in bytes per second at which the scrubber reads
+// content, or zero for no limit.
+func (c Config) ScrubRate() int64 {
+	switch {
+	case c.ScrubBytesPerSecond == 0:
+		return DefaultScrubRate
+	case c.ScrubBytesPerSecond < 0:
+		return 0
+	}
+	return c.ScrubBytesPerSecond
+}
+
+// GCGrace returns how old a blob without a record must be before the garbage
+// collector deletes it.
+func (c Config) GCGrace() time.Duration {
+	if c.GCGraceHours <= 0 {
+		return DefaultGCGrace
+	}
+	return time.Duration(c.GCGraceHours) * time.Hour
+}
+
+// ChangeRetention returns how long changes are kept in the change feed.
+func (c Config) ChangeRetention() time.Duration {
+	if c.ChangeRetentionHours <= 0 {
+		return DefaultChangeRetention
+	}
+	return time.Duration(c.ChangeRetentionHours) * time.Hour
+}
+
+// FileStorage represents a simple object storage system. It is the local
+// Backend and stages uploads for every other backend.
 type FileStorage struct {
 	config Config
 }
 
+// TempFile is content staged in the storage directory that has not yet been
+// committed under its final name.
+type TempFile struct {
+	path string
+	// Hash is the hex-encoded SHA-256 of the staged content.
+	Hash string
+	// Size is the number of bytes staged.
+	Size int64
+}
+
 // NewFileStorage creates a new FileStorage instance using the provided configuration file.
 func NewFileStorage(configFile string) (*FileStorage, error) {
 	config := &Config{}
@@ -33,8 +168,10 @@ func NewFileStorage(configFile string) (*FileStorage, error) {
 		return nil, fmt.Errorf("failed to decode config: %w", err)
 	}
 
-	if err := os.MkdirAll(config.StorageDirectory, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	for _, dir := range []string{tempDirName, uploadsDirName, quarantineDirName} {
+		if err := os.MkdirAll(filepath.Join(config.StorageDirectory, dir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
 	}
 
 	return &FileStorage{
@@ -43,17 +180,24 @@ func NewFileStorage(configFile string) (*FileStorage, error) {
 }
 
 // Create stores a new object with the given name and data.
-func (s *FileStorage) Create(name string, data []byte) error {
+func (s *FileStorage) Create(ctx context.Context, name string, data []byte) error {
+	_, err := s.CreateFrom(ctx, name, bytes.NewReader(data))
+	return err
+}
+
+// CreateFrom stores a new object with the given name, streaming its content
+// from r. It returns the number of bytes written.
+func (s *FileStorage) CreateFrom(ctx context.Context, name string, r io.Reader) (int64, error) {
 	filePath := filepath.Join(s.config.StorageDirectory, name)
 	_, err := os.Stat(filePath)
 	if err == nil {
-		return fmt.Errorf("object with name %s already exists", name)
+		return 0, fmt.Errorf("object with name %s already exists", name)
 	}
 	if !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("failed to check file existence: %w", err)
+		return 0, fmt.Errorf("failed to check file existence: %w", err)
 	}
 
-	return os.WriteFile(filePath, data, 0644)
+	return s.writeAndCommit(ctx, name, r)
 }
 
 // Read retrieves the object with the given name.
@@ -66,32 +210,175 @@ func (s *FileStorage) Read(name string) ([]byte, error) {
 	return data, nil
 }
 
+// Open returns a reader for the object with the given name. The caller must
+// close it.
+func (s *FileStorage) Open(name string) (io.ReadCloser, error) {
+	filePath := filepath.Join(s.config.StorageDirectory, name)
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object %s: %w", name, notFound(err))
+	}
+	return f, nil
+}
+
+// Put stores the content read from r under name, replacing any existing
+// object with that name.
+func (s *FileStorage) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := s.writeAndCommit(ctx, name, r)
+	return err
+}
+
+// Get is Open; the returned reader is an *os.File and can seek.
+func (s *FileStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.Open(name)
+}
+
+// Stat describes the object with the given name.
+func (s *FileStorage) Stat(ctx context.Context, name string) (*BlobInfo, error) {
+	info, err := os.Stat(filepath.Join(s.config.StorageDirectory, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object %s: %w", name, notFound(err))
+	}
+	return &BlobInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
 // Update modifies the content of an existing object.
-func (s *FileStorage) Update(name string, data []byte) error {
+func (s *FileStorage) Update(ctx context.Context, name string, data []byte) error {
+	_, err := s.UpdateFrom(ctx, name, bytes.NewReader(data))
+	return err
+}
+
+// UpdateFrom replaces the content of an existing object, streaming the new
+// content from r. It returns the number of bytes written.
+func (s *FileStorage) UpdateFrom(ctx context.Context, name string, r io.Reader) (int64, error) {
 	filePath := filepath.Join(s.config.StorageDirectory, name)
 	_, err := os.Stat(filePath)
 	if errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("object %s does not exist", name)
+		return 0, fmt.Errorf("object %s does not exist", name)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to check file existence: %w", err)
+		return 0, fmt.Errorf("failed to check file existence: %w", err)
 	}
 
-	return os.WriteFile(filePath, data, 0644)
+	return s.writeAndCommit(ctx, name, r)
+}
+
+func (s *FileStorage) writeAndCommit(ctx context.Context, name string, r io.Reader) (int64, error) {
+	tmp, err := s.WriteTemp(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.Commit(tmp, name); err != nil {
+		s.Discard(tmp)
+		return 0, err
+	}
+	return tmp.Size, nil
+}
+
+// WriteTemp streams r into a temporary file in the storage directory,
+// computing the SHA-256 of the content as it is written.
+func (s *FileStorage) WriteTemp(ctx context.Context, r io.Reader) (*TempFile, error) {
+	f, err := os.CreateTemp(filepath.Join(s.config.StorageDirectory, tempDirName), "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), newContextReader(ctx, r))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	return &TempFile{path: f.Name(), Hash: hashString(h), Size: n}, nil
+}
+
+// Commit moves a staged file into place under name, replacing any existing
+// object with that name. The content was synced to disk when it was staged;
+// the rename is synced before Commit returns.
+func (s *FileStorage) Commit(tmp *TempFile, name string) error {
+	filePath := filepath.Join(s.config.StorageDirectory, name)
+	if err := os.Rename(tmp.path, filePath); err != nil {
+		return fmt.Errorf("failed to commit object %s: %w", name, err)
+	}
+	if err := syncDir(s.config.StorageDirectory); err != nil {
+		return fmt.Errorf("failed to commit object %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *FileStorage) openTemp(tmp *TempFile) (*os.File, error) {
+	f, err := os.Open(tmp.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open temp file: %w", err)
+	}
+	return f, nil
+}
+
+// Discard removes a staged file that will not be committed.
+func (s *FileStorage) Discard(tmp *TempFile) error {
+	if err := os.Remove(tmp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to discard temp file: %w", err)
+	}
+	return nil
+}
+
+// CommitPart moves a staged file into place as a part of a multipart upload,
+// replacing any part with the same number.
+func (s *FileStorage) CommitPart(tmp *TempFile, uploadID string, partNumber int) error {
+	dir := s.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	if err := os.Rename(tmp.path, filepath.Join(dir, strconv.Itoa(partNumber))); err != nil {
+		return fmt.Errorf("failed to commit part %d of upload %s: %w", partNumber, uploadID, err)
+	}
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to commit part %d of upload %s: %w", partNumber, uploadID, err)
+	}
+	return nil
+}
+
+// OpenPart returns a reader for a part of a multipart upload. The caller must
+// close it.
+func (s *FileStorage) OpenPart(uploadID string, partNumber int) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.uploadDir(uploadID), strconv.Itoa(partNumber)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open part %d of upload %s: %w", partNumber, uploadID, err)
+	}
+	return f, nil
+}
+
+// DeleteUpload removes every part of a multipart upload.
+func (s *FileStorage) DeleteUpload(uploadID string) error {
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to delete upload %s: %w", uploadID, err)
+	}
+	return nil
+}
+
+func (s *FileStorage) uploadDir(uploadID string) string {
+	return filepath.Join(s.config.StorageDirectory, uploadsDirName, uploadID)
 }
 
 // Delete removes the object with the given name.
-func (s *FileStorage) Delete(name string) error {
+func (s *FileStorage) Delete(ctx context.Context, name string) error {
 	filePath := filepath.Join(s.config.StorageDirectory, name)
 	err := os.Remove(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to delete object %s: %w", name, err)
+		return fmt.Errorf("failed to delete object %s: %w", name, notFound(err))
 	}
 	return nil
 }
 
 // List returns a slice of all object names in the store.
-func (s *FileStorage) List() ([]string, error) {
+func (s *FileStorage) List(ctx context.Context) ([]string, error) {
 	entries, err := os.ReadDir(s.config.StorageDirectory)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read storage directory: %w", err)
@@ -105,3 +392,59 @@ func (s *FileStorage) List() ([]string, error) {
 	}
 	return names, nil
 }
+
+// ClearTemp removes content left staged by uploads that were interrupted.
+// It must only be called while no upload is in progress.
+func (s *FileStorage) ClearTemp() error {
+	dir := filepath.Join(s.config.StorageDirectory, tempDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read temp directory: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clear temp directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// Quarantine keeps the content read from r under name in the quarantine
+// directory, where it is never served.
+func (s *FileStorage) Quarantine(ctx context.Context, name string, r io.Reader) error {
+	f, err := os.Create(filepath.Join(s.config.StorageDirectory, quarantineDirName, name))
+	if err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", name, err)
+	}
+	_, err = io.Copy(f, newContextReader(ctx, r))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", name, err)
+	}
+	return nil
+}
+
+// syncDir flushes the entries of a directory to disk, making renames into it
+// durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// notFound translates a missing file into ErrBlobNotFound.
+func notFound(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+func hashString(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -2,7 +2,10 @@ package store
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -49,6 +52,8 @@ func TestObjectStore(t *testing.T) {
 		{"TestCreateDuplicateName", testCreateDuplicateName},
 		{"TestUpdateNonExistent", testUpdateNonExistent},
 		{"TestDeleteNonExistent", testDeleteNonExistent},
+		{"TestCreateFromStream", testCreateFromStream},
+		{"TestWriteTempDiscard", testWriteTempDiscard},
 	}
 
 	for _, tc := range testCases {
@@ -60,8 +65,9 @@ func TestObjectStore(t *testing.T) {
 }
 
 func testCreate(t *testing.T, os *FileStorage) {
+    ctx := t.Context()
     data := []byte("test data")
-    err := os.Create("testfile", data)
+    err := os.Create(ctx, "testfile", data)
     if err != nil {
         t.Errorf("Create failed: %v", err)
     }
@@ -83,15 +89,16 @@ func testReadNonExistent(t *testing.T, os *FileStorage) {
 }
 
 func testUpdate(t *testing.T, os *FileStorage) {
+    ctx := t.Context()
     initialData := []byte("initial data")
     updatedData := []byte("updated data")
     
-    err := os.Create("updatefile", initialData)
+    err := os.Create(ctx, "updatefile", initialData)
     if err != nil {
         t.Fatalf("Create failed: %v", err)
     }
 
-    err = os.Update("updatefile", updatedData)
+    err = os.Update(ctx, "updatefile", updatedData)
     if err != nil {
         t.Errorf("Update failed: %v", err)
     }
@@ -106,14 +113,15 @@ func testUpdate(t *testing.T, os *FileStorage) {
 }
 
 func testDelete(t *testing.T, os *FileStorage) {
+    ctx := t.Context()
     data := []byte("data to delete")
     
-    err := os.Create("deletefile", data)
+    err := os.Create(ctx, "deletefile", data)
     if err != nil {
         t.Fatalf("Create failed: %v", err)
     }
 
-    err = os.Delete("deletefile")
+    err = os.Delete(ctx, "deletefile")
     if err != nil {
         t.Errorf("Delete failed: %v", err)
     }
@@ -125,17 +133,18 @@ func testDelete(t *testing.T, os *FileStorage) {
 }
 
 func testList(t *testing.T, os *FileStorage) {
+    ctx := t.Context()
     files := []string{"file1", "file2", "file3"}
     data := []byte("data")
 
     for _, file := range files {
-        err := os.Create(file, data)
+        err := os.Create(ctx, file, data)
         if err != nil {
             t.Fatalf("Create failed: %v", err)
         }
     }
 
-    listed, err := os.List()
+    listed, err := os.List(ctx)
     if err != nil {
         t.Errorf("List failed: %v", err)
     }
@@ -159,29 +168,87 @@ func testList(t *testing.T, os *FileStorage) {
 }
 
 func testCreateDuplicateName(t *testing.T, os *FileStorage) {
+    ctx := t.Context()
     data := []byte("data")
-    err := os.Create("duplicate", data)
+    err := os.Create(ctx, "duplicate", data)
     if err != nil {
         t.Fatalf("First Create failed: %v", err)
     }
 
-    err = os.Create("duplicate", data)
+    err = os.Create(ctx, "duplicate", data)
     if err == nil {
         t.Error("Expected error when creating file with duplicate name")
     }
 }
 
 func testUpdateNonExistent(t *testing.T, os *FileStorage) {
+    ctx := t.Context()
     data := []byte("data")
-    err := os.Update("nonexistent", data)
+    err := os.Update(ctx, "nonexistent", data)
     if err == nil {
         t.Error("Expected error when updating non-existent file")
     }
 }
 
 func testDeleteNonExistent(t *testing.T, os *FileStorage) {
-    err := os.Delete("nonexistent")
+    ctx := t.Context()
+    err := os.Delete(ctx, "nonexistent")
     if err == nil {
         t.Error("Expected error when deleting non-existent file")
     }
 }
+
+func testCreateFromStream(t *testing.T, os *FileStorage) {
+	ctx := t.Context()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	n, err := os.CreateFrom(ctx, "streamfile", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("CreateFrom failed: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("CreateFrom wrote %d bytes, expected %d", n, len(data))
+	}
+
+	rc, err := os.Open("streamfile")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer rc.Close()
+
+	readData, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("Reading opened object failed: %v", err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Error("Streamed data doesn't match")
+	}
+}
+
+func testWriteTempDiscard(t *testing.T, os *FileStorage) {
+	ctx := t.Context()
+	data := []byte("staged data")
+	tmp, err := os.WriteTemp(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteTemp failed: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	if tmp.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("WriteTemp hash mismatch: got %s", tmp.Hash)
+	}
+	if tmp.Size != int64(len(data)) {
+		t.Errorf("WriteTemp size mismatch: expected %d, got %d", len(data), tmp.Size)
+	}
+
+	if err := os.Discard(tmp); err != nil {
+		t.Errorf("Discard failed: %v", err)
+	}
+
+	listed, err := os.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Staged files should not be listed, got %v", listed)
+	}
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GCOptions selects what CollectGarbage repairs. With neither option set it
+// only reports drift.
+type GCOptions struct {
+	// DeleteOrphans deletes the orphans older than the grace period.
+	DeleteOrphans bool
+	// MarkMissing marks dangling blobs BlobMissing, so that reads of their
+	// objects fail with ErrBlobDamaged and new writes do not reuse them.
+	MarkMissing bool
+	// Grace overrides the configured grace period if positive. Orphans
+	// younger than it may belong to writes in progress and are left alone.
+	Grace time.Duration
+}
+
+// GCReport describes the drift CollectGarbage found between the backend and
+// the blob records.
+type GCReport struct {
+	// Orphans are the backend blobs that no record refers to.
+	Orphans []string
+	// Dangling are the blob records whose content is not in the backend.
+	// Blobs already known to be damaged are not included.
+	Dangling []string
+	// Deleted and Marked are the orphans deleted and the dangling blobs
+	// marked missing.
+	Deleted []string
+	Marked  []string
+}
+
+// CollectGarbage compares the blobs in the backend against the blob records
+// and reports both kinds of drift, repairing it as opts selects.
+func (s *Store) CollectGarbage(ctx context.Context, opts GCOptions) (*GCReport, error) {
+	grace := opts.Grace
+	if grace <= 0 {
+		grace = s.FileStorage.config.GCGrace()
+	}
+
+	names, err := s.Backend.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := s.MetadataStore.ListBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]bool, len(names))
+	for _, name := range names {
+		stored[name] = true
+	}
+	recorded := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		recorded[blob.ID] = true
+	}
+
+	report := &GCReport{}
+	for _, name := range names {
+		if recorded[name] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, name)
+		if opts.DeleteOrphans {
+			deleted, err := s.deleteOrphan(ctx, name, time.Now().Add(-grace))
+			if err != nil {
+				return report, err
+			}
+			if deleted {
+				report.Deleted = append(report.Deleted, name)
+			}
+		}
+	}
+
+	for _, blob := range blobs {
+		if stored[blob.ID] || blob.Status != BlobOK {
+			continue
+		}
+		report.Dangling = append(report.Dangling, blob.ID)
+		if opts.MarkMissing {
+			marked, err := s.markMissing(ctx, blob.ID)
+			if err != nil {
+				return report, err
+			}
+			if marked {
+				report.Marked = append(report.Marked, blob.ID)
+			}
+		}
+	}
+	return report, nil
+}
+
+// deleteOrphan deletes a blob that has no record and was last modified
+// before cutoff. It holds blobMu and checks again, because the blob may have
+// been recorded since it was listed, or a write may be putting it.
+func (s *Store) deleteOrphan(ctx context.Context, name string, cutoff time.Time) (bool, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if inUse, err := s.blobInUse(ctx, name, 0); inUse || err != nil {
+		return false, err
+	}
+	info, err := s.Backend.Stat(ctx, name)
+	if errors.Is(err, ErrBlobNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.ModTime.Before(cutoff) {
+		return false, nil
+	}
+
+	if err := s.Backend.Delete(ctx, name); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return false, fmt.Errorf("failed to delete orphan %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// markMissing marks a blob whose content is not in the backend missing,
+// checking again under blobMu.
+func (s *Store) markMissing(ctx context.Context, blobID string) (bool, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	blob, err := s.MetadataStore.GetBlob(ctx, blobID)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if blob.Status != BlobOK {
+		return false, nil
+	}
+	if _, err := s.Backend.Stat(ctx, blobID); !errors.Is(err, ErrBlobNotFound) {
+		return false, err
+	}
+
+	if err := s.MetadataStore.SetBlobVerified(ctx, blobID, BlobMissing, time.Now()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
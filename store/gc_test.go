@@ -0,0 +1,79 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	if _, err := s.CreateObject(ctx, "kept.txt", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "dangling.txt", []byte("dangling")); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := s.StatObject(ctx, "dangling.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dangling := metadata.BlobID
+	if err := os.Remove(s.localPath(dangling)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"old-orphan", "new-orphan"} {
+		if err := s.Backend.Put(ctx, name, strings.NewReader(name), int64(len(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(s.localPath("old-orphan"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.CollectGarbage(ctx, GCOptions{})
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if !slices.Equal(report.Orphans, []string{"new-orphan", "old-orphan"}) || !slices.Equal(report.Dangling, []string{dangling}) {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if len(report.Deleted) != 0 || len(report.Marked) != 0 {
+		t.Errorf("Expected a report-only pass to change nothing, got %+v", report)
+	}
+
+	report, err = s.CollectGarbage(ctx, GCOptions{DeleteOrphans: true, MarkMissing: true})
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if !slices.Equal(report.Deleted, []string{"old-orphan"}) || !slices.Equal(report.Marked, []string{dangling}) {
+		t.Errorf("Unexpected repairs: %+v", report)
+	}
+	if _, err := s.Backend.Stat(ctx, "old-orphan"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected old orphan to be deleted, got %v", err)
+	}
+	if _, err := s.Backend.Stat(ctx, "new-orphan"); err != nil {
+		t.Errorf("Expected orphan within the grace period to be kept, got %v", err)
+	}
+	if _, err := s.ReadObject(ctx, "dangling.txt"); !errors.Is(err, ErrBlobDamaged) {
+		t.Errorf("Expected ErrBlobDamaged reading dangling object, got %v", err)
+	}
+	if data, err := s.ReadObject(ctx, "kept.txt"); err != nil || string(data) != "kept" {
+		t.Errorf("Expected kept object to be intact, got %q, %v", data, err)
+	}
+
+	report, err = s.CollectGarbage(ctx, GCOptions{DeleteOrphans: true, MarkMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Dangling) != 0 {
+		t.Errorf("Expected blobs marked missing not to be reported again, got %v", report.Dangling)
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LifecycleRule expires the objects, versions and multipart uploads of a
+// bucket under a path prefix once they reach an age. Each age is in days,
+// and zero leaves the corresponding kind alone.
+type LifecycleRule struct {
+	// ID names the rule in lifecycle reports.
+	ID string `json:"id,omitempty"`
+	// Prefix limits the rule to object paths starting with it. Empty
+	// matches every object in the bucket.
+	Prefix string `json:"prefix,omitempty"`
+	// ExpireDays deletes objects, with all their versions, that were last
+	// updated this many days ago.
+	ExpireDays int `json:"expire_days,omitempty"`
+	// NoncurrentDays deletes versions that a newer version replaced this
+	// many days ago.
+	NoncurrentDays int `json:"noncurrent_days,omitempty"`
+	// AbortUploadDays aborts multipart uploads initiated this many days ago.
+	AbortUploadDays int `json:"abort_upload_days,omitempty"`
+}
+
+// Kinds of lifecycle actions.
+const (
+	LifecycleExpire      = "expire"
+	LifecycleNoncurrent  = "noncurrent"
+	LifecycleAbortUpload = "abort_upload"
+)
+
+// LifecycleAction is an object, version or upload a lifecycle rule removed,
+// or would remove in a dry run.
+type LifecycleAction struct {
+	// Kind is LifecycleExpire, LifecycleNoncurrent or LifecycleAbortUpload.
+	Kind       string
+	Bucket     string
+	ObjectPath string
+	// VersionID is the version deleted: for expired objects, the version
+	// that was current. It is empty for uploads.
+	VersionID string
+	// UploadID is the upload aborted, for LifecycleAbortUpload.
+	UploadID string
+	// Rule is the ID of the rule that applied.
+	Rule string
+}
+
+// LifecycleReport lists what a lifecycle pass did or would do.
+type LifecycleReport struct {
+	DryRun  bool
+	Actions []LifecycleAction
+}
+
+// LifecycleOptions selects how ApplyLifecycle runs.
+type LifecycleOptions struct {
+	// DryRun reports what the rules would remove without removing it.
+	DryRun bool
+	// Now is the time ages are measured at. Zero means the current time.
+	Now time.Time
+}
+
+// maxDays is the longest lifecycle age or default retention period
+// accepted, a hundred years. Periods of more than about 290 years overflow a
+// time.Duration.
+const maxDays = 36500
+
+// days converts a lifecycle age to a duration. Ages are capped at maxDays
+// so that rules stored before they were validated cannot overflow.
+func days(n int) time.Duration {
+	return time.Duration(min(n, maxDays)) * 24 * time.Hour
+}
+
+// ApplyLifecycle enforces the lifecycle rules of every bucket and reports
+// what it removed. Objects updated since they were found to be expired are
+// left alone, and so are locked objects and their versions.
+func (s *Store) ApplyLifecycle(ctx context.Context, opts LifecycleOptions) (*LifecycleReport, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	buckets, err := s.MetadataStore.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LifecycleReport{DryRun: opts.DryRun}
+	seen := make(map[LifecycleAction]bool)
+	record := func(action LifecycleAction) {
+		key := action
+		key.Rule = ""
+		if !seen[key] {
+			seen[key] = true
+			report.Actions = append(report.Actions, action)
+		}
+	}
+	for _, bucket := range buckets {
+		for _, rule := range bucket.Lifecycle {
+			if err := s.applyLifecycleRule(ctx, bucket.Name, rule, now, opts.DryRun, record); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+func (s *Store) applyLifecycleRule(ctx context.Context, bucket string, rule LifecycleRule, now time.Time, dryRun bool, record func(LifecycleAction)) error {
+	scoped := s.InBucket(bucket)
+
+	if rule.ExpireDays > 0 {
+		expired, err := s.MetadataStore.ListObjectsUpdatedBefore(ctx, bucket, rule.Prefix, now.Add(-days(rule.ExpireDays)))
+		if err != nil {
+			return err
+		}
+		for _, metadata := range expired {
+			if checkLock(metadata, now, false) != nil {
+				continue
+			}
+			if !dryRun {
+				err := scoped.DeleteObjectWith(ctx, metadata.ObjectID, DeleteOptions{IfVersionID: metadata.VersionID})
+				if errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrObjectLocked) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to expire %s: %w", metadata.ObjectPath, err)
+				}
+			}
+			record(LifecycleAction{Kind: LifecycleExpire, Bucket: bucket, ObjectPath: metadata.ObjectPath, VersionID: metadata.VersionID, Rule: rule.ID})
+		}
+	}
+
+	if rule.NoncurrentDays > 0 {
+		versions, err := s.MetadataStore.ListNoncurrentVersionsBefore(ctx, bucket, rule.Prefix, now.Add(-days(rule.NoncurrentDays)))
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			locked, err := s.versionLocked(ctx, version, now)
+			if err != nil {
+				return err
+			}
+			if locked {
+				continue
+			}
+			if !dryRun {
+				if err := s.discardVersion(ctx, version); err != nil {
+					return fmt.Errorf("failed to delete version %s of %s: %w", version.VersionID, version.ObjectPath, err)
+				}
+			}
+			record(LifecycleAction{Kind: LifecycleNoncurrent, Bucket: bucket, ObjectPath: version.ObjectPath, VersionID: version.VersionID, Rule: rule.ID})
+		}
+	}
+
+	if rule.AbortUploadDays > 0 {
+		uploads, err := s.MetadataStore.ListUploadsBefore(ctx, now.Add(-days(rule.AbortUploadDays)))
+		if err != nil {
+			return err
+		}
+		for _, upload := range uploads {
+			if upload.Bucket != bucket || !strings.HasPrefix(upload.ObjectPath, rule.Prefix) {
+				continue
+			}
+			if !dryRun {
+				if err := s.deleteUpload(ctx, upload.UploadID); err != nil {
+					return fmt.Errorf("failed to abort upload %s: %w", upload.UploadID, err)
+				}
+			}
+			record(LifecycleAction{Kind: LifecycleAbortUpload, Bucket: bucket, ObjectPath: upload.ObjectPath, UploadID: upload.UploadID, Rule: rule.ID})
+		}
+	}
+	return nil
+}
+
+// versionLocked reports whether the object a version belongs to is locked,
+// which keeps all its versions.
+func (s *Store) versionLocked(ctx context.Context, version *Version, now time.Time) (bool, error) {
+	metadata, err := s.MetadataStore.GetByObjectPath(ctx, version.Bucket, version.ObjectPath)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return checkLock(metadata, now, false) != nil, nil
+}
+
+func validateLifecycle(rules []LifecycleRule) error {
+	for i, rule := range rules {
+		if rule.ExpireDays < 0 || rule.NoncurrentDays < 0 || rule.AbortUploadDays < 0 {
+			return fmt.Errorf("%w: lifecycle rule %d has a negative age", ErrInvalidBucketSettings, i)
+		}
+		if rule.ExpireDays > maxDays || rule.NoncurrentDays > maxDays || rule.AbortUploadDays > maxDays {
+			return fmt.Errorf("%w: lifecycle rule %d has an age over %d days", ErrInvalidBucketSettings, i, maxDays)
+		}
+		if rule.ExpireDays == 0 && rule.NoncurrentDays == 0 && rule.AbortUploadDays == 0 {
+			return fmt.Errorf("%w: lifecycle rule %d has no action", ErrInvalidBucketSettings, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	bucket, _ := s.GetBucket(ctx, DefaultBucket)
+	bucket.Lifecycle = []LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpireDays: 1, AbortUploadDays: 2},
+		{ID: "history", NoncurrentDays: 7},
+	}
+	if err := s.UpdateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetBucket(ctx, DefaultBucket); len(got.Lifecycle) != 2 || got.Lifecycle[0].Prefix != "tmp/" {
+		t.Fatalf("Expected the lifecycle rules to be stored, got %+v", got.Lifecycle)
+	}
+
+	if _, err := s.CreateObject(ctx, "tmp/cache.bin", []byte("cache")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "keep/config.yaml", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"v2", "v3"} {
+		if err := s.UpdateObject(ctx, "keep/config.yaml", []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpUpload, err := s.InitiateUpload(ctx, "tmp/big.bin", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InitiateUpload(ctx, "keep/big.bin", WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(report *LifecycleReport, kind string) int {
+		n := 0
+		for _, action := range report.Actions {
+			if action.Kind == kind {
+				n++
+			}
+		}
+		return n
+	}
+
+	now := time.Now()
+	report, err := s.ApplyLifecycle(ctx, LifecycleOptions{DryRun: true, Now: now.Add(3 * 24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || count(report, LifecycleExpire) != 1 || count(report, LifecycleAbortUpload) != 1 || count(report, LifecycleNoncurrent) != 0 {
+		t.Errorf("Unexpected dry run report %+v", report.Actions)
+	}
+	if _, err := s.StatObject(ctx, "tmp/cache.bin"); err != nil {
+		t.Errorf("Expected a dry run to leave objects alone, got %v", err)
+	}
+
+	report, err = s.ApplyLifecycle(ctx, LifecycleOptions{Now: now.Add(8 * 24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count(report, LifecycleExpire) != 1 || count(report, LifecycleAbortUpload) != 1 || count(report, LifecycleNoncurrent) != 2 {
+		t.Errorf("Unexpected lifecycle report %+v", report.Actions)
+	}
+	for _, action := range report.Actions {
+		if action.Kind == LifecycleExpire && (action.ObjectPath != "tmp/cache.bin" || action.Rule != "tmp") {
+			t.Errorf("Unexpected expiry %+v", action)
+		}
+	}
+
+	if _, err := s.StatObject(ctx, "tmp/cache.bin"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected the expired object to be deleted, got %v", err)
+	}
+	if _, err := s.GetUpload(ctx, tmpUpload.UploadID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected the stale upload to be aborted, got %v", err)
+	}
+	versions, _ := s.ListVersions(ctx, "keep/config.yaml")
+	if len(versions) != 1 {
+		t.Fatalf("Expected only the current version to remain, got %d", len(versions))
+	}
+	if data, _ := s.ReadObject(ctx, "keep/config.yaml"); string(data) != "v3" {
+		t.Errorf("Expected the current content to remain, got %q", data)
+	}
+
+	report, err = s.ApplyLifecycle(ctx, LifecycleOptions{Now: now.Add(8 * 24 * time.Hour)})
+	if err != nil || len(report.Actions) != 0 {
+		t.Errorf("Expected a second pass to find nothing, got %+v: %v", report.Actions, err)
+	}
+}
+
+func TestLifecycleValidation(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	for _, rule := range []LifecycleRule{{Prefix: "tmp/"}, {ExpireDays: -1}, {ExpireDays: 200000}} {
+		err := s.CreateBucket(ctx, &Bucket{Name: "invalid-lifecycle", Lifecycle: []LifecycleRule{rule}})
+		if !errors.Is(err, ErrInvalidBucketSettings) {
+			t.Errorf("Expected ErrInvalidBucketSettings for %+v, got %v", rule, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Defaults for the rotation of file log sinks.
+const (
+	DefaultLogMaxSizeMB  = 100
+	DefaultLogMaxBackups = 5
+)
+
+// LogConfig configures a log. Records are written as JSON lines.
+type LogConfig struct {
+	// Sinks are where records are written: "stdout", "stderr" or the path
+	// of a file. Empty means stderr.
+	Sinks []string `json:"sinks,omitempty"`
+	// Level is the least severe level written: "debug", "info", "warn" or
+	// "error". Empty means info.
+	Level string `json:"level,omitempty"`
+	// MaxSizeMB is the size at which a file sink is rotated. Zero means
+	// DefaultLogMaxSizeMB.
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+	// MaxBackups is how many rotated files are kept for each file sink.
+	// Zero means DefaultLogMaxBackups.
+	MaxBackups int `json:"max_backups,omitempty"`
+}
+
+// MaxSize returns the size in bytes at which a file sink is rotated.
+func (c LogConfig) MaxSize() int64 {
+	if c.MaxSizeMB <= 0 {
+		return DefaultLogMaxSizeMB << 20
+	}
+	return int64(c.MaxSizeMB) << 20
+}
+
+// Backups returns how many rotated files are kept for each file sink.
+func (c LogConfig) Backups() int {
+	if c.MaxBackups <= 0 {
+		return DefaultLogMaxBackups
+	}
+	return c.MaxBackups
+}
+
+// RequestInfo describes the request a store operation serves, so that the
+// store's logs can be correlated with the caller's. Callers pass it in the
+// context of store operations; see WithRequestInfo.
+type RequestInfo struct {
+	// ID identifies the request in logs.
+	ID string
+	// ObjectID is set by the store to the object the request last acted
+	// on.
+	ObjectID string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying info.
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFrom returns the request info ctx carries, or nil.
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// noteObject records in the request info ctx carries, if any, that the
+// request acts on objectID.
+func noteObject(ctx context.Context, objectID string) {
+	if info := RequestInfoFrom(ctx); info != nil {
+		info.ObjectID = objectID
+	}
+}
+
+// logger returns the logger for the store's messages, which carry the ID
+// of the request ctx carries, if any.
+func logger(ctx context.Context) *slog.Logger {
+	if info := RequestInfoFrom(ctx); info != nil {
+		return slog.Default().With("request_id", info.ID)
+	}
+	return slog.Default()
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestInfo(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	objectID, err := s.CreateObject(ctx, "a.txt", []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &RequestInfo{ID: "req-1"}
+	reqCtx := WithRequestInfo(ctx, info)
+	if _, err := s.ReadObject(reqCtx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if info.ObjectID != objectID {
+		t.Errorf("Expected the request to note object %s, got %q", objectID, info.ObjectID)
+	}
+
+	if err := s.CreateBucket(reqCtx, &Bucket{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := s.InBucket("other").CreateObject(reqCtx, "b.txt", []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ObjectID != otherID {
+		t.Errorf("Expected the request to note object %s, got %q", otherID, info.ObjectID)
+	}
+
+	// Operations outside a request note nothing.
+	if _, err := s.ReadObject(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if info.ObjectID != otherID {
+		t.Errorf("Expected the request info to be left alone, got %q", info.ObjectID)
+	}
+	if RequestInfoFrom(context.Background()) != nil {
+		t.Error("Expected no request info in a bare context")
+	}
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryBackend is a Backend that keeps blobs in memory. It is meant for
+// tests and throwaway servers.
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{blobs: make(map[string]memoryBlob)}
+}
+
+func (b *MemoryBackend) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	var buf bytes.Buffer
+	if size > 0 {
+		buf.Grow(int(size))
+	}
+	if _, err := io.Copy(&buf, newContextReader(ctx, r)); err != nil {
+		return fmt.Errorf("failed to put blob %s: %w", name, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blobs[name] = memoryBlob{data: buf.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	blob, ok := b.blobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, name)
+	}
+	// Blobs are never modified in place, so readers can share the data.
+	return nopSeekCloser{bytes.NewReader(blob.data)}, nil
+}
+
+func (b *MemoryBackend) Stat(ctx context.Context, name string) (*BlobInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	blob, ok := b.blobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, name)
+	}
+	return &BlobInfo{Name: name, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.blobs[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, name)
+	}
+	delete(b.blobs, name)
+	return nil
+}
+
+func (b *MemoryBackend) List(ctx context.Context) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.blobs))
+	for name := range b.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
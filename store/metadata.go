@@ -1,84 +1,801 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var (
+	// ErrMetadataNotFound is returned when no metadata row matches a lookup.
+	ErrMetadataNotFound = errors.New("metadata not found")
+	// ErrInvalidCursor is returned when a listing cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
 type Metadata struct {
 	ObjectID   string
+	Bucket     string
+	ObjectPath string
+	VersionID  string
+	BlobID     string
+	LocalPath  string
+	Size       int64
+	// KeyID identifies the key wrapping the content's data key: a master
+	// key ID, CustomerKeyID, or empty if the content is not encrypted.
+	KeyID string
+	// Codec is the codec the content is compressed with at rest, or empty
+	// if it is stored uncompressed. Size is always the uncompressed size.
+	Codec     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Retention and LegalHold keep the object from being updated or
+	// deleted. Unlike content and headers, they belong to the object rather
+	// than to a version, and are kept across updates.
+	Retention Retention
+	LegalHold bool
+	Headers
+}
+
+// Headers are the HTTP headers stored with a version on upload and returned
+// whenever it is read.
+type Headers struct {
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	CacheControl       string
+	// UserMetadata holds arbitrary client-supplied key/value pairs, keyed by
+	// lower-case name.
+	UserMetadata map[string]string
+}
+
+// Version is an immutable snapshot of an object's content. The metadata row
+// of an object points at its current version.
+type Version struct {
+	Bucket     string
 	ObjectPath string
+	VersionID  string
+	BlobID     string
 	LocalPath  string
+	Size       int64
+	KeyID      string
+	Codec      string
+	CreatedAt  time.Time
+	Headers
+}
+
+// Blob is a stored file shared by every version with the same content.
+// RefCount is the number of versions that reference it.
+type Blob struct {
+	ID string
+	// Hash is the hex-encoded SHA-256 of the content. It is empty for blobs
+	// stored before content addressing, whose content was never hashed.
+	Hash      string
+	Size      int64
+	RefCount  int
+	CreatedAt time.Time
+	// Status is BlobOK unless the scrubber found the content damaged.
+	Status string
+	// LastVerifiedAt is when the scrubber last checked the content, or the
+	// zero time if it never has.
+	LastVerifiedAt time.Time
+	// KeyID identifies the key that wraps WrappedKey, the data key the
+	// content is encrypted with. Both are empty if the content is not
+	// encrypted.
+	KeyID      string
+	WrappedKey []byte
+	// Codec is the codec the content is compressed with, or empty if it is
+	// stored uncompressed. Size is the size of the content uncompressed and
+	// EncodedSize its size compressed, before any encryption.
+	Codec       string
+	EncodedSize int64
+}
+
+// Blob statuses.
+const (
+	BlobOK = "ok"
+	// BlobCorrupt marks a blob whose content no longer matches its hash or
+	// size. Its content has been moved to quarantine.
+	BlobCorrupt = "corrupt"
+	// BlobMissing marks a blob whose content could not be found.
+	BlobMissing = "missing"
+)
+
+// Upload is a multipart upload in progress. Its headers are stored with the
+// object once the upload completes.
+type Upload struct {
+	UploadID   string
+	Bucket     string
+	ObjectPath string
 	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	Headers
+}
+
+// Part is an uploaded part of a multipart upload.
+type Part struct {
+	UploadID   string
+	PartNumber int
+	// Hash is the hex-encoded SHA-256 of the part's content.
+	Hash      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Intent records a blob operation in progress, so that it can be finished
+// or undone if the server stops before the operation completes.
+type Intent struct {
+	ID int64
+	// Kind is IntentPutBlob or IntentDeleteBlob.
+	Kind      string
+	BlobID    string
+	CreatedAt time.Time
+}
+
+// Intent kinds.
+const (
+	IntentPutBlob    = "put_blob"
+	IntentDeleteBlob = "delete_blob"
+)
+
+type Bucket struct {
+	Name string
+	// Versioning keeps previous content as older versions on update. When
+	// disabled, an update replaces the current version.
+	Versioning bool
+	// QuotaBytes caps the total size of the bucket's current objects; zero
+	// means unlimited.
+	QuotaBytes int64
+	// DefaultRetentionDays is the retention period applied to new objects
+	// in the bucket; zero means none.
+	DefaultRetentionDays int
+	// DefaultRetentionMode is the mode of the default retention:
+	// RetentionGovernance or RetentionCompliance. Empty means governance.
+	DefaultRetentionMode string
+	// Compression is the codec new content is compressed with unless a
+	// write asks otherwise; empty means none.
+	Compression string
+	// Lifecycle are the rules ApplyLifecycle enforces on the bucket.
+	Lifecycle []LifecycleRule
+	CreatedAt time.Time
 }
 
 type MetadataStore struct {
 	db *sql.DB
+	// webhooks are the webhooks changes to objects are queued for.
+	webhooks []WebhookConfig
+	// changes wakes the consumers of the change feed.
+	changes *changeNotifier
 }
 
+// migrations bring the database schema up to date. They are applied in order
+// and the number applied so far is tracked in PRAGMA user_version, so new
+// migrations must only ever be appended.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS metadata (
+		object_id TEXT PRIMARY KEY,
+		object_path TEXT UNIQUE,
+		local_path TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`ALTER TABLE metadata ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS buckets (
+		name TEXT PRIMARY KEY,
+		created_at DATETIME NOT NULL
+	)`,
+	// Objects created before versioning get a single version named "null".
+	`ALTER TABLE metadata ADD COLUMN version_id TEXT NOT NULL DEFAULT 'null'`,
+	`CREATE TABLE IF NOT EXISTS versions (
+		object_path TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		local_path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (object_path, version_id)
+	)`,
+	`INSERT INTO versions (object_path, version_id, local_path, size, created_at)
+		SELECT object_path, version_id, local_path, size, updated_at FROM metadata`,
+	`ALTER TABLE buckets ADD COLUMN versioning INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE buckets ADD COLUMN quota_bytes INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE buckets ADD COLUMN default_retention_days INTEGER NOT NULL DEFAULT 0`,
+	// Objects move into a bucket column. Paths the S3 API stored as
+	// "bucket/key" are split; everything else goes to the default bucket.
+	`CREATE TABLE metadata_new (
+		object_id TEXT PRIMARY KEY,
+		bucket TEXT NOT NULL,
+		object_path TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		local_path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		UNIQUE (bucket, object_path)
+	);
+	INSERT INTO metadata_new (object_id, bucket, object_path, version_id, local_path, size, created_at, updated_at)
+		SELECT m.object_id, COALESCE(b.name, 'default'),
+			CASE WHEN b.name IS NULL THEN COALESCE(m.object_path, '') ELSE substr(m.object_path, length(b.name) + 2) END,
+			m.version_id, m.local_path, m.size, m.created_at, m.updated_at
+		FROM metadata m LEFT JOIN buckets b ON substr(m.object_path, 1, length(b.name) + 1) = b.name || '/';
+	DROP TABLE metadata;
+	ALTER TABLE metadata_new RENAME TO metadata`,
+	`CREATE TABLE versions_new (
+		bucket TEXT NOT NULL,
+		object_path TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		local_path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (bucket, object_path, version_id)
+	);
+	INSERT INTO versions_new (bucket, object_path, version_id, local_path, size, created_at)
+		SELECT COALESCE(b.name, 'default'),
+			CASE WHEN b.name IS NULL THEN COALESCE(v.object_path, '') ELSE substr(v.object_path, length(b.name) + 2) END,
+			v.version_id, v.local_path, v.size, v.created_at
+		FROM versions v LEFT JOIN buckets b ON substr(v.object_path, 1, length(b.name) + 1) = b.name || '/';
+	DROP TABLE versions;
+	ALTER TABLE versions_new RENAME TO versions`,
+	`INSERT OR IGNORE INTO buckets (name, created_at) VALUES ('default', CURRENT_TIMESTAMP)`,
+	// Existing files become blobs named after their file; the rtrim idiom
+	// takes the last path element of local_path.
+	`ALTER TABLE metadata ADD COLUMN blob_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE versions ADD COLUMN blob_id TEXT NOT NULL DEFAULT ''`,
+	`UPDATE metadata SET blob_id = substr(local_path, length(rtrim(local_path, replace(local_path, '/', ''))) + 1);
+	UPDATE versions SET blob_id = substr(local_path, length(rtrim(local_path, replace(local_path, '/', ''))) + 1)`,
+	`CREATE TABLE blobs (
+		blob_id TEXT PRIMARY KEY,
+		hash TEXT,
+		size INTEGER NOT NULL,
+		ref_count INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX blobs_hash ON blobs (hash);
+	INSERT INTO blobs (blob_id, hash, size, ref_count, created_at)
+		SELECT blob_id, NULL, MAX(size), COUNT(*), MIN(created_at) FROM versions GROUP BY blob_id`,
+	`ALTER TABLE metadata ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+	ALTER TABLE metadata ADD COLUMN content_encoding TEXT NOT NULL DEFAULT '';
+	ALTER TABLE metadata ADD COLUMN content_disposition TEXT NOT NULL DEFAULT '';
+	ALTER TABLE metadata ADD COLUMN cache_control TEXT NOT NULL DEFAULT '';
+	ALTER TABLE metadata ADD COLUMN user_metadata TEXT NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE versions ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+	ALTER TABLE versions ADD COLUMN content_encoding TEXT NOT NULL DEFAULT '';
+	ALTER TABLE versions ADD COLUMN content_disposition TEXT NOT NULL DEFAULT '';
+	ALTER TABLE versions ADD COLUMN cache_control TEXT NOT NULL DEFAULT '';
+	ALTER TABLE versions ADD COLUMN user_metadata TEXT NOT NULL DEFAULT '{}'`,
+	`CREATE TABLE uploads (
+		upload_id TEXT PRIMARY KEY,
+		bucket TEXT NOT NULL,
+		object_path TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		content_type TEXT NOT NULL,
+		content_encoding TEXT NOT NULL,
+		content_disposition TEXT NOT NULL,
+		cache_control TEXT NOT NULL,
+		user_metadata TEXT NOT NULL
+	);
+	CREATE INDEX uploads_created_at ON uploads (created_at);
+	CREATE TABLE upload_parts (
+		upload_id TEXT NOT NULL,
+		part_number INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (upload_id, part_number)
+	)`,
+	`CREATE TABLE intents (
+		intent_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		blob_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`ALTER TABLE blobs ADD COLUMN status TEXT NOT NULL DEFAULT 'ok';
+	ALTER TABLE blobs ADD COLUMN last_verified_at DATETIME;
+	CREATE INDEX blobs_last_verified_at ON blobs (last_verified_at);
+	CREATE INDEX versions_blob_id ON versions (blob_id)`,
+	`ALTER TABLE blobs ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE blobs ADD COLUMN wrapped_key BLOB;
+	ALTER TABLE metadata ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE versions ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX blobs_key_id ON blobs (key_id)`,
+	`ALTER TABLE buckets ADD COLUMN compression TEXT NOT NULL DEFAULT '';
+	ALTER TABLE blobs ADD COLUMN codec TEXT NOT NULL DEFAULT '';
+	ALTER TABLE blobs ADD COLUMN encoded_size INTEGER NOT NULL DEFAULT 0;
+	UPDATE blobs SET encoded_size = size;
+	ALTER TABLE metadata ADD COLUMN codec TEXT NOT NULL DEFAULT '';
+	ALTER TABLE versions ADD COLUMN codec TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE api_keys (
+		access_key_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		previous_secret TEXT NOT NULL,
+		previous_expires_at DATETIME,
+		admin INTEGER NOT NULL,
+		disabled INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		rotated_at DATETIME
+	)`,
+	`CREATE TABLE policies (
+		kind TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		document TEXT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (kind, subject)
+	)`,
+	`ALTER TABLE buckets ADD COLUMN lifecycle TEXT NOT NULL DEFAULT '';
+	CREATE INDEX metadata_updated_at ON metadata (bucket, updated_at)`,
+	`ALTER TABLE metadata ADD COLUMN retention_mode TEXT NOT NULL DEFAULT '';
+	ALTER TABLE metadata ADD COLUMN retain_until DATETIME;
+	ALTER TABLE metadata ADD COLUMN legal_hold INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE buckets ADD COLUMN default_retention_mode TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE webhook_deliveries (
+		delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		next_attempt_at DATETIME NOT NULL,
+		dead INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX webhook_deliveries_next_attempt_at ON webhook_deliveries (dead, next_attempt_at)`,
+	`CREATE TABLE changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		object_path TEXT NOT NULL,
+		object_id TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		object_created_at DATETIME NOT NULL,
+		object_updated_at DATETIME NOT NULL,
+		time DATETIME NOT NULL
+	);
+	CREATE INDEX changes_bucket ON changes (bucket, seq)`,
+}
+
+const metadataColumns = "object_id, bucket, object_path, version_id, blob_id, local_path, size, key_id, codec, created_at, updated_at, retention_mode, retain_until, legal_hold, " + headerColumns
+
+const versionColumns = "bucket, object_path, version_id, blob_id, local_path, size, key_id, codec, created_at, " + headerColumns
+
+const headerColumns = "content_type, content_encoding, content_disposition, cache_control, user_metadata"
+
+const uploadColumns = "upload_id, bucket, object_path, created_at, " + headerColumns
+
+const partColumns = "upload_id, part_number, hash, size, created_at"
+
+const blobColumns = "blob_id, COALESCE(hash, ''), size, ref_count, created_at, status, last_verified_at, key_id, wrapped_key, codec, encoded_size"
+
+const bucketColumns = "name, versioning, quota_bytes, default_retention_days, default_retention_mode, compression, lifecycle, created_at"
+
+const changeColumns = "seq, type, bucket, object_path, object_id, version_id, size, object_created_at, object_updated_at, time"
+
+const deliveryColumns = "delivery_id, webhook_id, payload, attempts, last_error, next_attempt_at, dead, created_at"
+
+const apiKeyColumns = "access_key_id, secret, previous_secret, previous_expires_at, admin, disabled, created_at, rotated_at"
+
 func NewMetadataStore(dbPath string) (*MetadataStore, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS metadata (
-			object_id TEXT PRIMARY KEY,
-			object_path TEXT UNIQUE,
-			local_path TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			updated_at DATETIME NOT NULL
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &MetadataStore{db: db, changes: newChangeNotifier()}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database.
+func (ms *MetadataStore) Close() error {
+	return ms.db.Close()
+}
+
+func (ms *MetadataStore) Create(ctx context.Context, metadata *Metadata) error {
+	_, err := ms.db.ExecContext(ctx,
+		"INSERT INTO metadata ("+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		metadataValues(metadata)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata: %w", err)
+	}
+	return nil
+}
+
+// CreateWithVersion inserts the metadata row of a new object together with
+// its first version. blob is recorded if it is new. It fails with
+// ErrQuotaExceeded if the object does not fit in its bucket's quota.
+func (ms *MetadataStore) CreateWithVersion(ctx context.Context, metadata *Metadata, version *Version, blob *Blob) error {
+	return ms.withChangeTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO metadata ("+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			metadataValues(metadata)...,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata: %w", err)
+		}
+		if err := insertVersion(ctx, tx, version, blob); err != nil {
+			return err
+		}
+		if err := checkQuota(ctx, tx, metadata.Bucket, metadata.Size); err != nil {
+			return err
+		}
+		return ms.recordEvent(ctx, tx, newEvent(EventObjectCreated, metadata))
+	})
+}
+
+// UpdateWithVersion records a new version of an existing object and updates
+// its metadata row to point at it. blob is recorded if it is new; it is nil
+// if the version references an existing blob. Unless ifVersionID is empty,
+// the update only applies while it is still the object's current version
+// and fails with ErrPreconditionFailed otherwise. It fails with
+// ErrObjectLocked if the object's legal hold or retention refuse the update,
+// see lockedCondition, with ErrObjectNotFound if the object has been deleted
+// meanwhile, and with ErrQuotaExceeded if the update grows its bucket over
+// the quota.
+func (ms *MetadataStore) UpdateWithVersion(ctx context.Context, metadata *Metadata, version *Version, blob *Blob, ifVersionID string, bypassGovernance bool) error {
+	return ms.withChangeTx(ctx, func(tx *sql.Tx) error {
+		if err := insertVersion(ctx, tx, version, blob); err != nil {
+			return err
+		}
+		var previousSize int64
+		err := tx.QueryRowContext(ctx, "SELECT size FROM metadata WHERE object_id = ?", metadata.ObjectID).Scan(&previousSize)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to get metadata: %w", err)
+		}
+		res, err := tx.ExecContext(ctx,
+			`UPDATE metadata SET object_path = ?, version_id = ?, blob_id = ?, local_path = ?, size = ?, key_id = ?, codec = ?, updated_at = ?,
+				content_type = ?, content_encoding = ?, content_disposition = ?, cache_control = ?, user_metadata = ?
+			WHERE object_id = ? AND (? = '' OR version_id = ?) AND NOT `+lockedCondition,
+			metadata.ObjectPath, metadata.VersionID, metadata.BlobID, metadata.LocalPath, metadata.Size, metadata.KeyID, metadata.Codec, metadata.UpdatedAt,
+			metadata.ContentType, metadata.ContentEncoding, metadata.ContentDisposition, metadata.CacheControl, encodeUserMetadata(metadata.UserMetadata),
+			metadata.ObjectID, ifVersionID, ifVersionID, time.Now().UTC(), bypassGovernance,
 		)
-	`)
+		if err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return unchangedError(ctx, tx, metadata.ObjectID, ifVersionID, bypassGovernance)
+		}
+		if err := checkQuota(ctx, tx, metadata.Bucket, metadata.Size-previousSize); err != nil {
+			return err
+		}
+		return ms.recordEvent(ctx, tx, newEvent(EventObjectUpdated, metadata))
+	})
+}
+
+// lockedCondition holds for metadata rows whose legal hold or retention
+// refuse changes to the object. It takes two arguments: the current time,
+// in UTC, and whether governance retention is bypassed.
+const lockedCondition = "(legal_hold OR (COALESCE(retain_until > ?, 0) AND (retention_mode = 'compliance' OR NOT ?)))"
+
+// unchangedError explains why a conditional update or delete of an object's
+// metadata row matched no row: ErrObjectNotFound if the object no longer
+// exists, ErrPreconditionFailed if ifVersionID is no longer current, or
+// ErrObjectLocked if the object is locked. Returning an error rolls back the
+// statements that ran before the metadata row was written.
+func unchangedError(ctx context.Context, tx *sql.Tx, objectID, ifVersionID string, bypassGovernance bool) error {
+	var versionID string
+	var locked bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT version_id, "+lockedCondition+" FROM metadata WHERE object_id = ?",
+		time.Now().UTC(), bypassGovernance, objectID,
+	).Scan(&versionID, &locked)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, objectID)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create metadata table: %w", err)
+		return fmt.Errorf("failed to get metadata: %w", err)
 	}
+	switch {
+	case ifVersionID != "" && versionID != ifVersionID:
+		return fmt.Errorf("%w: version %s is no longer current", ErrPreconditionFailed, ifVersionID)
+	case locked:
+		return fmt.Errorf("%w: %s", ErrObjectLocked, objectID)
+	}
+	return nil
+}
 
-	return &MetadataStore{db: db}, nil
+// checkQuota fails with ErrQuotaExceeded if a write that grew a bucket by
+// delta bytes left the bucket's current objects over its quota. Writes call
+// it in their transaction once their metadata row is written, so that
+// concurrent writes cannot overshoot the quota together.
+func checkQuota(ctx context.Context, tx *sql.Tx, bucket string, delta int64) error {
+	if delta <= 0 {
+		return nil
+	}
+	var quota, usage int64
+	err := tx.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT quota_bytes FROM buckets WHERE name = ?), 0), (SELECT COALESCE(SUM(size), 0) FROM metadata WHERE bucket = ?)",
+		bucket, bucket,
+	).Scan(&quota, &usage)
+	if err != nil {
+		return fmt.Errorf("failed to check quota: %w", err)
+	}
+	if quota > 0 && usage > quota {
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, bucket)
+	}
+	return nil
 }
 
-func (ms *MetadataStore) Create(metadata *Metadata) error {
-	_, err := ms.db.Exec(
-		"INSERT INTO metadata (object_id, object_path, local_path, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
-		metadata.ObjectID, metadata.ObjectPath, metadata.LocalPath, metadata.CreatedAt, metadata.UpdatedAt,
+// insertVersion records a version and takes a reference on its blob,
+// creating the blob row from blob for content that has not been stored
+// before.
+func insertVersion(ctx context.Context, tx *sql.Tx, version *Version, blob *Blob) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO versions ("+versionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		version.Bucket, version.ObjectPath, version.VersionID, version.BlobID, version.LocalPath, version.Size, version.KeyID, version.Codec, version.CreatedAt,
+		version.ContentType, version.ContentEncoding, version.ContentDisposition, version.CacheControl, encodeUserMetadata(version.UserMetadata),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create metadata: %w", err)
+		return fmt.Errorf("failed to create version: %w", err)
+	}
+
+	if blob == nil {
+		_, err = tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count + 1 WHERE blob_id = ?", version.BlobID)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO blobs (blob_id, hash, size, ref_count, created_at, key_id, wrapped_key, codec, encoded_size) VALUES (?, NULLIF(?, ''), ?, 1, ?, ?, ?, ?, ?)
+			ON CONFLICT (blob_id) DO UPDATE SET ref_count = ref_count + 1`,
+			blob.ID, blob.Hash, version.Size, version.CreatedAt, blob.KeyID, blob.WrappedKey, blob.Codec, blob.EncodedSize,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reference blob: %w", err)
+	}
+	return nil
+}
+
+func metadataValues(metadata *Metadata) []any {
+	return []any{
+		metadata.ObjectID, metadata.Bucket, metadata.ObjectPath, metadata.VersionID, metadata.BlobID, metadata.LocalPath, metadata.Size, metadata.KeyID, metadata.Codec, metadata.CreatedAt, metadata.UpdatedAt,
+		metadata.Retention.Mode, nullTime(metadata.Retention.RetainUntil.UTC()), metadata.LegalHold,
+		metadata.ContentType, metadata.ContentEncoding, metadata.ContentDisposition, metadata.CacheControl, encodeUserMetadata(metadata.UserMetadata),
+	}
+}
+
+func encodeUserMetadata(userMetadata map[string]string) string {
+	if len(userMetadata) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(userMetadata)
+	return string(data)
+}
+
+func decodeUserMetadata(data string) (map[string]string, error) {
+	var userMetadata map[string]string
+	if err := json.Unmarshal([]byte(data), &userMetadata); err != nil {
+		return nil, fmt.Errorf("invalid user metadata: %w", err)
+	}
+	if len(userMetadata) == 0 {
+		return nil, nil
+	}
+	return userMetadata, nil
+}
+
+func (ms *MetadataStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := ms.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// withChangeTx is withTx for transactions that record changes. Once the
+// transaction commits, it wakes the consumers waiting for changes.
+func (ms *MetadataStore) withChangeTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if err := ms.withTx(ctx, fn); err != nil {
+		return err
+	}
+	ms.changes.notify()
 	return nil
 }
 
-func (ms *MetadataStore) Get(objectID string) (*Metadata, error) {
-	row := ms.db.QueryRow("SELECT object_id, object_path, local_path, created_at, updated_at FROM metadata WHERE object_id = ?", objectID)
+func (ms *MetadataStore) Get(ctx context.Context, objectID string) (*Metadata, error) {
+	row := ms.db.QueryRowContext(ctx, "SELECT "+metadataColumns+" FROM metadata WHERE object_id = ?", objectID)
 	return ms.scanMetadata(row)
 }
 
-func (ms *MetadataStore) GetByObjectPath(objectPath string) (*Metadata, error) {
-	row := ms.db.QueryRow("SELECT object_id, object_path, local_path, created_at, updated_at FROM metadata WHERE object_path = ?", objectPath)
+func (ms *MetadataStore) GetByObjectPath(ctx context.Context, bucket, objectPath string) (*Metadata, error) {
+	row := ms.db.QueryRowContext(ctx, "SELECT "+metadataColumns+" FROM metadata WHERE bucket = ? AND object_path = ?", bucket, objectPath)
 	return ms.scanMetadata(row)
 }
 
-func (ms *MetadataStore) scanMetadata(row *sql.Row) (*Metadata, error) {
+// DefaultListLimit is the number of entries List returns when no limit is
+// given.
+const DefaultListLimit = 1000
+
+// ListOptions selects a page of objects ordered by object path.
+type ListOptions struct {
+	// Bucket is the bucket to list.
+	Bucket string
+	// Prefix restricts the listing to paths that start with it.
+	Prefix string
+	// Delimiter, if set, groups paths that contain it after Prefix into a
+	// single common prefix ending at the first occurrence of Delimiter.
+	Delimiter string
+	// StartAfter skips paths that sort at or before it.
+	StartAfter string
+	// Cursor continues a previous listing. It takes precedence over
+	// StartAfter.
+	Cursor string
+	// Limit caps the number of objects plus common prefixes returned.
+	Limit int
+}
+
+// ListResult is one page of a listing.
+type ListResult struct {
+	Objects        []*Metadata
+	CommonPrefixes []string
+	IsTruncated    bool
+	// NextCursor is an opaque token for the next page; empty when the
+	// listing is complete.
+	NextCursor string
+}
+
+// List returns a page of objects under opts.Prefix, collapsing paths into
+// common prefixes when opts.Delimiter is set.
+func (ms *MetadataStore) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	after := opts.StartAfter
+	if opts.Cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, opts.Cursor)
+		}
+		after = string(decoded)
+	}
+
+	result := &ListResult{}
+	count := 0
+	for count < limit {
+		page, err := ms.listRange(ctx, opts.Bucket, opts.Prefix, after, limit-count)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, metadata := range page {
+			if opts.Delimiter != "" {
+				rest := metadata.ObjectPath[len(opts.Prefix):]
+				if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+					commonPrefix := opts.Prefix + rest[:i+len(opts.Delimiter)]
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+					count++
+					// Skip the remaining paths under the common prefix and
+					// query again from the end of it.
+					after = prefixEnd(commonPrefix)
+					break
+				}
+			}
+
+			result.Objects = append(result.Objects, metadata)
+			count++
+			after = metadata.ObjectPath
+		}
+	}
+
+	if count == limit {
+		more, err := ms.listRange(ctx, opts.Bucket, opts.Prefix, after, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(more) > 0 {
+			result.IsTruncated = true
+			result.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(after))
+		}
+	}
+
+	return result, nil
+}
+
+// listRange returns up to limit rows in bucket whose object path starts with
+// prefix and sorts after startAfter, ordered by object path.
+func (ms *MetadataStore) listRange(ctx context.Context, bucket, prefix, startAfter string, limit int) ([]*Metadata, error) {
+	rows, err := ms.db.QueryContext(ctx,
+		"SELECT "+metadataColumns+" FROM metadata WHERE bucket = ? AND object_path >= ? AND object_path < ? AND object_path > ? ORDER BY object_path LIMIT ?",
+		bucket, prefix, prefixEnd(prefix), startAfter, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*Metadata
+	for rows.Next() {
+		metadata, err := ms.scanMetadata(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+	return list, nil
+}
+
+// prefixEnd returns a key that sorts after every valid UTF-8 string starting
+// with prefix. 0xff never occurs in UTF-8, so it bounds every such string.
+func prefixEnd(prefix string) string {
+	return prefix + "\xff"
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func (ms *MetadataStore) scanMetadata(row scanner) (*Metadata, error) {
 	metadata := &Metadata{}
-	err := row.Scan(&metadata.ObjectID, &metadata.ObjectPath, &metadata.LocalPath, &metadata.CreatedAt, &metadata.UpdatedAt)
+	var userMetadata string
+	var retainUntil sql.NullTime
+	err := row.Scan(
+		&metadata.ObjectID, &metadata.Bucket, &metadata.ObjectPath, &metadata.VersionID, &metadata.BlobID, &metadata.LocalPath, &metadata.Size, &metadata.KeyID, &metadata.Codec, &metadata.CreatedAt, &metadata.UpdatedAt,
+		&metadata.Retention.Mode, &retainUntil, &metadata.LegalHold,
+		&metadata.ContentType, &metadata.ContentEncoding, &metadata.ContentDisposition, &metadata.CacheControl, &userMetadata,
+	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("metadata not found")
+			return nil, ErrMetadataNotFound
 		}
 		return nil, fmt.Errorf("failed to get metadata: %w", err)
 	}
+	if metadata.UserMetadata, err = decodeUserMetadata(userMetadata); err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	metadata.Retention.RetainUntil = retainUntil.Time
 	return metadata, nil
 }
 
-func (ms *MetadataStore) Update(metadata *Metadata) error {
-	_, err := ms.db.Exec(
-		"UPDATE metadata SET object_path = ?, local_path = ?, updated_at = ? WHERE object_id = ?",
-		metadata.ObjectPath, metadata.LocalPath, metadata.UpdatedAt, metadata.ObjectID,
+func (ms *MetadataStore) Update(ctx context.Context, metadata *Metadata) error {
+	_, err := ms.db.ExecContext(ctx,
+		"UPDATE metadata SET object_path = ?, version_id = ?, local_path = ?, size = ?, updated_at = ? WHERE object_id = ?",
+		metadata.ObjectPath, metadata.VersionID, metadata.LocalPath, metadata.Size, metadata.UpdatedAt, metadata.ObjectID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update metadata: %w", err)
@@ -86,10 +803,1054 @@ func (ms *MetadataStore) Update(metadata *Metadata) error {
 	return nil
 }
 
-func (ms *MetadataStore) Delete(objectID string) error {
-	_, err := ms.db.Exec("DELETE FROM metadata WHERE object_id = ?", objectID)
+// SetRetention replaces the retention of an object. While the object's
+// retention is active, compliance retention can only be extended and
+// governance retention can only be shortened or lifted if bypassGovernance
+// is set; it fails with ErrObjectLocked otherwise. The rule is checked by
+// the update itself so that concurrent changes cannot weaken retention.
+func (ms *MetadataStore) SetRetention(ctx context.Context, objectID string, retention Retention, bypassGovernance bool) error {
+	return ms.withChangeTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE metadata SET retention_mode = ?, retain_until = ?
+			WHERE object_id = ? AND NOT (COALESCE(retain_until > ?, 0) AND CASE retention_mode
+				WHEN 'compliance' THEN NOT (? = 'compliance' AND ? >= retain_until)
+				WHEN 'governance' THEN (? = '' OR ? < retain_until) AND NOT ?
+				ELSE 0
+			END)`,
+			retention.Mode, nullTime(retention.RetainUntil.UTC()),
+			objectID, time.Now().UTC(),
+			retention.Mode, retention.RetainUntil.UTC(),
+			retention.Mode, retention.RetainUntil.UTC(), bypassGovernance,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to set retention: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return retentionUnchangedError(ctx, tx, objectID)
+		}
+		return ms.recordLockChange(ctx, tx, objectID)
+	})
+}
+
+// retentionUnchangedError explains why SetRetention matched no row.
+func retentionUnchangedError(ctx context.Context, tx *sql.Tx, objectID string) error {
+	var objectPath, mode string
+	err := tx.QueryRowContext(ctx, "SELECT object_path, retention_mode FROM metadata WHERE object_id = ?", objectID).Scan(&objectPath, &mode)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, objectID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get metadata: %w", err)
+	}
+	if mode == RetentionCompliance {
+		return fmt.Errorf("%w: compliance retention of %s can only be extended", ErrObjectLocked, objectPath)
+	}
+	return fmt.Errorf("%w: shortening governance retention of %s requires bypassing governance", ErrObjectLocked, objectPath)
+}
+
+// SetLegalHold places an object under legal hold or releases it. Unless
+// mayRelease is set, it fails with ErrObjectLocked rather than release a
+// hold.
+func (ms *MetadataStore) SetLegalHold(ctx context.Context, objectID string, hold, mayRelease bool) error {
+	return ms.withChangeTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE metadata SET legal_hold = ? WHERE object_id = ? AND (? OR ? OR NOT legal_hold)",
+			hold, objectID, hold, mayRelease,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to set legal hold: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return unchangedError(ctx, tx, objectID, "", false)
+		}
+		return ms.recordLockChange(ctx, tx, objectID)
+	})
+}
+
+// recordLockChange records that the retention or legal hold of an object
+// changed.
+func (ms *MetadataStore) recordLockChange(ctx context.Context, tx *sql.Tx, objectID string) error {
+	metadata, err := ms.scanMetadata(tx.QueryRowContext(ctx, "SELECT "+metadataColumns+" FROM metadata WHERE object_id = ?", objectID))
+	if err != nil {
+		return err
+	}
+	return ms.recordEvent(ctx, tx, newEvent(EventObjectLockChanged, metadata))
+}
+
+// Delete removes the metadata row of an object along with all its versions,
+// dropping the versions' blob references. It fails with ErrObjectLocked if
+// the object is locked.
+func (ms *MetadataStore) Delete(ctx context.Context, objectID string) error {
+	return ms.DeleteIfVersion(ctx, objectID, "", false)
+}
+
+// DeleteIfVersion is Delete conditional on the object's current version.
+// Unless ifVersionID is empty, it fails with ErrPreconditionFailed when
+// ifVersionID is no longer current. Governance retention does not refuse
+// the delete if bypassGovernance is set.
+func (ms *MetadataStore) DeleteIfVersion(ctx context.Context, objectID, ifVersionID string, bypassGovernance bool) error {
+	return ms.withChangeTx(ctx, func(tx *sql.Tx) error {
+		// The transaction starts with a write so that SQLite takes the write
+		// lock up front, waiting for other writers, rather than failing to
+		// upgrade a read lock. Only the blobs of the object's versions are
+		// touched.
+		_, err := tx.ExecContext(ctx,
+			`UPDATE blobs SET ref_count = ref_count - (
+				SELECT COUNT(*) FROM versions v JOIN metadata m ON (v.bucket, v.object_path) = (m.bucket, m.object_path)
+				WHERE m.object_id = ? AND v.blob_id = blobs.blob_id
+			)
+			WHERE blob_id IN (
+				SELECT v.blob_id FROM versions v JOIN metadata m ON (v.bucket, v.object_path) = (m.bucket, m.object_path)
+				WHERE m.object_id = ?
+			)`,
+			objectID, objectID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to release blobs: %w", err)
+		}
+		metadata, err := ms.scanMetadata(tx.QueryRowContext(ctx, "SELECT "+metadataColumns+" FROM metadata WHERE object_id = ?", objectID))
+		if errors.Is(err, ErrMetadataNotFound) {
+			return unchangedError(ctx, tx, objectID, ifVersionID, bypassGovernance)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM versions WHERE (bucket, object_path) = (SELECT bucket, object_path FROM metadata WHERE object_id = ?)", objectID)
+		if err != nil {
+			return fmt.Errorf("failed to delete versions: %w", err)
+		}
+		// The metadata row goes last so that a failed condition rolls back
+		// the statements above.
+		res, err := tx.ExecContext(ctx,
+			"DELETE FROM metadata WHERE object_id = ? AND (? = '' OR version_id = ?) AND NOT "+lockedCondition,
+			objectID, ifVersionID, ifVersionID, time.Now().UTC(), bypassGovernance,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete metadata: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return unchangedError(ctx, tx, objectID, ifVersionID, bypassGovernance)
+		}
+		return ms.recordEvent(ctx, tx, newEvent(EventObjectDeleted, metadata))
+	})
+}
+
+func (ms *MetadataStore) GetVersion(ctx context.Context, bucket, objectPath, versionID string) (*Version, error) {
+	row := ms.db.QueryRowContext(ctx, "SELECT "+versionColumns+" FROM versions WHERE bucket = ? AND object_path = ? AND version_id = ?", bucket, objectPath, versionID)
+	version, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrMetadataNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+	return version, nil
+}
+
+// ListVersions returns every version of an object, newest first.
+func (ms *MetadataStore) ListVersions(ctx context.Context, bucket, objectPath string) ([]*Version, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT "+versionColumns+" FROM versions WHERE bucket = ? AND object_path = ? ORDER BY rowid DESC", bucket, objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*Version
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	return versions, nil
+}
+
+// DeleteVersion removes a single version record, drops its blob reference
+// and records the deletion. The caller is responsible for releasing the
+// blob.
+func (ms *MetadataStore) DeleteVersion(ctx context.Context, version *Version) error {
+	return ms.withChangeTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM versions WHERE bucket = ? AND object_path = ? AND version_id = ?", version.Bucket, version.ObjectPath, version.VersionID)
+		if err != nil {
+			return fmt.Errorf("failed to delete version: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count - 1 WHERE blob_id = ?", version.BlobID)
+		if err != nil {
+			return fmt.Errorf("failed to release blob: %w", err)
+		}
+
+		event := &Event{
+			Type:       EventObjectVersionDeleted,
+			Bucket:     version.Bucket,
+			ObjectPath: version.ObjectPath,
+			VersionID:  version.VersionID,
+			Size:       version.Size,
+			CreatedAt:  version.CreatedAt,
+			UpdatedAt:  version.CreatedAt,
+			Time:       time.Now(),
+		}
+		// The object the version belonged to may be gone already.
+		err = tx.QueryRowContext(ctx, "SELECT object_id FROM metadata WHERE bucket = ? AND object_path = ?", version.Bucket, version.ObjectPath).Scan(&event.ObjectID)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to get metadata: %w", err)
+		}
+		return ms.recordEvent(ctx, tx, event)
+	})
+}
+
+func (ms *MetadataStore) GetBlob(ctx context.Context, blobID string) (*Blob, error) {
+	return ms.queryBlob(ctx, "SELECT "+blobColumns+" FROM blobs WHERE blob_id = ?", blobID)
+}
+
+// GetBlobByHash returns an undamaged blob whose content has the given
+// SHA-256 and is encrypted under a master key if encrypted is set, or not
+// encrypted otherwise. Blobs encrypted under customer keys are never
+// returned.
+func (ms *MetadataStore) GetBlobByHash(ctx context.Context, hash string, encrypted bool) (*Blob, error) {
+	return ms.queryBlob(ctx,
+		"SELECT "+blobColumns+" FROM blobs WHERE hash = ? AND status = ? AND (key_id != '') = ? AND key_id != ? LIMIT 1",
+		hash, BlobOK, encrypted, CustomerKeyID,
+	)
+}
+
+func (ms *MetadataStore) queryBlob(ctx context.Context, query string, args ...any) (*Blob, error) {
+	blob, err := scanBlob(ms.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMetadataNotFound
+		}
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+	return blob, nil
+}
+
+func scanBlob(row scanner) (*Blob, error) {
+	blob := &Blob{}
+	var lastVerifiedAt sql.NullTime
+	if err := row.Scan(&blob.ID, &blob.Hash, &blob.Size, &blob.RefCount, &blob.CreatedAt, &blob.Status, &lastVerifiedAt, &blob.KeyID, &blob.WrappedKey, &blob.Codec, &blob.EncodedSize); err != nil {
+		return nil, err
+	}
+	blob.LastVerifiedAt = lastVerifiedAt.Time
+	return blob, nil
+}
+
+// ListBlobsToVerify returns up to limit undamaged blobs that have not been
+// verified since before cutoff, those never verified first.
+func (ms *MetadataStore) ListBlobsToVerify(ctx context.Context, cutoff time.Time, limit int) ([]*Blob, error) {
+	return ms.listBlobs(ctx, "SELECT "+blobColumns+" FROM blobs WHERE status = ? AND (last_verified_at IS NULL OR last_verified_at < ?) ORDER BY last_verified_at, blob_id LIMIT ?", BlobOK, cutoff, limit)
+}
+
+// ListBlobs returns every blob.
+func (ms *MetadataStore) ListBlobs(ctx context.Context) ([]*Blob, error) {
+	return ms.listBlobs(ctx, "SELECT "+blobColumns+" FROM blobs ORDER BY blob_id")
+}
+
+// ListBlobsToRewrap returns up to limit blobs whose data keys are wrapped
+// by a master key other than keyID.
+func (ms *MetadataStore) ListBlobsToRewrap(ctx context.Context, keyID string, limit int) ([]*Blob, error) {
+	return ms.listBlobs(ctx, "SELECT "+blobColumns+" FROM blobs WHERE key_id NOT IN ('', ?, ?) ORDER BY blob_id LIMIT ?", keyID, CustomerKeyID, limit)
+}
+
+// RewrapBlob replaces the wrapped data key of a blob and records the master
+// key now wrapping it on the blob and on every version of its content.
+func (ms *MetadataStore) RewrapBlob(ctx context.Context, blobID, keyID string, wrappedKey []byte) error {
+	return ms.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE blobs SET key_id = ?, wrapped_key = ? WHERE blob_id = ?", keyID, wrappedKey, blobID); err != nil {
+			return fmt.Errorf("failed to rewrap blob: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE versions SET key_id = ? WHERE blob_id = ?", keyID, blobID); err != nil {
+			return fmt.Errorf("failed to rewrap blob: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE metadata SET key_id = ? WHERE blob_id = ?", keyID, blobID); err != nil {
+			return fmt.Errorf("failed to rewrap blob: %w", err)
+		}
+		return nil
+	})
+}
+
+// KeyInUse reports whether any blob's data key is wrapped by keyID.
+func (ms *MetadataStore) KeyInUse(ctx context.Context, keyID string) (bool, error) {
+	var inUse bool
+	if err := ms.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM blobs WHERE key_id = ?)", keyID).Scan(&inUse); err != nil {
+		return false, fmt.Errorf("failed to check key: %w", err)
+	}
+	return inUse, nil
+}
+
+// ListDamagedBlobs returns the blobs whose status is not BlobOK.
+func (ms *MetadataStore) ListDamagedBlobs(ctx context.Context) ([]*Blob, error) {
+	return ms.listBlobs(ctx, "SELECT "+blobColumns+" FROM blobs WHERE status != ? ORDER BY blob_id", BlobOK)
+}
+
+func (ms *MetadataStore) listBlobs(ctx context.Context, query string, args ...any) ([]*Blob, error) {
+	rows, err := ms.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []*Blob
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+		blobs = append(blobs, blob)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	return blobs, nil
+}
+
+// CountBlobsByStatus returns the number of blobs with each status.
+func (ms *MetadataStore) CountBlobsByStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM blobs GROUP BY status")
 	if err != nil {
-		return fmt.Errorf("failed to delete metadata: %w", err)
+		return nil, fmt.Errorf("failed to count blobs: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("failed to count blobs: %w", err)
+		}
+		counts[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count blobs: %w", err)
+	}
+	return counts, nil
+}
+
+// SetBlobVerified records the outcome of verifying a blob's content.
+func (ms *MetadataStore) SetBlobVerified(ctx context.Context, blobID, status string, verifiedAt time.Time) error {
+	if _, err := ms.db.ExecContext(ctx, "UPDATE blobs SET status = ?, last_verified_at = ? WHERE blob_id = ?", status, verifiedAt, blobID); err != nil {
+		return fmt.Errorf("failed to update blob: %w", err)
 	}
 	return nil
 }
+
+// ListVersionsByBlob returns every version whose content is the given blob.
+func (ms *MetadataStore) ListVersionsByBlob(ctx context.Context, blobID string) ([]*Version, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT "+versionColumns+" FROM versions WHERE blob_id = ? ORDER BY bucket, object_path, rowid", blobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*Version
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	return versions, nil
+}
+
+// DeleteBlobIfUnreferenced removes a blob row once no version references it
+// and reports whether it did.
+func (ms *MetadataStore) DeleteBlobIfUnreferenced(ctx context.Context, blobID string) (bool, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM blobs WHERE blob_id = ? AND ref_count <= 0", blobID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete blob: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CreateIntent records an intent and sets its ID.
+func (ms *MetadataStore) CreateIntent(ctx context.Context, intent *Intent) error {
+	res, err := ms.db.ExecContext(ctx, "INSERT INTO intents (kind, blob_id, created_at) VALUES (?, ?, ?)", intent.Kind, intent.BlobID, intent.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create intent: %w", err)
+	}
+	if intent.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to create intent: %w", err)
+	}
+	return nil
+}
+
+// ListIntents returns the recorded intents, oldest first.
+func (ms *MetadataStore) ListIntents(ctx context.Context) ([]*Intent, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT intent_id, kind, blob_id, created_at FROM intents ORDER BY intent_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list intents: %w", err)
+	}
+	defer rows.Close()
+
+	var intents []*Intent
+	for rows.Next() {
+		intent := &Intent{}
+		if err := rows.Scan(&intent.ID, &intent.Kind, &intent.BlobID, &intent.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list intents: %w", err)
+		}
+		intents = append(intents, intent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list intents: %w", err)
+	}
+	return intents, nil
+}
+
+// HasPutIntent reports whether an IntentPutBlob other than the one with ID
+// except is recorded for a blob.
+func (ms *MetadataStore) HasPutIntent(ctx context.Context, blobID string, except int64) (bool, error) {
+	var exists bool
+	err := ms.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM intents WHERE kind = ? AND blob_id = ? AND intent_id != ?)", IntentPutBlob, blobID, except).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check intents: %w", err)
+	}
+	return exists, nil
+}
+
+func (ms *MetadataStore) DeleteIntent(ctx context.Context, id int64) error {
+	if _, err := ms.db.ExecContext(ctx, "DELETE FROM intents WHERE intent_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete intent: %w", err)
+	}
+	return nil
+}
+
+func scanVersion(row scanner) (*Version, error) {
+	version := &Version{}
+	var userMetadata string
+	err := row.Scan(
+		&version.Bucket, &version.ObjectPath, &version.VersionID, &version.BlobID, &version.LocalPath, &version.Size, &version.KeyID, &version.Codec, &version.CreatedAt,
+		&version.ContentType, &version.ContentEncoding, &version.ContentDisposition, &version.CacheControl, &userMetadata,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if version.UserMetadata, err = decodeUserMetadata(userMetadata); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (ms *MetadataStore) CreateUpload(ctx context.Context, upload *Upload) error {
+	_, err := ms.db.ExecContext(ctx,
+		"INSERT INTO uploads ("+uploadColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		upload.UploadID, upload.Bucket, upload.ObjectPath, upload.CreatedAt,
+		upload.ContentType, upload.ContentEncoding, upload.ContentDisposition, upload.CacheControl, encodeUserMetadata(upload.UserMetadata),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	return nil
+}
+
+func (ms *MetadataStore) GetUpload(ctx context.Context, uploadID string) (*Upload, error) {
+	upload, err := scanUpload(ms.db.QueryRowContext(ctx, "SELECT "+uploadColumns+" FROM uploads WHERE upload_id = ?", uploadID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMetadataNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+	return upload, nil
+}
+
+// ListUploadsBefore returns the uploads initiated before t, oldest first.
+func (ms *MetadataStore) ListUploadsBefore(ctx context.Context, t time.Time) ([]*Upload, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT "+uploadColumns+" FROM uploads WHERE created_at < ? ORDER BY created_at", t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var uploads []*Upload
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list uploads: %w", err)
+		}
+		uploads = append(uploads, upload)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+	return uploads, nil
+}
+
+// PutPart records an uploaded part, replacing any earlier part with the same
+// number. It returns ErrMetadataNotFound if the upload no longer exists.
+func (ms *MetadataStore) PutPart(ctx context.Context, part *Part) error {
+	res, err := ms.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO upload_parts ("+partColumns+") SELECT ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM uploads WHERE upload_id = ?)",
+		part.UploadID, part.PartNumber, part.Hash, part.Size, part.CreatedAt, part.UploadID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record part: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMetadataNotFound
+	}
+	return nil
+}
+
+// ListParts returns the parts of an upload ordered by part number.
+func (ms *MetadataStore) ListParts(ctx context.Context, uploadID string) ([]*Part, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT "+partColumns+" FROM upload_parts WHERE upload_id = ? ORDER BY part_number", uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []*Part
+	for rows.Next() {
+		part := &Part{}
+		if err := rows.Scan(&part.UploadID, &part.PartNumber, &part.Hash, &part.Size, &part.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list parts: %w", err)
+		}
+		parts = append(parts, part)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+	return parts, nil
+}
+
+// DeleteUpload removes an upload and the records of its parts.
+func (ms *MetadataStore) DeleteUpload(ctx context.Context, uploadID string) error {
+	return ms.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM upload_parts WHERE upload_id = ?", uploadID); err != nil {
+			return fmt.Errorf("failed to delete parts: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM uploads WHERE upload_id = ?", uploadID); err != nil {
+			return fmt.Errorf("failed to delete upload: %w", err)
+		}
+		return nil
+	})
+}
+
+func scanUpload(row scanner) (*Upload, error) {
+	upload := &Upload{}
+	var userMetadata string
+	err := row.Scan(
+		&upload.UploadID, &upload.Bucket, &upload.ObjectPath, &upload.CreatedAt,
+		&upload.ContentType, &upload.ContentEncoding, &upload.ContentDisposition, &upload.CacheControl, &userMetadata,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if upload.UserMetadata, err = decodeUserMetadata(userMetadata); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (ms *MetadataStore) CreateBucket(ctx context.Context, bucket *Bucket) error {
+	lifecycle, err := encodeLifecycle(bucket.Lifecycle)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+	_, err = ms.db.ExecContext(ctx,
+		"INSERT INTO buckets ("+bucketColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		bucket.Name, bucket.Versioning, bucket.QuotaBytes, bucket.DefaultRetentionDays, bucket.DefaultRetentionMode, bucket.Compression, lifecycle, bucket.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+	return nil
+}
+
+func (ms *MetadataStore) GetBucket(ctx context.Context, name string) (*Bucket, error) {
+	bucket, err := scanBucket(ms.db.QueryRowContext(ctx, "SELECT "+bucketColumns+" FROM buckets WHERE name = ?", name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMetadataNotFound
+		}
+		return nil, fmt.Errorf("failed to get bucket: %w", err)
+	}
+	return bucket, nil
+}
+
+func (ms *MetadataStore) ListBuckets(ctx context.Context) ([]*Bucket, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT "+bucketColumns+" FROM buckets ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []*Bucket
+	for rows.Next() {
+		bucket, err := scanBucket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list buckets: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	return buckets, nil
+}
+
+func (ms *MetadataStore) UpdateBucket(ctx context.Context, bucket *Bucket) error {
+	lifecycle, err := encodeLifecycle(bucket.Lifecycle)
+	if err != nil {
+		return fmt.Errorf("failed to update bucket: %w", err)
+	}
+	_, err = ms.db.ExecContext(ctx,
+		"UPDATE buckets SET versioning = ?, quota_bytes = ?, default_retention_days = ?, default_retention_mode = ?, compression = ?, lifecycle = ? WHERE name = ?",
+		bucket.Versioning, bucket.QuotaBytes, bucket.DefaultRetentionDays, bucket.DefaultRetentionMode, bucket.Compression, lifecycle, bucket.Name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update bucket: %w", err)
+	}
+	return nil
+}
+
+// DeleteBucket deletes a bucket along with its policy.
+func (ms *MetadataStore) DeleteBucket(ctx context.Context, name string) error {
+	return ms.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM buckets WHERE name = ?", name); err != nil {
+			return fmt.Errorf("failed to delete bucket: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM policies WHERE kind = ? AND subject = ?", PolicyBucket, name); err != nil {
+			return fmt.Errorf("failed to delete bucket policy: %w", err)
+		}
+		return nil
+	})
+}
+
+// HasBucketData reports whether any object versions or pending multipart
+// uploads remain in a bucket.
+func (ms *MetadataStore) HasBucketData(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	err := ms.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM versions WHERE bucket = ?) OR EXISTS (SELECT 1 FROM uploads WHERE bucket = ?)",
+		name, name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check bucket contents: %w", err)
+	}
+	return exists, nil
+}
+
+// BucketUsage returns the total size of the current objects in a bucket.
+func (ms *MetadataStore) BucketUsage(ctx context.Context, name string) (int64, error) {
+	var usage int64
+	err := ms.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM metadata WHERE bucket = ?", name).Scan(&usage)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute bucket usage: %w", err)
+	}
+	return usage, nil
+}
+
+// ObjectStats counts the objects in a bucket.
+type ObjectStats struct {
+	Objects int64
+	// Bytes is the size of the objects' current versions.
+	Bytes int64
+}
+
+// ObjectStats returns the statistics of each bucket holding objects.
+func (ms *MetadataStore) ObjectStats(ctx context.Context) (map[string]ObjectStats, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT bucket, COUNT(*), COALESCE(SUM(size), 0) FROM metadata GROUP BY bucket")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count objects: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]ObjectStats)
+	for rows.Next() {
+		var bucket string
+		var st ObjectStats
+		if err := rows.Scan(&bucket, &st.Objects, &st.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to count objects: %w", err)
+		}
+		stats[bucket] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count objects: %w", err)
+	}
+	return stats, nil
+}
+
+// BlobBytes returns the total size of the stored blobs.
+func (ms *MetadataStore) BlobBytes(ctx context.Context) (int64, error) {
+	var n int64
+	if err := ms.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM blobs").Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to sum blob sizes: %w", err)
+	}
+	return n, nil
+}
+
+func scanBucket(row scanner) (*Bucket, error) {
+	bucket := &Bucket{}
+	var lifecycle string
+	err := row.Scan(&bucket.Name, &bucket.Versioning, &bucket.QuotaBytes, &bucket.DefaultRetentionDays, &bucket.DefaultRetentionMode, &bucket.Compression, &lifecycle, &bucket.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lifecycle != "" {
+		if err := json.Unmarshal([]byte(lifecycle), &bucket.Lifecycle); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+// encodeLifecycle encodes lifecycle rules for the lifecycle column, which is
+// empty for buckets without rules.
+func encodeLifecycle(rules []LifecycleRule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(rules)
+	return string(data), err
+}
+
+// ListObjectsUpdatedBefore returns the objects in bucket whose path starts
+// with prefix and that were last updated before t.
+func (ms *MetadataStore) ListObjectsUpdatedBefore(ctx context.Context, bucket, prefix string, t time.Time) ([]*Metadata, error) {
+	rows, err := ms.db.QueryContext(ctx,
+		"SELECT "+metadataColumns+" FROM metadata WHERE bucket = ? AND object_path >= ? AND object_path < ? AND updated_at < ? ORDER BY object_path",
+		bucket, prefix, prefixEnd(prefix), t,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*Metadata
+	for rows.Next() {
+		metadata, err := ms.scanMetadata(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+	return list, nil
+}
+
+// ListNoncurrentVersionsBefore returns the versions of objects in bucket
+// whose path starts with prefix that a newer version replaced before t.
+func (ms *MetadataStore) ListNoncurrentVersionsBefore(ctx context.Context, bucket, prefix string, t time.Time) ([]*Version, error) {
+	rows, err := ms.db.QueryContext(ctx,
+		"SELECT "+versionColumns+" FROM versions v WHERE bucket = ? AND object_path >= ? AND object_path < ? AND EXISTS ("+
+			"SELECT 1 FROM versions n WHERE n.bucket = v.bucket AND n.object_path = v.object_path AND n.rowid > v.rowid AND n.created_at < ?"+
+			") ORDER BY object_path, rowid",
+		bucket, prefix, prefixEnd(prefix), t,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*Version
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (ms *MetadataStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	_, err := ms.db.ExecContext(ctx,
+		"INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		key.AccessKeyID, key.Secret, key.PreviousSecret, nullTime(key.PreviousExpiresAt), key.Admin, key.Disabled, key.CreatedAt, nullTime(key.RotatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (ms *MetadataStore) GetAPIKey(ctx context.Context, accessKeyID string) (*APIKey, error) {
+	key, err := scanAPIKey(ms.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE access_key_id = ?", accessKeyID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMetadataNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+func (ms *MetadataStore) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	rows, err := ms.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY access_key_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list API keys: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// UpdateAPIKey stores the secrets and flags of an existing API key.
+func (ms *MetadataStore) UpdateAPIKey(ctx context.Context, key *APIKey) error {
+	res, err := ms.db.ExecContext(ctx,
+		`UPDATE api_keys SET secret = ?, previous_secret = ?, previous_expires_at = ?, admin = ?, disabled = ?, rotated_at = ?
+		WHERE access_key_id = ?`,
+		key.Secret, key.PreviousSecret, nullTime(key.PreviousExpiresAt), key.Admin, key.Disabled, nullTime(key.RotatedAt),
+		key.AccessKeyID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMetadataNotFound
+	}
+	return nil
+}
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	key := &APIKey{}
+	var previousExpiresAt, rotatedAt sql.NullTime
+	err := row.Scan(&key.AccessKeyID, &key.Secret, &key.PreviousSecret, &previousExpiresAt, &key.Admin, &key.Disabled, &key.CreatedAt, &rotatedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.PreviousExpiresAt = previousExpiresAt.Time
+	key.RotatedAt = rotatedAt.Time
+	return key, nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// PutPolicy attaches a policy to a subject, replacing any it had.
+func (ms *MetadataStore) PutPolicy(ctx context.Context, kind, subject string, policy *Policy) error {
+	document, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to encode policy: %w", err)
+	}
+	_, err = ms.db.ExecContext(ctx,
+		`INSERT INTO policies (kind, subject, document, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (kind, subject) DO UPDATE SET document = excluded.document, updated_at = excluded.updated_at`,
+		kind, subject, string(document), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to put policy: %w", err)
+	}
+	return nil
+}
+
+func (ms *MetadataStore) GetPolicy(ctx context.Context, kind, subject string) (*Policy, error) {
+	var document string
+	err := ms.db.QueryRowContext(ctx, "SELECT document FROM policies WHERE kind = ? AND subject = ?", kind, subject).Scan(&document)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMetadataNotFound
+		}
+		return nil, fmt.Errorf("failed to get policy: %w", err)
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal([]byte(document), policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %w", err)
+	}
+	return policy, nil
+}
+
+func (ms *MetadataStore) DeletePolicy(ctx context.Context, kind, subject string) error {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM policies WHERE kind = ? AND subject = ?", kind, subject)
+	if err != nil {
+		return fmt.Errorf("failed to delete policy: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMetadataNotFound
+	}
+	return nil
+}
+
+// ListDueDeliveries returns up to limit deliveries that are due at now and
+// not dead-lettered, oldest first.
+func (ms *MetadataStore) ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*Delivery, error) {
+	return ms.listDeliveries(ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE dead = 0 AND next_attempt_at <= ? ORDER BY delivery_id LIMIT ?",
+		now.UTC(), limit,
+	)
+}
+
+// ListDeadDeliveries returns the dead-lettered deliveries, oldest first.
+func (ms *MetadataStore) ListDeadDeliveries(ctx context.Context) ([]*Delivery, error) {
+	return ms.listDeliveries(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE dead = 1 ORDER BY delivery_id")
+}
+
+func (ms *MetadataStore) listDeliveries(ctx context.Context, query string, args ...any) ([]*Delivery, error) {
+	rows, err := ms.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		delivery := &Delivery{}
+		var payload string
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &payload, &delivery.Attempts, &delivery.LastError, &delivery.NextAttemptAt, &delivery.Dead, &delivery.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deliveries: %w", err)
+		}
+		delivery.payload = []byte(payload)
+		if err := json.Unmarshal(delivery.payload, &delivery.Event); err != nil {
+			return nil, fmt.Errorf("failed to decode delivery %d: %w", delivery.ID, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// UpdateDelivery records a failed attempt at a delivery.
+func (ms *MetadataStore) UpdateDelivery(ctx context.Context, delivery *Delivery) error {
+	_, err := ms.db.ExecContext(ctx,
+		"UPDATE webhook_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ?, dead = ? WHERE delivery_id = ?",
+		delivery.Attempts, delivery.LastError, delivery.NextAttemptAt.UTC(), delivery.Dead, delivery.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	return nil
+}
+
+// DeleteDelivery removes a delivery once it succeeded.
+func (ms *MetadataStore) DeleteDelivery(ctx context.Context, id int64) error {
+	if _, err := ms.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE delivery_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete delivery: %w", err)
+	}
+	return nil
+}
+
+// RetryDelivery makes a dead-lettered delivery due again with no failed
+// attempts. It returns ErrMetadataNotFound if no dead-lettered delivery has
+// the ID.
+func (ms *MetadataStore) RetryDelivery(ctx context.Context, id int64) error {
+	res, err := ms.db.ExecContext(ctx,
+		"UPDATE webhook_deliveries SET attempts = 0, dead = 0, next_attempt_at = ? WHERE delivery_id = ? AND dead = 1",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to retry delivery: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMetadataNotFound
+	}
+	return nil
+}
+
+// recordEvent records event in the change feed, setting its sequence
+// number, and queues it for delivery to every webhook it matches, as part of
+// the transaction making the change it describes.
+func (ms *MetadataStore) recordEvent(ctx context.Context, tx *sql.Tx, event *Event) error {
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO changes (type, bucket, object_path, object_id, version_id, size, object_created_at, object_updated_at, time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.Type, event.Bucket, event.ObjectPath, event.ObjectID, event.VersionID, event.Size, event.CreatedAt, event.UpdatedAt, event.Time.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	if event.Seq, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	var payload []byte
+	for _, webhook := range ms.webhooks {
+		if !webhook.Matches(event) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(event); err != nil {
+				return fmt.Errorf("failed to queue event: %w", err)
+			}
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO webhook_deliveries (webhook_id, payload, attempts, last_error, next_attempt_at, dead, created_at)
+			VALUES (?, ?, 0, '', ?, 0, ?)`,
+			webhook.ID, string(payload), event.Time.UTC(), event.Time,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to queue event: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListChanges returns up to limit changes to objects in bucket under prefix
+// with sequence numbers above since, oldest first.
+func (ms *MetadataStore) ListChanges(ctx context.Context, bucket, prefix string, since int64, limit int) ([]*Event, error) {
+	rows, err := ms.db.QueryContext(ctx,
+		"SELECT "+changeColumns+" FROM changes WHERE bucket = ? AND seq > ? AND object_path >= ? AND object_path < ? ORDER BY seq LIMIT ?",
+		bucket, since, prefix, prefixEnd(prefix), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*Event
+	for rows.Next() {
+		event := &Event{}
+		err := rows.Scan(&event.Seq, &event.Type, &event.Bucket, &event.ObjectPath, &event.ObjectID, &event.VersionID, &event.Size, &event.CreatedAt, &event.UpdatedAt, &event.Time)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list changes: %w", err)
+		}
+		changes = append(changes, event)
+	}
+	return changes, rows.Err()
+}
+
+// OldestChange returns the sequence number of the oldest change kept. If
+// none are, it is the sequence number the next change will get.
+func (ms *MetadataStore) OldestChange(ctx context.Context) (int64, error) {
+	var oldest int64
+	err := ms.db.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT MIN(seq) FROM changes), (SELECT seq + 1 FROM sqlite_sequence WHERE name = 'changes'), 1)",
+	).Scan(&oldest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get oldest change: %w", err)
+	}
+	return oldest, nil
+}
+
+// PruneChanges deletes the changes recorded before t and returns how many
+// it deleted.
+func (ms *MetadataStore) PruneChanges(ctx context.Context, t time.Time) (int64, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM changes WHERE time < ?", t.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune changes: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -1,7 +1,12 @@
 package store
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -24,6 +29,7 @@ func TestMetadataStore(t *testing.T) {
 
 func testCreateMetadata(ms *MetadataStore) func(*testing.T) {
 	return func(t *testing.T) {
+		ctx := t.Context()
 		metadata := &Metadata{
 			ObjectID:  "test1",
 			LocalPath: "path/to/test1",
@@ -31,13 +37,13 @@ func testCreateMetadata(ms *MetadataStore) func(*testing.T) {
 			UpdatedAt: time.Now(),
 		}
 
-		err := ms.Create(metadata)
+		err := ms.Create(ctx, metadata)
 		if err != nil {
 			t.Errorf("Failed to create metadata: %v", err)
 		}
 
 		// Verify creation
-		stored, err := ms.Get("test1")
+		stored, err := ms.Get(ctx, "test1")
 		if err != nil {
 			t.Errorf("Failed to get created metadata: %v", err)
 		}
@@ -49,7 +55,8 @@ func testCreateMetadata(ms *MetadataStore) func(*testing.T) {
 
 func testGetMetadata(ms *MetadataStore) func(*testing.T) {
 	return func(t *testing.T) {
-		metadata, err := ms.Get("test1")
+		ctx := t.Context()
+		metadata, err := ms.Get(ctx, "test1")
 		if err != nil {
 			t.Errorf("Failed to get metadata: %v", err)
 		}
@@ -61,19 +68,20 @@ func testGetMetadata(ms *MetadataStore) func(*testing.T) {
 
 func testUpdateMetadata(ms *MetadataStore) func(*testing.T) {
 	return func(t *testing.T) {
+		ctx := t.Context()
 		metadata := &Metadata{
 			ObjectID:  "test1",
 			LocalPath: "updated/path/to/test1",
 			UpdatedAt: time.Now(),
 		}
 
-		err := ms.Update(metadata)
+		err := ms.Update(ctx, metadata)
 		if err != nil {
 			t.Errorf("Failed to update metadata: %v", err)
 		}
 
 		// Verify update
-		updated, err := ms.Get("test1")
+		updated, err := ms.Get(ctx, "test1")
 		if err != nil {
 			t.Errorf("Failed to get updated metadata: %v", err)
 		}
@@ -85,15 +93,182 @@ func testUpdateMetadata(ms *MetadataStore) func(*testing.T) {
 
 func testDeleteMetadata(ms *MetadataStore) func(*testing.T) {
 	return func(t *testing.T) {
-		err := ms.Delete("test1")
+		ctx := t.Context()
+		err := ms.Delete(ctx, "test1")
 		if err != nil {
 			t.Errorf("Failed to delete metadata: %v", err)
 		}
 
 		// Verify deletion
-		_, err = ms.Get("test1")
+		_, err = ms.Get(ctx, "test1")
 		if err == nil {
 			t.Errorf("Metadata still exists after deletion")
 		}
 	}
 }
+
+func TestMigrateLegacySchema(t *testing.T) {
+	ctx := t.Context()
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE metadata (
+			object_id TEXT PRIMARY KEY,
+			object_path TEXT UNIQUE,
+			local_path TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		INSERT INTO metadata VALUES ('legacy', 'old/object.txt', 'storage/legacy', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := NewMetadataStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer ms.Close()
+
+	metadata, err := ms.GetByObjectPath(ctx, DefaultBucket, "old/object.txt")
+	if err != nil {
+		t.Fatalf("Failed to get legacy metadata: %v", err)
+	}
+	if metadata.VersionID != "null" {
+		t.Errorf("Expected legacy version ID %q, got %q", "null", metadata.VersionID)
+	}
+
+	versions, err := ms.ListVersions(ctx, DefaultBucket, "old/object.txt")
+	if err != nil {
+		t.Fatalf("Failed to list legacy versions: %v", err)
+	}
+	if len(versions) != 1 || versions[0].LocalPath != "storage/legacy" {
+		t.Errorf("Expected a single backfilled version, got %+v", versions)
+	}
+}
+
+func TestListMetadata(t *testing.T) {
+	ctx := t.Context()
+	ms, err := NewMetadataStore(filepath.Join(t.TempDir(), "list.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	paths := []string{
+		"documents/2025/a.txt",
+		"documents/2026/01/b.txt",
+		"documents/2026/02/c.txt",
+		"documents/2026/d.txt",
+		"documents/readme.md",
+		"images/logo.png",
+	}
+	for i, path := range paths {
+		err := ms.Create(ctx, &Metadata{ObjectID: fmt.Sprint(i), Bucket: DefaultBucket, ObjectPath: path, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := ms.List(ctx, ListOptions{Bucket: DefaultBucket, Prefix: "documents/", Delimiter: "/"})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].ObjectPath != "documents/readme.md" {
+		t.Errorf("Unexpected objects: %+v", result.Objects)
+	}
+	if strings.Join(result.CommonPrefixes, ",") != "documents/2025/,documents/2026/" {
+		t.Errorf("Unexpected common prefixes: %v", result.CommonPrefixes)
+	}
+	if result.IsTruncated {
+		t.Error("Listing should not be truncated")
+	}
+
+	var listed []string
+	opts := ListOptions{Bucket: DefaultBucket, Prefix: "documents/2026/", Delimiter: "/", Limit: 1}
+	for {
+		page, err := ms.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List page failed: %v", err)
+		}
+		for _, m := range page.Objects {
+			listed = append(listed, m.ObjectPath)
+		}
+		listed = append(listed, page.CommonPrefixes...)
+		if !page.IsTruncated {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	expected := "documents/2026/01/,documents/2026/02/,documents/2026/d.txt"
+	if strings.Join(listed, ",") != expected {
+		t.Errorf("Expected paginated listing %s, got %v", expected, listed)
+	}
+
+	if _, err := ms.List(ctx, ListOptions{Cursor: "!!"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestMigrateBucketPaths(t *testing.T) {
+	ctx := t.Context()
+	dbPath := filepath.Join(t.TempDir(), "prebucket.db")
+
+	// Build the schema as it was before objects had a bucket column, with
+	// one object stored by the S3 API as "bucket/key".
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range migrations[:6] {
+		if _, err := db.Exec(migration); err != nil {
+			t.Fatalf("Failed to apply migration %d: %v", i+1, err)
+		}
+	}
+	_, err = db.Exec(`
+		PRAGMA user_version = 6;
+		INSERT INTO buckets VALUES ('photos', CURRENT_TIMESTAMP);
+		INSERT INTO metadata VALUES ('a', 'photos/cat.jpg', 'storage/a', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 3, 'v1');
+		INSERT INTO metadata VALUES ('b', 'notes/todo.txt', 'storage/b', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 4, 'v1');
+		INSERT INTO versions VALUES ('photos/cat.jpg', 'v1', 'storage/a', 3, CURRENT_TIMESTAMP);
+		INSERT INTO versions VALUES ('notes/todo.txt', 'v1', 'storage/b', 4, CURRENT_TIMESTAMP);
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := NewMetadataStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	defer ms.Close()
+
+	photo, err := ms.GetByObjectPath(ctx, "photos", "cat.jpg")
+	if err != nil {
+		t.Fatalf("S3 object was not moved into its bucket: %v", err)
+	}
+	if photo.ObjectID != "a" {
+		t.Errorf("Unexpected object %+v", photo)
+	}
+	if _, err := ms.GetVersion(ctx, "photos", "cat.jpg", "v1"); err != nil {
+		t.Errorf("S3 object version was not moved into its bucket: %v", err)
+	}
+
+	note, err := ms.GetByObjectPath(ctx, DefaultBucket, "notes/todo.txt")
+	if err != nil {
+		t.Fatalf("Plain object was not moved into the default bucket: %v", err)
+	}
+	if note.ObjectID != "b" {
+		t.Errorf("Unexpected object %+v", note)
+	}
+	if _, err := ms.GetBucket(ctx, DefaultBucket); err != nil {
+		t.Errorf("Default bucket was not created: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Store operation phases. The file phase covers staging, writing, opening
+// and releasing blobs; the metadata phase covers the database lookups and
+// the transactions recording the result.
+const (
+	phaseFile     = "file"
+	phaseMetadata = "metadata"
+)
+
+// storeMetrics holds the metrics of store operations. It is shared by the
+// views of a store.
+type storeMetrics struct {
+	operationDuration *prometheus.HistogramVec
+}
+
+func newStoreMetrics() *storeMetrics {
+	return &storeMetrics{
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "objectstore_store_operation_duration_seconds",
+			Help:    "Time store operations spent in each phase.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "phase"}),
+	}
+}
+
+// Collectors returns the collectors of the store's metrics: the time object
+// operations spend on files and on metadata, the number and size of the
+// objects in each bucket, the bytes of stored blobs and the statistics of
+// the database connection pool.
+func (s *Store) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		s.metrics.operationDuration,
+		newStoreCollector(s.MetadataStore),
+		collectors.NewDBStatsCollector(s.MetadataStore.db, "metadata"),
+	}
+}
+
+// opTimer times the phases of a store operation. It starts in the metadata
+// phase.
+type opTimer struct {
+	metrics *storeMetrics
+	op      string
+	phase   string
+	start   time.Time
+	elapsed map[string]time.Duration
+}
+
+func (s *Store) startOp(op string) *opTimer {
+	return &opTimer{
+		metrics: s.metrics,
+		op:      op,
+		phase:   phaseMetadata,
+		start:   time.Now(),
+		elapsed: make(map[string]time.Duration, 2),
+	}
+}
+
+// enter ends the current phase and starts phase.
+func (t *opTimer) enter(phase string) {
+	now := time.Now()
+	t.elapsed[t.phase] += now.Sub(t.start)
+	t.phase, t.start = phase, now
+}
+
+// done ends the operation, observing the time spent in each phase.
+func (t *opTimer) done() {
+	t.enter("")
+	for phase, d := range t.elapsed {
+		if phase != "" {
+			t.metrics.operationDuration.WithLabelValues(t.op, phase).Observe(d.Seconds())
+		}
+	}
+}
+
+// storeCollector collects the object and blob totals from the database
+// when scraped.
+type storeCollector struct {
+	ms        *MetadataStore
+	objects   *prometheus.Desc
+	bytes     *prometheus.Desc
+	blobBytes *prometheus.Desc
+}
+
+func newStoreCollector(ms *MetadataStore) *storeCollector {
+	return &storeCollector{
+		ms:        ms,
+		objects:   prometheus.NewDesc("objectstore_objects", "Number of objects in each bucket.", []string{"bucket"}, nil),
+		bytes:     prometheus.NewDesc("objectstore_object_bytes", "Size of the current versions of the objects in each bucket.", []string{"bucket"}, nil),
+		blobBytes: prometheus.NewDesc("objectstore_blob_bytes", "Size of the distinct blobs stored, across all versions and buckets.", nil, nil),
+	}
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.objects
+	ch <- c.bytes
+	ch <- c.blobBytes
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	stats, err := c.ms.ObjectStats(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.objects, err)
+	}
+	for bucket, st := range stats {
+		ch <- prometheus.MustNewConstMetric(c.objects, prometheus.GaugeValue, float64(st.Objects), bucket)
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(st.Bytes), bucket)
+	}
+
+	blobBytes, err := c.ms.BlobBytes(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.blobBytes, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.blobBytes, prometheus.GaugeValue, float64(blobBytes))
+}
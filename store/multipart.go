@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// MinPartNumber and MaxPartNumber bound the part numbers of a multipart
+	// upload.
+	MinPartNumber = 1
+	MaxPartNumber = 10000
+)
+
+var (
+	// ErrUploadNotFound is returned when no multipart upload matches an ID.
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrInvalidPart is returned when a part number is out of range or a
+	// part listed on completion was not uploaded as described.
+	ErrInvalidPart = errors.New("invalid part")
+)
+
+// CompletedPart identifies a part to assemble when completing a multipart
+// upload. Hash, if set, must match the SHA-256 of the uploaded part.
+type CompletedPart struct {
+	PartNumber int
+	Hash       string
+}
+
+// InitiateUpload starts a multipart upload of an object at objectPath. The
+// headers in opts are stored with the object once the upload completes.
+func (s *Store) InitiateUpload(ctx context.Context, objectPath string, opts WriteOptions) (*Upload, error) {
+	bucket, err := s.GetBucket(ctx, s.BucketName())
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{
+		UploadID:   newID(),
+		Bucket:     bucket.Name,
+		ObjectPath: objectPath,
+		CreatedAt:  time.Now(),
+		Headers:    opts.Headers,
+	}
+	if err := s.MetadataStore.CreateUpload(ctx, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetUpload returns a multipart upload in progress.
+func (s *Store) GetUpload(ctx context.Context, uploadID string) (*Upload, error) {
+	upload, err := s.MetadataStore.GetUpload(ctx, uploadID)
+	if errors.Is(err, ErrMetadataNotFound) || (err == nil && upload.Bucket != s.BucketName()) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+	}
+	return upload, err
+}
+
+// UploadPart stores a part of a multipart upload, streaming its content from
+// r. Uploading a part number again replaces the earlier part.
+func (s *Store) UploadPart(ctx context.Context, uploadID string, partNumber int, r io.Reader) (*Part, error) {
+	if partNumber < MinPartNumber || partNumber > MaxPartNumber {
+		return nil, fmt.Errorf("%w: part number %d is not between %d and %d", ErrInvalidPart, partNumber, MinPartNumber, MaxPartNumber)
+	}
+	if _, err := s.GetUpload(ctx, uploadID); err != nil {
+		return nil, err
+	}
+
+	tmp, err := s.FileStorage.WriteTemp(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create part: %w", err)
+	}
+	if err := s.FileStorage.CommitPart(tmp, uploadID, partNumber); err != nil {
+		s.FileStorage.Discard(tmp)
+		return nil, err
+	}
+
+	part := &Part{
+		UploadID:   uploadID,
+		PartNumber: partNumber,
+		Hash:       tmp.Hash,
+		Size:       tmp.Size,
+		CreatedAt:  time.Now(),
+	}
+	err = s.MetadataStore.PutPart(ctx, part)
+	if errors.Is(err, ErrMetadataNotFound) {
+		// The upload was aborted while the part was being written.
+		s.FileStorage.DeleteUpload(uploadID)
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// ListParts returns the parts uploaded so far, ordered by part number.
+func (s *Store) ListParts(ctx context.Context, uploadID string) ([]*Part, error) {
+	if _, err := s.GetUpload(ctx, uploadID); err != nil {
+		return nil, err
+	}
+	return s.MetadataStore.ListParts(ctx, uploadID)
+}
+
+// CompleteUpload assembles the listed parts, in ascending part number order,
+// into the upload's object, creating it or replacing its content. If parts is
+// empty, every uploaded part is used. It returns the object's metadata and
+// the composite checksum of the parts: the SHA-256 of their concatenated
+// SHA-256 digests, suffixed with the number of parts.
+func (s *Store) CompleteUpload(ctx context.Context, uploadID string, parts []CompletedPart) (*Metadata, string, error) {
+	upload, err := s.GetUpload(ctx, uploadID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uploaded, err := s.MetadataStore.ListParts(ctx, uploadID)
+	if err != nil {
+		return nil, "", err
+	}
+	selected, err := selectParts(uploaded, parts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	composite := sha256.New()
+	for _, part := range selected {
+		digest, err := hex.DecodeString(part.Hash)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode part hash: %w", err)
+		}
+		composite.Write(digest)
+	}
+	checksum := fmt.Sprintf("%s-%d", hashString(composite), len(selected))
+
+	r := &partsReader{storage: s.FileStorage, parts: selected}
+	defer r.Close()
+	bucket := s.InBucket(upload.Bucket)
+	objectID, err := bucket.PutObjectWith(ctx, upload.ObjectPath, r, WriteOptions{Headers: upload.Headers})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.deleteUpload(ctx, uploadID); err != nil {
+		return nil, "", err
+	}
+
+	metadata, err := bucket.StatObject(ctx, objectID)
+	if err != nil {
+		return nil, "", err
+	}
+	return metadata, checksum, nil
+}
+
+// AbortUpload discards a multipart upload and its parts.
+func (s *Store) AbortUpload(ctx context.Context, uploadID string) error {
+	if _, err := s.GetUpload(ctx, uploadID); err != nil {
+		return err
+	}
+	return s.deleteUpload(ctx, uploadID)
+}
+
+// ReapStaleUploads aborts the multipart uploads in any bucket that were
+// initiated longer ago than the configured upload expiry. It returns the
+// number of uploads aborted.
+func (s *Store) ReapStaleUploads(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-s.FileStorage.config.UploadExpiry())
+	uploads, err := s.MetadataStore.ListUploadsBefore(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, upload := range uploads {
+		if err := s.deleteUpload(ctx, upload.UploadID); err != nil {
+			return i, err
+		}
+	}
+	return len(uploads), nil
+}
+
+// deleteUpload removes the records of an upload before its parts, so that a
+// part written concurrently is refused rather than left behind.
+func (s *Store) deleteUpload(ctx context.Context, uploadID string) error {
+	if err := s.MetadataStore.DeleteUpload(ctx, uploadID); err != nil {
+		return err
+	}
+	return s.FileStorage.DeleteUpload(uploadID)
+}
+
+// selectParts returns the uploaded parts named in parts, checking that they
+// are listed in ascending order and match what was uploaded.
+func selectParts(uploaded []*Part, parts []CompletedPart) ([]*Part, error) {
+	if len(parts) == 0 {
+		if len(uploaded) == 0 {
+			return nil, fmt.Errorf("%w: no parts were uploaded", ErrInvalidPart)
+		}
+		return uploaded, nil
+	}
+
+	byNumber := make(map[int]*Part, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+
+	selected := make([]*Part, 0, len(parts))
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return nil, fmt.Errorf("%w: parts must be listed in ascending order", ErrInvalidPart)
+		}
+		part, ok := byNumber[p.PartNumber]
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d was not uploaded", ErrInvalidPart, p.PartNumber)
+		}
+		if p.Hash != "" && p.Hash != part.Hash {
+			return nil, fmt.Errorf("%w: part %d does not match its hash", ErrInvalidPart, p.PartNumber)
+		}
+		selected = append(selected, part)
+	}
+	return selected, nil
+}
+
+// partsReader reads the content of parts one after another, opening each
+// part only once the previous one has been read.
+type partsReader struct {
+	storage *FileStorage
+	parts   []*Part
+	current io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.storage.OpenPart(r.parts[0].UploadID, r.parts[0].PartNumber)
+			if err != nil {
+				return 0, err
+			}
+			r.current = rc
+			r.parts = r.parts[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	upload, err := s.InitiateUpload(ctx, "releases/app.tar", WriteOptions{Headers: Headers{ContentType: "application/x-tar"}})
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	parts := map[int]string{1: "first-", 2: "second-", 3: "third"}
+	for _, n := range []int{3, 1, 2} {
+		if _, err := s.UploadPart(ctx, upload.UploadID, n, bytes.NewReader([]byte(parts[n]))); err != nil {
+			t.Fatalf("Failed to upload part %d: %v", n, err)
+		}
+	}
+	// Uploading a part again replaces it.
+	part, err := s.UploadPart(ctx, upload.UploadID, 2, bytes.NewReader([]byte("SECOND-")))
+	if err != nil {
+		t.Fatalf("Failed to replace part: %v", err)
+	}
+	if _, err := s.UploadPart(ctx, upload.UploadID, 0, bytes.NewReader(nil)); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("Expected ErrInvalidPart for part 0, got %v", err)
+	}
+
+	listed, err := s.ListParts(ctx, upload.UploadID)
+	if err != nil {
+		t.Fatalf("Failed to list parts: %v", err)
+	}
+	if len(listed) != 3 || listed[0].PartNumber != 1 || listed[1].Hash != part.Hash {
+		t.Errorf("Unexpected parts: %+v", listed)
+	}
+
+	_, _, err = s.CompleteUpload(ctx, upload.UploadID, []CompletedPart{{PartNumber: 2}, {PartNumber: 1}})
+	if !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("Expected ErrInvalidPart for parts out of order, got %v", err)
+	}
+	_, _, err = s.CompleteUpload(ctx, upload.UploadID, []CompletedPart{{PartNumber: 1}, {PartNumber: 2, Hash: listed[0].Hash}})
+	if !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("Expected ErrInvalidPart for a mismatched hash, got %v", err)
+	}
+
+	metadata, checksum, err := s.CompleteUpload(ctx, upload.UploadID, []CompletedPart{{PartNumber: 1}, {PartNumber: 2, Hash: part.Hash}, {PartNumber: 3}})
+	if err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+
+	composite := sha256.New()
+	for _, p := range listed {
+		digest, _ := hex.DecodeString(p.Hash)
+		composite.Write(digest)
+	}
+	if want := hex.EncodeToString(composite.Sum(nil)) + "-3"; checksum != want {
+		t.Errorf("Expected composite checksum %s, got %s", want, checksum)
+	}
+	if metadata.ContentType != "application/x-tar" {
+		t.Errorf("Expected upload headers on the object, got %+v", metadata.Headers)
+	}
+	data, err := s.ReadObject(ctx, "releases/app.tar")
+	if err != nil {
+		t.Fatalf("Failed to read assembled object: %v", err)
+	}
+	if string(data) != "first-SECOND-third" {
+		t.Errorf("Expected assembled content %q, got %q", "first-SECOND-third", data)
+	}
+
+	if _, err := s.GetUpload(ctx, upload.UploadID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected completed upload to be gone, got %v", err)
+	}
+	if _, err := os.Stat(s.FileStorage.uploadDir(upload.UploadID)); !os.IsNotExist(err) {
+		t.Errorf("Expected parts to be deleted after completion, got %v", err)
+	}
+}
+
+func TestAbortAndReapUploads(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	aborted, err := s.InitiateUpload(ctx, "a", WriteOptions{})
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+	if _, err := s.UploadPart(ctx, aborted.UploadID, 1, bytes.NewReader([]byte("part"))); err != nil {
+		t.Fatalf("Failed to upload part: %v", err)
+	}
+	if err := s.AbortUpload(ctx, aborted.UploadID); err != nil {
+		t.Fatalf("Failed to abort upload: %v", err)
+	}
+	if _, err := s.UploadPart(ctx, aborted.UploadID, 2, bytes.NewReader([]byte("late"))); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected ErrUploadNotFound after abort, got %v", err)
+	}
+
+	stale := &Upload{UploadID: "stale", Bucket: DefaultBucket, ObjectPath: "b", CreatedAt: time.Now().Add(-DefaultUploadExpiry - time.Hour)}
+	if err := s.MetadataStore.CreateUpload(ctx, stale); err != nil {
+		t.Fatalf("Failed to create stale upload: %v", err)
+	}
+	if _, err := s.UploadPart(ctx, stale.UploadID, 1, bytes.NewReader([]byte("part"))); err != nil {
+		t.Fatalf("Failed to upload part: %v", err)
+	}
+	fresh, err := s.InitiateUpload(ctx, "c", WriteOptions{})
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	n, err := s.ReapStaleUploads(ctx)
+	if err != nil {
+		t.Fatalf("Failed to reap uploads: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 stale upload to be reaped, got %d", n)
+	}
+	if _, err := s.GetUpload(ctx, fresh.UploadID); err != nil {
+		t.Errorf("Fresh upload was reaped: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.FileStorage.config.StorageDirectory, uploadsDirName))
+	if err != nil {
+		t.Fatalf("Failed to read uploads directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no upload directories left, found %d", len(entries))
+	}
+}
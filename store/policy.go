@@ -0,0 +1,261 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrPolicyNotFound is returned when no policy is attached to a key or
+	// bucket.
+	ErrPolicyNotFound = errors.New("policy not found")
+	// ErrInvalidPolicy is returned for policies with unknown effects or
+	// actions, or statements that match no path.
+	ErrInvalidPolicy = errors.New("invalid policy")
+)
+
+// Actions a policy statement grants or denies on object paths.
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+	// ActionList applies to listings, whose path is the listed prefix.
+	ActionList = "list"
+)
+
+// Statement effects.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Kinds of subject a policy is attached to.
+const (
+	PolicyKey    = "key"
+	PolicyBucket = "bucket"
+)
+
+// Policy grants or denies actions on object paths. A key's policy applies to
+// the requests signed with it; a bucket's policy applies to the requests on
+// its objects.
+type Policy struct {
+	Statements []Statement `json:"statements"`
+}
+
+// Statement is a rule of a policy. It matches a request when the request's
+// action and path match, and for key policies its bucket, and for bucket
+// policies its key.
+type Statement struct {
+	// Effect is EffectAllow or EffectDeny.
+	Effect string `json:"effect"`
+	// Actions are the actions the statement applies to; "*" is every action.
+	Actions []string `json:"actions"`
+	// Paths are glob patterns over object paths: "*" matches any run of
+	// characters other than "/", "**" any run of characters, and "?" a
+	// single character other than "/".
+	Paths []string `json:"paths"`
+	// Buckets are glob patterns limiting a key policy's statement to some
+	// buckets. Empty means every bucket. Bucket policies ignore it.
+	Buckets []string `json:"buckets,omitempty"`
+	// Principals limits a bucket policy's statement to some access key IDs.
+	// Empty or "*" means every key. Key policies ignore it.
+	Principals []string `json:"principals,omitempty"`
+}
+
+// AccessRequest describes a request to authorize.
+type AccessRequest struct {
+	AccessKeyID string
+	// Admin is set for admin keys, which are allowed whatever no statement
+	// denies.
+	Admin  bool
+	Bucket string
+	Path   string
+	Action string
+}
+
+// Decision is the outcome of authorizing a request.
+type Decision struct {
+	Allowed bool
+	// Rule is the statement that decided, or nil if no statement matched.
+	Rule *MatchedRule
+	// Reason explains the decision.
+	Reason string
+}
+
+// MatchedRule identifies a policy statement.
+type MatchedRule struct {
+	// Kind and Subject identify the policy: PolicyKey and an access key ID,
+	// or PolicyBucket and a bucket name.
+	Kind    string
+	Subject string
+	// Index is the position of the statement in the policy.
+	Index     int
+	Statement Statement
+}
+
+// PutPolicy attaches policy to an API key or a bucket, replacing any policy
+// it had.
+func (s *Store) PutPolicy(ctx context.Context, kind, subject string, policy *Policy) error {
+	if err := s.checkPolicySubject(ctx, kind, subject); err != nil {
+		return err
+	}
+	if err := validatePolicy(policy); err != nil {
+		return err
+	}
+	return s.MetadataStore.PutPolicy(ctx, kind, subject, policy)
+}
+
+// GetPolicy returns the policy attached to an API key or a bucket.
+func (s *Store) GetPolicy(ctx context.Context, kind, subject string) (*Policy, error) {
+	if err := s.checkPolicySubject(ctx, kind, subject); err != nil {
+		return nil, err
+	}
+	policy, err := s.MetadataStore.GetPolicy(ctx, kind, subject)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return nil, fmt.Errorf("%w: %s %s", ErrPolicyNotFound, kind, subject)
+	}
+	return policy, err
+}
+
+// DeletePolicy detaches the policy of an API key or a bucket.
+func (s *Store) DeletePolicy(ctx context.Context, kind, subject string) error {
+	if err := s.checkPolicySubject(ctx, kind, subject); err != nil {
+		return err
+	}
+	err := s.MetadataStore.DeletePolicy(ctx, kind, subject)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return fmt.Errorf("%w: %s %s", ErrPolicyNotFound, kind, subject)
+	}
+	return err
+}
+
+func (s *Store) checkPolicySubject(ctx context.Context, kind, subject string) error {
+	switch kind {
+	case PolicyKey:
+		_, err := s.GetAPIKey(ctx, subject)
+		return err
+	case PolicyBucket:
+		_, err := s.GetBucket(ctx, subject)
+		return err
+	}
+	return fmt.Errorf("%w: unknown policy kind %q", ErrInvalidPolicy, kind)
+}
+
+func validatePolicy(policy *Policy) error {
+	for i, st := range policy.Statements {
+		if st.Effect != EffectAllow && st.Effect != EffectDeny {
+			return fmt.Errorf("%w: statement %d has unknown effect %q", ErrInvalidPolicy, i, st.Effect)
+		}
+		if len(st.Actions) == 0 || len(st.Paths) == 0 {
+			return fmt.Errorf("%w: statement %d needs actions and paths", ErrInvalidPolicy, i)
+		}
+		for _, action := range st.Actions {
+			switch action {
+			case "*", ActionRead, ActionWrite, ActionDelete, ActionList:
+			default:
+				return fmt.Errorf("%w: statement %d has unknown action %q", ErrInvalidPolicy, i, action)
+			}
+		}
+	}
+	return nil
+}
+
+// Authorize decides whether req is allowed by the policies of its key and
+// bucket. A statement that denies the request overrides any that allows it.
+// A request no statement matches is denied, unless its key is an admin key.
+func (s *Store) Authorize(ctx context.Context, req AccessRequest) (*Decision, error) {
+	var rules []*MatchedRule
+	for _, subject := range []struct{ kind, name string }{{PolicyKey, req.AccessKeyID}, {PolicyBucket, req.Bucket}} {
+		policy, err := s.MetadataStore.GetPolicy(ctx, subject.kind, subject.name)
+		if errors.Is(err, ErrMetadataNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, st := range policy.Statements {
+			if st.matches(subject.kind, req) {
+				rules = append(rules, &MatchedRule{Kind: subject.kind, Subject: subject.name, Index: i, Statement: st})
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.Statement.Effect == EffectDeny {
+			return &Decision{Rule: rule, Reason: "denied by " + rule.String()}, nil
+		}
+	}
+	if len(rules) > 0 {
+		return &Decision{Allowed: true, Rule: rules[0], Reason: "allowed by " + rules[0].String()}, nil
+	}
+	if req.Admin {
+		return &Decision{Allowed: true, Reason: "allowed as an admin key"}, nil
+	}
+	return &Decision{Reason: "no statement allows the request"}, nil
+}
+
+func (r *MatchedRule) String() string {
+	return fmt.Sprintf("statement %d of the policy of %s %s", r.Index, r.Kind, r.Subject)
+}
+
+func (st *Statement) matches(kind string, req AccessRequest) bool {
+	if !slices.Contains(st.Actions, "*") && !slices.Contains(st.Actions, req.Action) {
+		return false
+	}
+	if !matchAnyGlob(st.Paths, req.Path) {
+		return false
+	}
+	switch kind {
+	case PolicyKey:
+		return len(st.Buckets) == 0 || matchAnyGlob(st.Buckets, req.Bucket)
+	default:
+		return len(st.Principals) == 0 || slices.Contains(st.Principals, "*") || slices.Contains(st.Principals, req.AccessKeyID)
+	}
+}
+
+func matchAnyGlob(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		return matchGlob(pattern, s)
+	})
+}
+
+// matchGlob reports whether s matches pattern, as described for
+// Statement.Paths.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "**"):
+			rest := strings.TrimLeft(pattern, "*")
+			for i := len(s); i >= 0; i-- {
+				if matchGlob(rest, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case pattern[0] == '*':
+			rest := pattern[1:]
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(rest, s[i:]) {
+					return true
+				}
+				if i < len(s) && s[i] == '/' {
+					return false
+				}
+			}
+			return false
+		case len(s) == 0:
+			return false
+		case pattern[0] == '?':
+			if s[0] == '/' {
+				return false
+			}
+		case pattern[0] != s[0]:
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
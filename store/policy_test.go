@@ -0,0 +1,137 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"logs/*", "logs/a.txt", true},
+		{"logs/*", "logs/2024/a.txt", false},
+		{"logs/**", "logs/2024/a.txt", true},
+		{"logs/**", "logs/", true},
+		{"**/*.txt", "a/b/c.txt", true},
+		{"**/*.txt", "a/b/c.bin", false},
+		{"*.txt", "a.txt", true},
+		{"*.txt", "dir/a.txt", false},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"**", "", true},
+		{"*", "", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	key, _ := s.CreateAPIKey(ctx, false)
+	other, _ := s.CreateAPIKey(ctx, false)
+	admin, _ := s.CreateAPIKey(ctx, true)
+
+	err := s.PutPolicy(ctx, PolicyKey, key.AccessKeyID, &Policy{Statements: []Statement{
+		{Effect: EffectAllow, Actions: []string{"*"}, Paths: []string{"shared/**"}},
+		{Effect: EffectAllow, Actions: []string{ActionRead}, Paths: []string{"**"}, Buckets: []string{"archive-*"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.PutPolicy(ctx, PolicyBucket, DefaultBucket, &Policy{Statements: []Statement{
+		{Effect: EffectDeny, Actions: []string{ActionDelete, ActionWrite}, Paths: []string{"shared/locked/**"}},
+		{Effect: EffectAllow, Actions: []string{ActionRead}, Paths: []string{"public/*"}, Principals: []string{other.AccessKeyID}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		req    AccessRequest
+		want   bool
+		kind   string
+		index  int
+		reason string
+	}{
+		{"key allows", AccessRequest{AccessKeyID: key.AccessKeyID, Bucket: DefaultBucket, Path: "shared/a.txt", Action: ActionWrite}, true, PolicyKey, 0, ""},
+		{"bucket denial overrides", AccessRequest{AccessKeyID: key.AccessKeyID, Bucket: DefaultBucket, Path: "shared/locked/a.txt", Action: ActionDelete}, false, PolicyBucket, 0, ""},
+		{"no statement matches", AccessRequest{AccessKeyID: key.AccessKeyID, Bucket: DefaultBucket, Path: "private/a.txt", Action: ActionRead}, false, "", 0, "no statement allows the request"},
+		{"bucket glob", AccessRequest{AccessKeyID: key.AccessKeyID, Bucket: "archive-2024", Path: "x/y", Action: ActionRead}, true, PolicyKey, 1, ""},
+		{"principal allows", AccessRequest{AccessKeyID: other.AccessKeyID, Bucket: DefaultBucket, Path: "public/a.txt", Action: ActionRead}, true, PolicyBucket, 1, ""},
+		{"principal does not match", AccessRequest{AccessKeyID: key.AccessKeyID, Bucket: DefaultBucket, Path: "public/a.txt", Action: ActionRead}, false, "", 0, ""},
+		{"admin by default", AccessRequest{AccessKeyID: admin.AccessKeyID, Admin: true, Bucket: DefaultBucket, Path: "private/a.txt", Action: ActionDelete}, true, "", 0, "allowed as an admin key"},
+		{"admin denied", AccessRequest{AccessKeyID: admin.AccessKeyID, Admin: true, Bucket: DefaultBucket, Path: "shared/locked/a.txt", Action: ActionWrite}, false, PolicyBucket, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			decision, err := s.Authorize(ctx, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decision.Allowed != tt.want {
+				t.Errorf("Expected allowed %v, got %+v", tt.want, decision)
+			}
+			if tt.kind == "" {
+				if decision.Rule != nil {
+					t.Errorf("Expected no rule to decide, got %v", decision.Rule)
+				}
+			} else if decision.Rule == nil || decision.Rule.Kind != tt.kind || decision.Rule.Index != tt.index {
+				t.Errorf("Expected statement %d of the %s policy to decide, got %+v", tt.index, tt.kind, decision.Rule)
+			}
+			if tt.reason != "" && decision.Reason != tt.reason {
+				t.Errorf("Expected reason %q, got %q", tt.reason, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestPolicyValidation(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	key, _ := s.CreateAPIKey(ctx, false)
+
+	invalid := []*Policy{
+		{Statements: []Statement{{Effect: "maybe", Actions: []string{ActionRead}, Paths: []string{"*"}}}},
+		{Statements: []Statement{{Effect: EffectAllow, Actions: []string{"chmod"}, Paths: []string{"*"}}}},
+		{Statements: []Statement{{Effect: EffectAllow, Actions: []string{ActionRead}}}},
+	}
+	for _, policy := range invalid {
+		if err := s.PutPolicy(ctx, PolicyKey, key.AccessKeyID, policy); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("Expected ErrInvalidPolicy for %+v, got %v", policy, err)
+		}
+	}
+	if err := s.PutPolicy(ctx, PolicyBucket, "missing", &Policy{}); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if _, err := s.GetPolicy(ctx, PolicyKey, key.AccessKeyID); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("Expected ErrPolicyNotFound, got %v", err)
+	}
+
+	// A deleted bucket's policy does not carry over to a new bucket of the
+	// same name.
+	if err := s.CreateBucket(ctx, &Bucket{Name: "scratch"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutPolicy(ctx, PolicyBucket, "scratch", &Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBucket(ctx, "scratch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateBucket(ctx, &Bucket{Name: "scratch"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPolicy(ctx, PolicyBucket, "scratch"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("Expected the policy to be deleted with its bucket, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPresignDisabled is returned when presigned URLs are used but no
+	// secret is configured to sign them.
+	ErrPresignDisabled = errors.New("presigned URLs are not enabled")
+	// ErrInvalidPresign is returned when asked to presign a request that
+	// presigned URLs cannot allow.
+	ErrInvalidPresign = errors.New("invalid presigned request")
+	// ErrPresignExpired is returned when a presigned URL is used after it
+	// expired.
+	ErrPresignExpired = errors.New("presigned URL expired")
+	// ErrPresignMismatch is returned when a presigned URL's signature does
+	// not match the request it describes.
+	ErrPresignMismatch = errors.New("presigned URL signature does not match")
+)
+
+// DefaultPresignExpiry is how long a presigned URL is valid when no expiry
+// is asked for.
+const DefaultPresignExpiry = 15 * time.Minute
+
+// DefaultMaxPresignExpiry is the longest a presigned URL may be valid,
+// unless configured otherwise.
+const DefaultMaxPresignExpiry = 7 * 24 * time.Hour
+
+// PresignConfig configures presigned URLs.
+type PresignConfig struct {
+	// Secret signs presigned URLs. Presigned URLs are refused when it is
+	// empty; changing it invalidates every URL signed with the previous
+	// secret.
+	Secret string `json:"secret,omitempty"`
+	// MaxExpirySeconds is the longest a presigned URL may be valid. Zero
+	// means DefaultMaxPresignExpiry.
+	MaxExpirySeconds int `json:"max_expiry_seconds,omitempty"`
+}
+
+// MaxExpiry returns the longest a presigned URL may be valid.
+func (c PresignConfig) MaxExpiry() time.Duration {
+	if c.MaxExpirySeconds <= 0 {
+		return DefaultMaxPresignExpiry
+	}
+	return time.Duration(c.MaxExpirySeconds) * time.Second
+}
+
+// Presign describes the request a presigned URL allows without credentials.
+type Presign struct {
+	// Method is http.MethodGet, which also allows HEAD, or http.MethodPut.
+	Method string
+	Bucket string
+	// Path is the object path or ID the URL addresses.
+	Path    string
+	Expires time.Time
+	// ContentLength, if positive, is the only content length a PUT may
+	// send.
+	ContentLength int64
+	// ContentType, if set, is the only content type a PUT may send.
+	ContentType string
+}
+
+// SignPresign returns the signature of a presigned URL allowing p.
+func (s *Store) SignPresign(p Presign) (string, error) {
+	config := s.Config().Presign
+	if config.Secret == "" {
+		return "", ErrPresignDisabled
+	}
+	switch p.Method {
+	case http.MethodGet:
+		if p.ContentLength != 0 || p.ContentType != "" {
+			return "", fmt.Errorf("%w: content constraints only apply to PUT", ErrInvalidPresign)
+		}
+	case http.MethodPut:
+		if p.ContentLength < 0 {
+			return "", fmt.Errorf("%w: negative content length", ErrInvalidPresign)
+		}
+	default:
+		return "", fmt.Errorf("%w: method %s cannot be presigned", ErrInvalidPresign, p.Method)
+	}
+	if p.Path == "" {
+		return "", fmt.Errorf("%w: missing object path", ErrInvalidPresign)
+	}
+	if expiry := time.Until(p.Expires); expiry <= 0 || expiry > config.MaxExpiry() {
+		return "", fmt.Errorf("%w: expiry must be within %s", ErrInvalidPresign, config.MaxExpiry())
+	}
+	return presignSignature(config.Secret, p), nil
+}
+
+// VerifyPresign checks that signature was made by SignPresign for p, and
+// that p has not expired.
+func (s *Store) VerifyPresign(p Presign, signature string) error {
+	config := s.Config().Presign
+	if config.Secret == "" {
+		return ErrPresignDisabled
+	}
+	if p.Method == http.MethodHead {
+		p.Method = http.MethodGet
+	}
+	if !hmac.Equal([]byte(signature), []byte(presignSignature(config.Secret, p))) {
+		return ErrPresignMismatch
+	}
+	if time.Now().After(p.Expires) {
+		return fmt.Errorf("%w at %s", ErrPresignExpired, p.Expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func presignSignature(secret string, p Presign) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{
+		p.Method,
+		p.Bucket,
+		p.Path,
+		strconv.FormatInt(p.Expires.Unix(), 10),
+		strconv.FormatInt(p.ContentLength, 10),
+		p.ContentType,
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPresign(t *testing.T) {
+	s := newTestStoreWithConfig(t, Config{Presign: PresignConfig{Secret: "secret", MaxExpirySeconds: 3600}})
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	put := Presign{Method: http.MethodPut, Bucket: DefaultBucket, Path: "uploads/a.bin", Expires: expires, ContentLength: 5, ContentType: "application/octet-stream"}
+	signature, err := s.SignPresign(put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyPresign(put, signature); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+
+	tampered := []Presign{put, put, put, put}
+	tampered[0].Method = http.MethodGet
+	tampered[1].Path = "uploads/b.bin"
+	tampered[2].ContentLength = 6
+	tampered[3].Expires = expires.Add(time.Hour)
+	for _, p := range tampered {
+		if err := s.VerifyPresign(p, signature); !errors.Is(err, ErrPresignMismatch) {
+			t.Errorf("Expected ErrPresignMismatch for %+v, got %v", p, err)
+		}
+	}
+
+	// A GET signature also allows HEAD.
+	get := Presign{Method: http.MethodGet, Bucket: DefaultBucket, Path: "a.txt", Expires: expires}
+	signature, err = s.SignPresign(get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Method = http.MethodHead
+	if err := s.VerifyPresign(get, signature); err != nil {
+		t.Errorf("Expected a GET signature to allow HEAD, got %v", err)
+	}
+
+	expired := Presign{Method: http.MethodGet, Bucket: DefaultBucket, Path: "a.txt", Expires: time.Now().Add(-time.Second)}
+	if err := s.VerifyPresign(expired, presignSignature("secret", expired)); !errors.Is(err, ErrPresignExpired) {
+		t.Errorf("Expected ErrPresignExpired, got %v", err)
+	}
+
+	invalid := []Presign{
+		{Method: http.MethodDelete, Path: "a.txt", Expires: expires},
+		{Method: http.MethodGet, Path: "a.txt", Expires: expires, ContentType: "text/plain"},
+		{Method: http.MethodGet, Path: "", Expires: expires},
+		{Method: http.MethodGet, Path: "a.txt", Expires: time.Now().Add(2 * time.Hour)},
+		{Method: http.MethodGet, Path: "a.txt", Expires: time.Now().Add(-time.Minute)},
+	}
+	for _, p := range invalid {
+		if _, err := s.SignPresign(p); !errors.Is(err, ErrInvalidPresign) {
+			t.Errorf("Expected ErrInvalidPresign for %+v, got %v", p, err)
+		}
+	}
+
+	disabled := newTestStore(t)
+	if _, err := disabled.SignPresign(get); !errors.Is(err, ErrPresignDisabled) {
+		t.Errorf("Expected ErrPresignDisabled, got %v", err)
+	}
+}
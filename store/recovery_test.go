@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errCrash is the panic value faultyBackend uses to simulate the server
+// stopping mid-operation.
+var errCrash = errors.New("simulated crash")
+
+// faultyBackend wraps a Backend and crashes at a chosen point.
+type faultyBackend struct {
+	Backend
+	crashAfterPut     bool
+	crashBeforeDelete bool
+}
+
+func (b *faultyBackend) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := b.Backend.Put(ctx, name, r, size); err != nil {
+		return err
+	}
+	if b.crashAfterPut {
+		panic(errCrash)
+	}
+	return nil
+}
+
+func (b *faultyBackend) Delete(ctx context.Context, name string) error {
+	if b.crashBeforeDelete {
+		panic(errCrash)
+	}
+	return b.Backend.Delete(ctx, name)
+}
+
+// crash runs op, which is expected to be interrupted by faultyBackend.
+func crash(t *testing.T, op func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != errCrash {
+			t.Fatalf("Expected simulated crash, got %v", r)
+		}
+	}()
+	op()
+}
+
+// restart closes s, opens a new store on the same files and recovers it, as
+// the server does on startup.
+func restart(t *testing.T, s *Store) *Store {
+	ctx := t.Context()
+	t.Helper()
+	s.Close()
+	dir := filepath.Dir(s.FileStorage.config.StorageDirectory)
+	restarted, err := NewStore(filepath.Join(dir, "config.json"), filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	t.Cleanup(func() { restarted.Close() })
+	if err := restarted.Recover(ctx); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	return restarted
+}
+
+func listBlobs(t *testing.T, s *Store) []string {
+	ctx := t.Context()
+	t.Helper()
+	names, err := s.Backend.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func checkNoIntents(t *testing.T, s *Store) {
+	ctx := t.Context()
+	t.Helper()
+	intents, err := s.MetadataStore.ListIntents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 0 {
+		t.Errorf("Expected no intents after recovery, got %d", len(intents))
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	t.Run("CrashBeforeRecord", func(t *testing.T) {
+		ctx := t.Context()
+		s := newTestStore(t)
+		s.Backend = &faultyBackend{Backend: s.Backend, crashAfterPut: true}
+		crash(t, func() { s.CreateObject(ctx, "crash.txt", []byte("never recorded")) })
+
+		s = restart(t, s)
+		if blobs := listBlobs(t, s); len(blobs) != 0 {
+			t.Errorf("Expected the unrecorded blob to be deleted, got %v", blobs)
+		}
+		if _, err := s.StatObject(ctx, "crash.txt"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Expected ErrObjectNotFound, got %v", err)
+		}
+		checkNoIntents(t, s)
+	})
+
+	t.Run("CrashBeforeFileDelete", func(t *testing.T) {
+		ctx := t.Context()
+		s := newTestStore(t)
+		if _, err := s.CreateObject(ctx, "doomed.txt", []byte("delete me")); err != nil {
+			t.Fatal(err)
+		}
+		s.Backend = &faultyBackend{Backend: s.Backend, crashBeforeDelete: true}
+		crash(t, func() { s.DeleteObject(ctx, "doomed.txt") })
+
+		s = restart(t, s)
+		if blobs := listBlobs(t, s); len(blobs) != 0 {
+			t.Errorf("Expected the released blob to be deleted, got %v", blobs)
+		}
+		checkNoIntents(t, s)
+	})
+
+	t.Run("CrashAfterRecord", func(t *testing.T) {
+		ctx := t.Context()
+		s := newTestStore(t)
+		id, err := s.CreateObject(ctx, "kept.txt", []byte("committed"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata, err := s.StatObject(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The write committed but its intent was never cleared.
+		intent := &Intent{Kind: IntentPutBlob, BlobID: metadata.BlobID, CreatedAt: time.Now()}
+		if err := s.MetadataStore.CreateIntent(ctx, intent); err != nil {
+			t.Fatal(err)
+		}
+
+		s = restart(t, s)
+		data, err := s.ReadObject(ctx, "kept.txt")
+		if err != nil || string(data) != "committed" {
+			t.Errorf("Expected committed content to survive recovery, got %q, %v", data, err)
+		}
+		checkNoIntents(t, s)
+	})
+
+	t.Run("StagedUpload", func(t *testing.T) {
+		ctx := t.Context()
+		s := newTestStore(t)
+		tmp, err := s.FileStorage.WriteTemp(ctx, strings.NewReader("interrupted"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s = restart(t, s)
+		if _, err := os.Stat(tmp.path); !os.IsNotExist(err) {
+			t.Errorf("Expected staged file to be cleared, got %v", err)
+		}
+	})
+}
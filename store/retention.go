@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrObjectLocked is returned when an update or delete is refused
+	// because the object is under retention or legal hold.
+	ErrObjectLocked = errors.New("object is locked")
+	// ErrInvalidRetention is returned for retention with an unknown mode or
+	// a retain-until date in the past.
+	ErrInvalidRetention = errors.New("invalid retention")
+)
+
+// Retention modes.
+const (
+	// RetentionGovernance retention can be shortened or lifted, and the
+	// object changed, by writes that bypass governance.
+	RetentionGovernance = "governance"
+	// RetentionCompliance retention can only be extended, and nothing can
+	// change the object until it ends.
+	RetentionCompliance = "compliance"
+)
+
+// Retention keeps an object from being updated or deleted until
+// RetainUntil. The zero Retention retains nothing.
+type Retention struct {
+	// Mode is RetentionGovernance or RetentionCompliance, or empty for no
+	// retention.
+	Mode        string
+	RetainUntil time.Time
+}
+
+// Active reports whether the retention protects its object at now.
+func (r Retention) Active(now time.Time) bool {
+	return r.Mode != "" && now.Before(r.RetainUntil)
+}
+
+func validateRetention(r Retention, now time.Time) error {
+	switch r.Mode {
+	case "":
+		if !r.RetainUntil.IsZero() {
+			return fmt.Errorf("%w: a retain-until date needs a mode", ErrInvalidRetention)
+		}
+	case RetentionGovernance, RetentionCompliance:
+		if !r.RetainUntil.After(now) {
+			return fmt.Errorf("%w: the retain-until date must be in the future", ErrInvalidRetention)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidRetention, r.Mode)
+	}
+	return nil
+}
+
+// defaultRetention returns the retention the bucket applies to new objects,
+// which is none unless the bucket has a default retention period.
+func defaultRetention(bucket *Bucket, now time.Time) Retention {
+	if bucket.DefaultRetentionDays == 0 {
+		return Retention{}
+	}
+	mode := bucket.DefaultRetentionMode
+	if mode == "" {
+		mode = RetentionGovernance
+	}
+	return Retention{Mode: mode, RetainUntil: now.Add(days(bucket.DefaultRetentionDays)).Truncate(time.Second)}
+}
+
+// checkLock reports whether the legal hold or retention of an object refuse
+// changes to it at now.
+func checkLock(metadata *Metadata, now time.Time, bypassGovernance bool) error {
+	retention := metadata.Retention
+	switch {
+	case metadata.LegalHold:
+		return fmt.Errorf("%w: %s is under legal hold", ErrObjectLocked, metadata.ObjectPath)
+	case retention.Active(now) && (retention.Mode == RetentionCompliance || !bypassGovernance):
+		return fmt.Errorf("%w: %s is retained in %s mode until %s", ErrObjectLocked, metadata.ObjectPath, retention.Mode, retention.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SetRetention replaces the retention of an object and returns its
+// metadata. Compliance retention can only be extended; governance retention
+// can only be shortened or lifted if bypassGovernance is set.
+func (s *Store) SetRetention(ctx context.Context, objectIDOrPath string, retention Retention, bypassGovernance bool) (*Metadata, error) {
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := validateRetention(retention, now); err != nil {
+		return nil, err
+	}
+
+	if err := s.MetadataStore.SetRetention(ctx, metadata.ObjectID, retention, bypassGovernance); err != nil {
+		return nil, err
+	}
+	metadata.Retention = retention
+	return metadata, nil
+}
+
+// SetLegalHold places an object under legal hold, or releases it, and
+// returns its metadata. An object under legal hold cannot be updated or
+// deleted whatever its retention. Releasing a hold fails with
+// ErrObjectLocked unless mayRelease is set, which callers reserve for the
+// same privilege that bypasses governance retention.
+func (s *Store) SetLegalHold(ctx context.Context, objectIDOrPath string, hold, mayRelease bool) (*Metadata, error) {
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.MetadataStore.SetLegalHold(ctx, metadata.ObjectID, hold, mayRelease); err != nil {
+		return nil, err
+	}
+	metadata.LegalHold = hold
+	return metadata, nil
+}
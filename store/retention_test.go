@@ -0,0 +1,228 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	until := time.Now().Add(time.Hour)
+
+	governed, err := s.CreateObjectWith(ctx, "governed.txt", bytes.NewReader([]byte("v1")), WriteOptions{
+		Retention: Retention{Mode: RetentionGovernance, RetainUntil: until},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateObject(ctx, governed, []byte("v2")); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked updating, got %v", err)
+	}
+	if err := s.DeleteObject(ctx, governed); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked deleting, got %v", err)
+	}
+	if err := s.UpdateObjectWith(ctx, governed, bytes.NewReader([]byte("v2")), WriteOptions{BypassGovernance: true}); err != nil {
+		t.Errorf("Expected bypassing governance to allow the update, got %v", err)
+	}
+	metadata, err := s.StatObject(ctx, governed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Retention.Mode != RetentionGovernance || !metadata.Retention.RetainUntil.Equal(until) {
+		t.Errorf("Expected the update to keep the retention, got %+v", metadata.Retention)
+	}
+
+	compliant, err := s.CreateObjectWith(ctx, "compliant.txt", bytes.NewReader([]byte("v1")), WriteOptions{
+		Retention: Retention{Mode: RetentionCompliance, RetainUntil: until},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObjectWith(ctx, compliant, DeleteOptions{BypassGovernance: true}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected compliance retention to refuse a bypassing delete, got %v", err)
+	}
+	if _, err := s.SetRetention(ctx, compliant, Retention{Mode: RetentionCompliance, RetainUntil: until.Add(-time.Minute)}, true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected shortening compliance retention to fail, got %v", err)
+	}
+	if _, err := s.SetRetention(ctx, compliant, Retention{Mode: RetentionGovernance, RetainUntil: until.Add(time.Hour)}, true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected weakening compliance to governance to fail, got %v", err)
+	}
+	if _, err := s.SetRetention(ctx, compliant, Retention{Mode: RetentionCompliance, RetainUntil: until.Add(time.Hour)}, false); err != nil {
+		t.Errorf("Expected extending compliance retention to succeed, got %v", err)
+	}
+
+	if _, err := s.SetRetention(ctx, governed, Retention{}, false); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected lifting governance retention without bypass to fail, got %v", err)
+	}
+	if _, err := s.SetRetention(ctx, governed, Retention{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, governed); err != nil {
+		t.Errorf("Expected the delete to succeed once retention was lifted, got %v", err)
+	}
+
+	if _, err := s.SetRetention(ctx, compliant, Retention{Mode: "forever", RetainUntil: until}, false); !errors.Is(err, ErrInvalidRetention) {
+		t.Errorf("Expected ErrInvalidRetention for an unknown mode, got %v", err)
+	}
+	_, err = s.CreateObjectWith(ctx, "past.txt", bytes.NewReader(nil), WriteOptions{
+		Retention: Retention{Mode: RetentionGovernance, RetainUntil: time.Now().Add(-time.Hour)},
+	})
+	if !errors.Is(err, ErrInvalidRetention) {
+		t.Errorf("Expected ErrInvalidRetention for a past date, got %v", err)
+	}
+
+	// The database refuses changes to a locked object even when the object
+	// was read before it was locked.
+	stale, err := s.CreateObject(ctx, "stale.txt", []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetLegalHold(ctx, stale, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MetadataStore.DeleteIfVersion(ctx, stale, "", true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected the database to refuse deleting a held object, got %v", err)
+	}
+}
+
+func TestLegalHold(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	objectID, err := s.CreateObjectWith(ctx, "held.txt", bytes.NewReader([]byte("v1")), WriteOptions{LegalHold: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UpdateObjectWith(ctx, objectID, bytes.NewReader([]byte("v2")), WriteOptions{BypassGovernance: true}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected legal hold to refuse a bypassing update, got %v", err)
+	}
+	if err := s.DeleteObjectWith(ctx, objectID, DeleteOptions{BypassGovernance: true}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected legal hold to refuse a bypassing delete, got %v", err)
+	}
+
+	if _, err := s.SetLegalHold(ctx, objectID, false, false); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected releasing the hold without the privilege to fail, got %v", err)
+	}
+	metadata, err := s.SetLegalHold(ctx, objectID, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.LegalHold {
+		t.Error("Expected the legal hold to be released")
+	}
+	if err := s.DeleteObject(ctx, objectID); err != nil {
+		t.Errorf("Expected the delete to succeed once released, got %v", err)
+	}
+	if _, err := s.SetLegalHold(ctx, "missing.txt", true, false); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestDefaultRetention(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	err := s.CreateBucket(ctx, &Bucket{Name: "vault", DefaultRetentionDays: 30, DefaultRetentionMode: RetentionCompliance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := s.InBucket("vault")
+
+	objectID, err := vault.CreateObject(ctx, "record.txt", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := vault.StatObject(ctx, objectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Retention.Mode != RetentionCompliance || time.Until(metadata.Retention.RetainUntil) < 29*24*time.Hour {
+		t.Errorf("Expected 30 days of compliance retention, got %+v", metadata.Retention)
+	}
+	if err := vault.DeleteObject(ctx, objectID); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked, got %v", err)
+	}
+
+	// An explicit retention overrides the default.
+	until := time.Now().Add(time.Hour)
+	objectID, err = vault.CreateObjectWith(ctx, "short.txt", bytes.NewReader(nil), WriteOptions{
+		Retention: Retention{Mode: RetentionGovernance, RetainUntil: until},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ := vault.StatObject(ctx, objectID); metadata.Retention.Mode != RetentionGovernance {
+		t.Errorf("Expected governance retention, got %+v", metadata.Retention)
+	}
+
+	if err := s.CreateBucket(ctx, &Bucket{Name: "bad-mode", DefaultRetentionMode: "forever"}); !errors.Is(err, ErrInvalidBucketSettings) {
+		t.Errorf("Expected ErrInvalidBucketSettings, got %v", err)
+	}
+	bucket, err := s.GetBucket(ctx, "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket.DefaultRetentionMode != RetentionCompliance {
+		t.Errorf("Expected the default retention mode to be stored, got %q", bucket.DefaultRetentionMode)
+	}
+
+	// Lifecycle expiry leaves locked objects alone.
+	bucket.Lifecycle = []LifecycleRule{{ExpireDays: 1}}
+	if err := s.UpdateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.ApplyLifecycle(ctx, LifecycleOptions{Now: time.Now().Add(2 * 24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range report.Actions {
+		if action.ObjectPath == "record.txt" {
+			t.Errorf("Expected lifecycle to skip the locked object, got %+v", action)
+		}
+	}
+}
+
+func TestRetentionCheckedByUpdate(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	until := time.Now().Add(time.Hour)
+	compliant, err := s.CreateObjectWith(ctx, "compliant.txt", bytes.NewReader([]byte("v1")), WriteOptions{
+		Retention: Retention{Mode: RetentionCompliance, RetainUntil: until},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	governed, err := s.CreateObjectWith(ctx, "governed.txt", bytes.NewReader([]byte("v1")), WriteOptions{
+		Retention: Retention{Mode: RetentionGovernance, RetainUntil: until},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetRetention(ctx, compliant, Retention{Mode: RetentionCompliance, RetainUntil: until.Add(time.Hour)}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// An extension checked against the retention before the one above
+	// committed must not shorten it.
+	err = s.MetadataStore.SetRetention(ctx, compliant, Retention{Mode: RetentionCompliance, RetainUntil: until.Add(time.Minute)}, false)
+	if !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked shortening compliance retention, got %v", err)
+	}
+	metadata, err := s.StatObject(ctx, compliant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.Retention.RetainUntil.Equal(until.Add(time.Hour)) {
+		t.Errorf("Expected the extended retention to remain, got %+v", metadata.Retention)
+	}
+
+	err = s.MetadataStore.SetRetention(ctx, governed, Retention{}, false)
+	if !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked lifting governance retention without bypass, got %v", err)
+	}
+	if err := s.MetadataStore.SetRetention(ctx, governed, Retention{}, true); err != nil {
+		t.Errorf("Expected bypassing governance to lift the retention, got %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// S3Config configures the S3 backend.
+type S3Config struct {
+	// Endpoint is the base URL of the S3-compatible service. Empty means
+	// AWS itself.
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	// Prefix is prepended to every blob name, so that several stores can
+	// share a bucket.
+	Prefix          string `json:"prefix,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	// UsePathStyle addresses the bucket in the URL path rather than the
+	// host name, as most self-hosted services require.
+	UsePathStyle bool `json:"use_path_style,omitempty"`
+}
+
+// S3Backend is a Backend that stores blobs as objects in an S3-compatible
+// bucket.
+type S3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 backend requires a bucket")
+	}
+
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	opts := s3.Options{
+		Region:       region,
+		UsePathStyle: config.UsePathStyle,
+		// Payloads are always seekable temp files, so checksums are only
+		// sent where the service requires them.
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}
+	if config.Endpoint != "" {
+		opts.BaseEndpoint = aws.String(config.Endpoint)
+	}
+	if config.AccessKeyID != "" {
+		opts.Credentials = credentials.NewStaticCredentialsProvider(config.AccessKeyID, config.SecretAccessKey, "")
+	}
+
+	return &S3Backend{client: s3.New(opts), bucket: config.Bucket, prefix: config.Prefix}, nil
+}
+
+func (b *S3Backend) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(b.prefix + name),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put blob %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get returns a reader that fetches the blob lazily and seeks by issuing
+// ranged requests.
+func (b *S3Backend) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	info, err := b.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Reader{ctx: ctx, backend: b, name: name, size: info.Size}, nil
+}
+
+func (b *S3Backend) Stat(ctx context.Context, name string) (*BlobInfo, error) {
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat blob %s: %w", name, s3NotFound(err))
+	}
+	return &BlobInfo{Name: name, Size: aws.ToInt64(out.ContentLength), ModTime: aws.ToTime(out.LastModified)}, nil
+}
+
+// Delete removes a blob. S3 does not report deleting a missing object, so
+// the blob is checked first to keep the Backend contract.
+func (b *S3Backend) Delete(ctx context.Context, name string) error {
+	if _, err := b.Stat(ctx, name); err != nil {
+		return err
+	}
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + name),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", name, err)
+	}
+	return nil
+}
+
+func (b *S3Backend) List(ctx context.Context) ([]string, error) {
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(b.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+		for _, object := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(object.Key), b.prefix))
+		}
+	}
+	return names, nil
+}
+
+// s3NotFound translates the errors S3 returns for missing objects into
+// ErrBlobNotFound.
+func s3NotFound(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return ErrBlobNotFound
+		}
+	}
+	return err
+}
+
+// s3Reader reads a blob from S3, starting a ranged GET from the current
+// offset on the first read after a seek.
+type s3Reader struct {
+	// ctx is the context of the Get the reader was returned by; it bounds
+	// the requests the reader makes.
+	ctx     context.Context
+	backend *S3Backend
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		out, err := r.backend.client.GetObject(r.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(r.backend.bucket),
+			Key:    aws.String(r.backend.prefix + r.name),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to get blob %s: %w", r.name, s3NotFound(err))
+		}
+		r.body = out.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid seek to negative offset %d", offset)
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
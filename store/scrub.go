@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrBlobDamaged is returned when reading content the scrubber found corrupt
+// or missing.
+var ErrBlobDamaged = errors.New("blob damaged")
+
+// scrubBatchSize is the number of blobs the scrubber loads at a time.
+const scrubBatchSize = 100
+
+// ScrubResult summarizes a scrub pass.
+type ScrubResult struct {
+	// Verified is the number of blobs whose content was checked.
+	Verified int
+	// Bytes is the amount of content read.
+	Bytes int64
+	// Corrupt and Missing list the blobs found damaged during the pass.
+	Corrupt []string
+	Missing []string
+}
+
+// ScrubBlobs verifies the blobs that have not been verified within the
+// configured scrub interval, reading their content at no more than the
+// configured scrub rate. A blob whose content no longer matches its hash or
+// size is marked corrupt and its content moved to quarantine; a blob whose
+// content is gone is marked missing. Blobs stored before content addressing
+// have no hash, and blobs encrypted under customer keys cannot be decrypted
+// here, so for those only the size is checked.
+func (s *Store) ScrubBlobs(ctx context.Context) (*ScrubResult, error) {
+	config := s.FileStorage.config
+	cutoff := time.Now().Add(-config.ScrubInterval())
+	limiter := newRateLimiter(config.ScrubRate())
+
+	result := &ScrubResult{}
+	for {
+		blobs, err := s.MetadataStore.ListBlobsToVerify(ctx, cutoff, scrubBatchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(blobs) == 0 {
+			return result, nil
+		}
+
+		for _, blob := range blobs {
+			status, n, err := s.verifyBlob(ctx, blob, limiter)
+			result.Bytes += n
+			if err != nil {
+				return result, err
+			}
+			if err := s.recordVerification(ctx, blob, status); err != nil {
+				return result, err
+			}
+
+			result.Verified++
+			switch status {
+			case BlobCorrupt:
+				result.Corrupt = append(result.Corrupt, blob.ID)
+			case BlobMissing:
+				result.Missing = append(result.Missing, blob.ID)
+			}
+		}
+	}
+}
+
+// verifyBlob reads a blob's content and returns its status and the number of
+// bytes read.
+func (s *Store) verifyBlob(ctx context.Context, blob *Blob, limiter *rateLimiter) (string, int64, error) {
+	if blob.KeyID == CustomerKeyID {
+		info, err := s.Backend.Stat(ctx, blob.ID)
+		if errors.Is(err, ErrBlobNotFound) {
+			return BlobMissing, 0, nil
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to verify blob %s: %w", blob.ID, err)
+		}
+		if info.Size != encryptedSize(blob.EncodedSize) {
+			return BlobCorrupt, 0, nil
+		}
+		return BlobOK, 0, nil
+	}
+
+	rc, err := s.openBlob(ctx, blob.ID, blob.KeyID, blob.Codec, ReadOptions{})
+	if errors.Is(err, ErrBlobNotFound) {
+		return BlobMissing, 0, nil
+	}
+	if errors.Is(err, errDecrypt) || errors.Is(err, errDecode) {
+		return BlobCorrupt, 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to verify blob %s: %w", blob.ID, err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, limiter.reader(rc))
+	if errors.Is(err, errDecrypt) || errors.Is(err, errDecode) {
+		return BlobCorrupt, n, nil
+	}
+	if err != nil {
+		return "", n, fmt.Errorf("failed to verify blob %s: %w", blob.ID, err)
+	}
+
+	if n != blob.Size || (blob.Hash != "" && hashString(h) != blob.Hash) {
+		return BlobCorrupt, n, nil
+	}
+	return BlobOK, n, nil
+}
+
+// recordVerification stores the outcome of verifying blob, quarantining its
+// content if it is corrupt. It holds blobMu so that a blob deleted while it
+// was being read is not reported damaged, and a damaged blob is not reused
+// for new content.
+func (s *Store) recordVerification(ctx context.Context, blob *Blob, status string) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if _, err := s.MetadataStore.GetBlob(ctx, blob.ID); err != nil {
+		if errors.Is(err, ErrMetadataNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := s.MetadataStore.SetBlobVerified(ctx, blob.ID, status, time.Now()); err != nil {
+		return err
+	}
+	if status == BlobCorrupt {
+		return s.quarantineBlob(ctx, blob.ID)
+	}
+	return nil
+}
+
+// quarantineBlob moves a blob's content out of the backend into the
+// quarantine directory.
+func (s *Store) quarantineBlob(ctx context.Context, blobID string) error {
+	rc, err := s.Backend.Get(ctx, blobID)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine blob %s: %w", blobID, err)
+	}
+	err = s.FileStorage.Quarantine(ctx, blobID, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if err := s.Backend.Delete(ctx, blobID); err != nil {
+		return fmt.Errorf("failed to quarantine blob %s: %w", blobID, err)
+	}
+	return nil
+}
+
+// checkBlob explains a failure to read a blob's content, reporting
+// ErrBlobDamaged if the scrubber found the blob damaged.
+func (s *Store) checkBlob(ctx context.Context, blobID string, err error) error {
+	if !errors.Is(err, ErrBlobNotFound) {
+		return err
+	}
+	blob, getErr := s.MetadataStore.GetBlob(ctx, blobID)
+	if getErr != nil || blob.Status == BlobOK {
+		return err
+	}
+	return fmt.Errorf("%w: %s is %s", ErrBlobDamaged, blobID, blob.Status)
+}
+
+// rateLimiter paces reads to a number of bytes per second, averaged since
+// it was created. A zero rate does not limit.
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l.rate <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, limiter: l}
+}
+
+// wait records n bytes read and sleeps until reading them is within the rate.
+func (l *rateLimiter) wait(n int) {
+	l.read += int64(n)
+	due := l.start.Add(time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// Read no more than a tenth of a second's worth at once, so that pacing
+	// is smooth.
+	if chunk := int(r.limiter.rate/10) + 1; len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScrubBlobs(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStoreWithConfig(t, Config{ScrubBytesPerSecond: -1})
+
+	blobOf := func(path string) string {
+		t.Helper()
+		metadata, err := s.StatObject(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return metadata.BlobID
+	}
+	for path, content := range map[string]string{"good.txt": "good", "rotten.txt": "rotten", "lost.txt": "lost"} {
+		if _, err := s.CreateObject(ctx, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotten := blobOf("rotten.txt")
+	if err := os.WriteFile(s.localPath(rotten), []byte("rotted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lost := blobOf("lost.txt")
+	if err := os.Remove(s.localPath(lost)); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.ScrubBlobs(ctx)
+	if err != nil {
+		t.Fatalf("ScrubBlobs failed: %v", err)
+	}
+	if result.Verified != 3 || len(result.Corrupt) != 1 || result.Corrupt[0] != rotten || len(result.Missing) != 1 || result.Missing[0] != lost {
+		t.Errorf("Unexpected scrub result: %+v", result)
+	}
+
+	blob, err := s.MetadataStore.GetBlob(ctx, blobOf("good.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Status != BlobOK || blob.LastVerifiedAt.IsZero() {
+		t.Errorf("Expected good blob to be verified, got %+v", blob)
+	}
+
+	quarantined, err := os.ReadFile(filepath.Join(s.FileStorage.config.StorageDirectory, quarantineDirName, rotten))
+	if err != nil || string(quarantined) != "rotted" {
+		t.Errorf("Expected corrupt content in quarantine, got %q, %v", quarantined, err)
+	}
+	if _, err := s.ReadObject(ctx, "rotten.txt"); !errors.Is(err, ErrBlobDamaged) {
+		t.Errorf("Expected ErrBlobDamaged reading corrupt object, got %v", err)
+	}
+
+	// Writing the original content again does not reuse the damaged blob.
+	if err := s.UpdateObject(ctx, "rotten.txt", []byte("rotten")); err != nil {
+		t.Fatalf("UpdateObject failed: %v", err)
+	}
+	data, err := s.ReadObject(ctx, "rotten.txt")
+	if err != nil || string(data) != "rotten" {
+		t.Errorf("Expected rewritten content, got %q, %v", data, err)
+	}
+
+	damaged, err := s.MetadataStore.ListDamagedBlobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(damaged) != 2 {
+		t.Errorf("Expected 2 damaged blobs, got %d", len(damaged))
+	}
+
+	// Blobs verified within the scrub interval are skipped.
+	result, err = s.ScrubBlobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Verified != 1 {
+		t.Errorf("Expected only the new blob to be verified, got %d", result.Verified)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(10000)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limiter.reader(bytes.NewReader(make([]byte, 2000))))
+	if err != nil || n != 2000 {
+		t.Fatalf("Copy failed: %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected reading 2000 bytes at 10000 B/s to take about 200ms, took %v", elapsed)
+	}
+}
@@ -1,15 +1,46 @@
 package store
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
+var (
+	// ErrObjectNotFound is returned when no object matches an ID or path.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectExists is returned when creating an object at a path that is taken.
+	ErrObjectExists = errors.New("object already exists")
+	// ErrQuotaExceeded is returned when a write would take a bucket over its quota.
+	ErrQuotaExceeded = errors.New("bucket quota exceeded")
+	// ErrPreconditionFailed is returned when a conditional write finds the
+	// object changed since the condition was evaluated.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// DefaultBucket is the bucket object operations act on unless the store has
+// been scoped with InBucket.
+const DefaultBucket = "default"
+
 type Store struct {
-	FileStorage   *FileStorage
+	// FileStorage holds the storage directory where uploads are staged.
+	FileStorage *FileStorage
+	// Backend stores the blobs; by default it is FileStorage itself.
+	Backend       Backend
 	MetadataStore *MetadataStore
+
+	// keys wraps the data keys of encrypted content. It is nil unless
+	// encryption is configured.
+	keys *Keyring
+
+	bucket  string
+	blobMu  *sync.Mutex
+	metrics *storeMetrics
 }
 
 func NewStore(configFile, dbPath string) (*Store, error) {
@@ -18,57 +49,217 @@ func NewStore(configFile, dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("failed to create FileStorage: %w", err)
 	}
 
+	backend, err := newBackend(fs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Backend: %w", err)
+	}
+
+	var keys *Keyring
+	if keyFile := fs.config.Encryption.KeyFile; keyFile != "" {
+		if keys, err = LoadKeyring(keyFile); err != nil {
+			return nil, fmt.Errorf("failed to load keyring: %w", err)
+		}
+	}
+
 	ms, err := NewMetadataStore(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MetadataStore: %w", err)
 	}
+	ms.webhooks = fs.config.Webhooks
 
 	return &Store{
 		FileStorage:   fs,
+		Backend:       backend,
 		MetadataStore: ms,
+		keys:          keys,
+		blobMu:        &sync.Mutex{},
+		metrics:       newStoreMetrics(),
 	}, nil
 }
 
-func (s *Store) CreateObject(objectPath string, data []byte) (string, error) {
-	objectID := generateObjectID(data)
-	localPath := filepath.Join(s.FileStorage.config.StorageDirectory, objectID)
+// Keyring returns the keyring wrapping the data keys of encrypted content, or
+// nil if encryption is not configured.
+func (s *Store) Keyring() *Keyring {
+	return s.keys
+}
+
+// Config returns the configuration the store was opened with.
+func (s *Store) Config() Config {
+	return s.FileStorage.config
+}
+
+// Recover brings storage back to a consistent state after the server stopped
+// abruptly: it clears content staged by interrupted uploads and finishes or
+// undoes the blob writes and deletions that were in progress. It must run
+// before the store serves requests, and never while another process is
+// serving from the same storage.
+func (s *Store) Recover(ctx context.Context) error {
+	if err := s.FileStorage.ClearTemp(); err != nil {
+		return fmt.Errorf("failed to recover: %w", err)
+	}
+	if err := s.recoverIntents(ctx); err != nil {
+		return fmt.Errorf("failed to recover: %w", err)
+	}
+	return nil
+}
+
+// InBucket returns a view of the store whose object operations act on the
+// named bucket. The view shares its storage and database with s.
+func (s *Store) InBucket(name string) *Store {
+	scoped := *s
+	scoped.bucket = name
+	return &scoped
+}
+
+// BucketName returns the name of the bucket object operations act on.
+func (s *Store) BucketName() string {
+	if s.bucket == "" {
+		return DefaultBucket
+	}
+	return s.bucket
+}
+
+// Close releases the resources held by the store.
+func (s *Store) Close() error {
+	return s.MetadataStore.Close()
+}
+
+func (s *Store) CreateObject(ctx context.Context, objectPath string, data []byte) (string, error) {
+	return s.CreateObjectFrom(ctx, objectPath, bytes.NewReader(data))
+}
+
+// WriteOptions are the per-request settings of a write.
+type WriteOptions struct {
+	// Headers are stored with the new version and returned when it is read.
+	Headers Headers
+	// IfVersionID, if set, makes an update of an existing object fail with
+	// ErrPreconditionFailed unless IfVersionID is still its current version.
+	IfVersionID string
+	// CustomerKey, if set, is the AES-256 key the content is encrypted under
+	// instead of a master key. It is not stored; reads must supply it.
+	CustomerKey []byte
+	// Compression is the codec the content is compressed with at rest,
+	// overriding the bucket's default: CodecGzip, CodecZstd, or
+	// CodecIdentity for none. Content the client encoded itself, as
+	// Headers.ContentEncoding says, is never compressed.
+	Compression string
+	// Retention and LegalHold lock a new object; without Retention it gets
+	// the bucket's default retention. Updates keep the lock of the object
+	// they update and ignore both; see SetRetention and SetLegalHold.
+	Retention Retention
+	LegalHold bool
+	// BypassGovernance lets an update replace the content of an object
+	// under governance retention.
+	BypassGovernance bool
+}
+
+// ReadOptions are the per-request settings of a read.
+type ReadOptions struct {
+	// CustomerKey is the key content encrypted under a customer-supplied
+	// key was written with.
+	CustomerKey []byte
+	// AcceptCodecs are the codecs the reader can decompress itself. Content
+	// compressed with one of them is returned still compressed, as an
+	// *EncodedReader.
+	AcceptCodecs []string
+}
+
+// DeleteOptions are the per-request settings of a delete.
+type DeleteOptions struct {
+	// IfVersionID, if set, makes the delete fail with ErrPreconditionFailed
+	// unless IfVersionID is still the object's current version.
+	IfVersionID string
+	// BypassGovernance lets the delete remove an object under governance
+	// retention.
+	BypassGovernance bool
+}
+
+// CreateObjectFrom stores a new object at objectPath, streaming its content
+// from r. The content is hashed while it is written to disk and stored as a
+// blob shared with any other version that has the same content.
+func (s *Store) CreateObjectFrom(ctx context.Context, objectPath string, r io.Reader) (string, error) {
+	return s.CreateObjectWith(ctx, objectPath, r, WriteOptions{})
+}
+
+// CreateObjectWith is CreateObjectFrom with per-request options.
+func (s *Store) CreateObjectWith(ctx context.Context, objectPath string, r io.Reader, opts WriteOptions) (string, error) {
+	timer := s.startOp("create")
+	defer timer.done()
+
+	bucket, err := s.GetBucket(ctx, s.BucketName())
+	if err != nil {
+		return "", err
+	}
 
 	// Check if the object already exists
-	_, err := s.MetadataStore.Get(objectID)
+	_, err = s.MetadataStore.GetByObjectPath(ctx, bucket.Name, objectPath)
 	if err == nil {
-		return "", fmt.Errorf("object with ID %s already exists", objectID)
+		return "", fmt.Errorf("%w: %s", ErrObjectExists, objectPath)
+	}
+
+	if err := checkCustomerKey(opts.CustomerKey); err != nil {
+		return "", err
+	}
+	if err := checkCodec(opts.Compression); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	retention := opts.Retention
+	if retention == (Retention{}) {
+		retention = defaultRetention(bucket, now)
+	}
+	if err := validateRetention(retention, now); err != nil {
+		return "", err
 	}
 
-	err = s.FileStorage.Create(objectID, data)
+	timer.enter(phaseFile)
+	tmp, err := s.FileStorage.WriteTemp(ctx, r)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
 
+	if err := s.checkQuota(ctx, bucket, tmp.Size); err != nil {
+		s.FileStorage.Discard(tmp)
+		return "", err
+	}
+
 	metadata := &Metadata{
-		ObjectID:   objectID,
+		ObjectID:   newID(),
+		Bucket:     bucket.Name,
 		ObjectPath: objectPath,
-		LocalPath:  localPath,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		VersionID:  newID(),
+		Size:       tmp.Size,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Retention:  retention,
+		LegalHold:  opts.LegalHold,
+		Headers:    opts.Headers,
 	}
 
-	err = s.MetadataStore.Create(metadata)
+	err = s.storeBlob(ctx, tmp, writeCodec(bucket, opts), opts.CustomerKey, func(blob *Blob) error {
+		metadata.BlobID = blob.ID
+		metadata.LocalPath = s.localPath(blob.ID)
+		metadata.KeyID = blob.KeyID
+		metadata.Codec = blob.Codec
+		timer.enter(phaseMetadata)
+		return s.MetadataStore.CreateWithVersion(ctx, metadata, currentVersion(metadata), blob)
+	})
 	if err != nil {
-		// If metadata creation fails, rollback file creation
-		s.FileStorage.Delete(objectID)
-		return "", fmt.Errorf("failed to create metadata: %w", err)
+		return "", err
 	}
 
-	return objectID, nil
+	noteObject(ctx, metadata.ObjectID)
+	return metadata.ObjectID, nil
 }
 
-func (s *Store) ReadObject(objectIDOrPath string) ([]byte, error) {
-	metadata, err := s.getMetadata(objectIDOrPath)
+func (s *Store) ReadObject(ctx context.Context, objectIDOrPath string) ([]byte, error) {
+	rc, err := s.OpenObject(ctx, objectIDOrPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata: %w", err)
+		return nil, err
 	}
+	defer rc.Close()
 
-	data, err := s.FileStorage.Read(metadata.ObjectID)
+	data, err := io.ReadAll(rc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -76,61 +267,243 @@ func (s *Store) ReadObject(objectIDOrPath string) ([]byte, error) {
 	return data, nil
 }
 
-func (s *Store) UpdateObject(objectIDOrPath string, data []byte) error {
-	metadata, err := s.getMetadata(objectIDOrPath)
+// OpenObject returns a reader for the content of an object. The caller must
+// close it.
+func (s *Store) OpenObject(ctx context.Context, objectIDOrPath string) (io.ReadCloser, error) {
+	rc, _, err := s.GetObject(ctx, objectIDOrPath)
+	return rc, err
+}
+
+// GetObject returns a reader for the content of an object together with the
+// metadata describing that content. The caller must close the reader.
+func (s *Store) GetObject(ctx context.Context, objectIDOrPath string) (io.ReadCloser, *Metadata, error) {
+	return s.GetObjectWith(ctx, objectIDOrPath, ReadOptions{})
+}
+
+// GetObjectWith is GetObject with per-request options.
+func (s *Store) GetObjectWith(ctx context.Context, objectIDOrPath string, opts ReadOptions) (io.ReadCloser, *Metadata, error) {
+	timer := s.startOp("get")
+	defer timer.done()
+
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	timer.enter(phaseFile)
+	rc, err := s.openBlob(ctx, metadata.BlobID, metadata.KeyID, metadata.Codec, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rc, metadata, nil
+}
+
+func (s *Store) UpdateObject(ctx context.Context, objectIDOrPath string, data []byte) error {
+	return s.UpdateObjectFrom(ctx, objectIDOrPath, bytes.NewReader(data))
+}
+
+// UpdateObjectFrom replaces the content of an object, streaming the new
+// content from r. The previous content is kept as an older version.
+func (s *Store) UpdateObjectFrom(ctx context.Context, objectIDOrPath string, r io.Reader) error {
+	return s.UpdateObjectWith(ctx, objectIDOrPath, r, WriteOptions{})
+}
+
+// UpdateObjectWith is UpdateObjectFrom with per-request options. The new
+// version's headers replace those of the previous one. It fails with
+// ErrObjectLocked if the object is under legal hold or retention.
+func (s *Store) UpdateObjectWith(ctx context.Context, objectIDOrPath string, r io.Reader, opts WriteOptions) error {
+	timer := s.startOp("update")
+	defer timer.done()
+
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
 	if err != nil {
 		return fmt.Errorf("failed to get metadata: %w", err)
 	}
 
-	err = s.FileStorage.Update(metadata.ObjectID, data)
+	if opts.IfVersionID != "" && opts.IfVersionID != metadata.VersionID {
+		return fmt.Errorf("%w: version %s is no longer current", ErrPreconditionFailed, opts.IfVersionID)
+	}
+	if err := checkLock(metadata, time.Now(), opts.BypassGovernance); err != nil {
+		return err
+	}
+
+	bucket, err := s.GetBucket(ctx, metadata.Bucket)
+	if err != nil {
+		return err
+	}
+
+	if err := checkCustomerKey(opts.CustomerKey); err != nil {
+		return err
+	}
+	if err := checkCodec(opts.Compression); err != nil {
+		return err
+	}
+
+	timer.enter(phaseFile)
+	tmp, err := s.FileStorage.WriteTemp(ctx, r)
 	if err != nil {
 		return fmt.Errorf("failed to update file: %w", err)
 	}
 
+	if err := s.checkQuota(ctx, bucket, tmp.Size-metadata.Size); err != nil {
+		s.FileStorage.Discard(tmp)
+		return err
+	}
+
+	previous := currentVersion(metadata)
+	metadata.VersionID = newID()
+	metadata.Size = tmp.Size
 	metadata.UpdatedAt = time.Now()
-	err = s.MetadataStore.Update(metadata)
+	metadata.Headers = opts.Headers
+	err = s.storeBlob(ctx, tmp, writeCodec(bucket, opts), opts.CustomerKey, func(blob *Blob) error {
+		metadata.BlobID = blob.ID
+		metadata.LocalPath = s.localPath(blob.ID)
+		metadata.KeyID = blob.KeyID
+		metadata.Codec = blob.Codec
+		timer.enter(phaseMetadata)
+		return s.MetadataStore.UpdateWithVersion(ctx, metadata, currentVersion(metadata), blob, opts.IfVersionID, opts.BypassGovernance)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update metadata: %w", err)
+		return err
 	}
 
+	if !bucket.Versioning {
+		timer.enter(phaseFile)
+		return s.discardVersion(ctx, previous)
+	}
 	return nil
 }
 
-func (s *Store) DeleteObject(objectIDOrPath string) error {
-	metadata, err := s.getMetadata(objectIDOrPath)
+// PutObjectFrom stores content streamed from r at objectPath, creating the
+// object or replacing the content of an existing one. It returns the object ID.
+func (s *Store) PutObjectFrom(ctx context.Context, objectPath string, r io.Reader) (string, error) {
+	return s.PutObjectWith(ctx, objectPath, r, WriteOptions{})
+}
+
+// PutObjectWith is PutObjectFrom with per-request options.
+func (s *Store) PutObjectWith(ctx context.Context, objectPath string, r io.Reader, opts WriteOptions) (string, error) {
+	metadata, err := s.MetadataStore.GetByObjectPath(ctx, s.BucketName(), objectPath)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return s.CreateObjectWith(ctx, objectPath, r, opts)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	if err := s.UpdateObjectWith(ctx, metadata.ObjectID, r, opts); err != nil {
+		return "", err
+	}
+	return metadata.ObjectID, nil
+}
+
+// StatObject returns the metadata of an object without opening its content.
+func (s *Store) StatObject(ctx context.Context, objectIDOrPath string) (*Metadata, error) {
+	return s.getMetadata(ctx, objectIDOrPath)
+}
+
+// ListObjects returns a page of the bucket's objects ordered by path.
+func (s *Store) ListObjects(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	opts.Bucket = s.BucketName()
+	return s.MetadataStore.List(ctx, opts)
+}
+
+// DeleteObject removes an object together with all of its versions. It fails
+// with ErrObjectLocked if the object is under legal hold or retention.
+func (s *Store) DeleteObject(ctx context.Context, objectIDOrPath string) error {
+	return s.DeleteObjectWith(ctx, objectIDOrPath, DeleteOptions{})
+}
+
+// DeleteObjectWith is DeleteObject with per-request options.
+func (s *Store) DeleteObjectWith(ctx context.Context, objectIDOrPath string, opts DeleteOptions) error {
+	timer := s.startOp("delete")
+	defer timer.done()
+
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
 	if err != nil {
 		return fmt.Errorf("failed to get metadata: %w", err)
 	}
 
-	err = s.FileStorage.Delete(metadata.ObjectID)
+	if opts.IfVersionID != "" && opts.IfVersionID != metadata.VersionID {
+		return fmt.Errorf("%w: version %s is no longer current", ErrPreconditionFailed, opts.IfVersionID)
+	}
+	if err := checkLock(metadata, time.Now(), opts.BypassGovernance); err != nil {
+		return err
+	}
+
+	versions, err := s.MetadataStore.ListVersions(ctx, metadata.Bucket, metadata.ObjectPath)
 	if err != nil {
-		return fmt.Errorf("failed to delete file: %w", err)
+		return fmt.Errorf("failed to list versions: %w", err)
 	}
 
-	err = s.MetadataStore.Delete(metadata.ObjectID)
+	err = s.MetadataStore.DeleteIfVersion(ctx, metadata.ObjectID, opts.IfVersionID, opts.BypassGovernance)
 	if err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
-	return nil
+	blobIDs := make([]string, 0, len(versions))
+	for _, version := range versions {
+		blobIDs = append(blobIDs, version.BlobID)
+	}
+	timer.enter(phaseFile)
+	return s.releaseBlobs(ctx, blobIDs...)
 }
 
-func (s *Store) getMetadata(objectIDOrPath string) (*Metadata, error) {
-	metadata, err := s.MetadataStore.Get(objectIDOrPath)
-	if err == nil {
+func (s *Store) getMetadata(ctx context.Context, objectIDOrPath string) (*Metadata, error) {
+	metadata, err := s.MetadataStore.Get(ctx, objectIDOrPath)
+	if err == nil && metadata.Bucket == s.BucketName() {
+		noteObject(ctx, metadata.ObjectID)
 		return metadata, nil
 	}
 
 	// If not found by ObjectID, try by ObjectPath
-	metadata, err = s.MetadataStore.GetByObjectPath(objectIDOrPath)
+	metadata, err = s.MetadataStore.GetByObjectPath(ctx, s.BucketName(), objectIDOrPath)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectIDOrPath)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("object not found: %s", objectIDOrPath)
+		return nil, err
 	}
 
+	noteObject(ctx, metadata.ObjectID)
 	return metadata, nil
 }
 
-func generateObjectID(data []byte) string {
-	hash := sha256.Sum256(data)
-	return fmt.Sprintf("%x", hash)
+// checkQuota reports whether growing the bucket by delta bytes keeps it
+// within its quota. It saves storing content that cannot fit; the metadata
+// store enforces the quota when it records the write.
+func (s *Store) checkQuota(ctx context.Context, bucket *Bucket, delta int64) error {
+	if bucket.QuotaBytes == 0 || delta <= 0 {
+		return nil
+	}
+
+	usage, err := s.MetadataStore.BucketUsage(ctx, bucket.Name)
+	if err != nil {
+		return err
+	}
+	if usage+delta > bucket.QuotaBytes {
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, bucket.Name)
+	}
+	return nil
+}
+
+// discardVersion removes a version that is no longer kept, releasing its
+// blob.
+func (s *Store) discardVersion(ctx context.Context, version *Version) error {
+	if err := s.MetadataStore.DeleteVersion(ctx, version); err != nil {
+		return err
+	}
+	return s.releaseBlobs(ctx, version.BlobID)
+}
+
+// checkCustomerKey reports whether key, if set, is an AES-256 key.
+func checkCustomerKey(key []byte) error {
+	if key != nil && len(key) != KeySize {
+		return fmt.Errorf("%w: customer keys must be %d bytes", ErrInvalidKey, KeySize)
+	}
+	return nil
+}
+
+func (s *Store) localPath(name string) string {
+	return filepath.Join(s.FileStorage.config.StorageDirectory, name)
 }
@@ -2,7 +2,12 @@ package store
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -40,20 +45,25 @@ func TestStore(t *testing.T) {
 	t.Run("CreateReadUpdateDeleteByObjectPath", func(t *testing.T) {
 		testStoreOperations(t, s, "object-path", "documents/report1.docx")
 	})
+
+	t.Run("StreamingCreateAndOpen", func(t *testing.T) {
+		testStoreStreaming(t, s)
+	})
 }
 
 func testStoreOperations(t *testing.T, s *Store, testCase, objectPath string) {
+	ctx := t.Context()
 	data := []byte("Hello, world!")
 	updatedData := []byte("Hello, updated world!")
 
 	// Test CreateObject
-	objectID, err := s.CreateObject(objectPath, data)
+	objectID, err := s.CreateObject(ctx, objectPath, data)
 	if err != nil {
 		t.Fatalf("%s: Failed to create object: %v", testCase, err)
 	}
 
 	// Test ReadObject by ObjectID
-	readData, err := s.ReadObject(objectID)
+	readData, err := s.ReadObject(ctx, objectID)
 	if err != nil {
 		t.Fatalf("%s: Failed to read object by ObjectID: %v", testCase, err)
 	}
@@ -62,7 +72,7 @@ func testStoreOperations(t *testing.T, s *Store, testCase, objectPath string) {
 	}
 
 	// Test ReadObject by ObjectPath
-	readData, err = s.ReadObject(objectPath)
+	readData, err = s.ReadObject(ctx, objectPath)
 	if err != nil {
 		t.Fatalf("%s: Failed to read object by ObjectPath: %v", testCase, err)
 	}
@@ -71,12 +81,12 @@ func testStoreOperations(t *testing.T, s *Store, testCase, objectPath string) {
 	}
 
 	// Test UpdateObject
-	err = s.UpdateObject(objectID, updatedData)
+	err = s.UpdateObject(ctx, objectID, updatedData)
 	if err != nil {
 		t.Fatalf("%s: Failed to update object by ObjectID: %v", testCase, err)
 	}
 
-	readData, err = s.ReadObject(objectPath)
+	readData, err = s.ReadObject(ctx, objectPath)
 	if err != nil {
 		t.Fatalf("%s: Failed to read updated object: %v", testCase, err)
 	}
@@ -85,18 +95,144 @@ func testStoreOperations(t *testing.T, s *Store, testCase, objectPath string) {
 	}
 
 	// Test DeleteObject
-	err = s.DeleteObject(objectPath)
+	err = s.DeleteObject(ctx, objectPath)
 	if err != nil {
 		t.Fatalf("%s: Failed to delete object: %v", testCase, err)
 	}
 
-	_, err = s.ReadObject(objectID)
+	_, err = s.ReadObject(ctx, objectID)
 	if err == nil {
 		t.Errorf("%s: Object still exists after deletion", testCase)
 	}
 
-	_, err = s.ReadObject(objectPath)
+	_, err = s.ReadObject(ctx, objectPath)
 	if err == nil {
 		t.Errorf("%s: Object still exists after deletion", testCase)
 	}
 }
+
+func testStoreStreaming(t *testing.T, s *Store) {
+	ctx := t.Context()
+	data := bytes.Repeat([]byte("streamed content "), 100000)
+	sum := sha256.Sum256(data)
+
+	objectID, err := s.CreateObjectFrom(ctx, "artifacts/build.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to create object from reader: %v", err)
+	}
+	metadata, err := s.StatObject(ctx, objectID)
+	if err != nil {
+		t.Fatalf("Failed to stat object: %v", err)
+	}
+	if metadata.BlobID != hex.EncodeToString(sum[:]) {
+		t.Errorf("Blob ID %s is not the SHA-256 of the content", metadata.BlobID)
+	}
+
+	rc, err := s.OpenObject(ctx, "artifacts/build.bin")
+	if err != nil {
+		t.Fatalf("Failed to open object: %v", err)
+	}
+	readData, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("Failed to read opened object: %v", err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Error("Streamed data doesn't match")
+	}
+
+	if err := s.DeleteObject(ctx, objectID); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	if _, err := s.CreateObject(ctx, "lock.json", []byte("v1")); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+	stale, err := s.StatObject(ctx, "lock.json")
+	if err != nil {
+		t.Fatalf("Failed to stat object: %v", err)
+	}
+	if err := s.UpdateObjectWith(ctx, "lock.json", bytes.NewReader([]byte("v2")), WriteOptions{IfVersionID: stale.VersionID}); err != nil {
+		t.Fatalf("Update conditional on the current version failed: %v", err)
+	}
+
+	err = s.UpdateObjectWith(ctx, "lock.json", bytes.NewReader([]byte("v3")), WriteOptions{IfVersionID: stale.VersionID})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale update, got %v", err)
+	}
+	err = s.DeleteObjectWith(ctx, "lock.json", DeleteOptions{IfVersionID: stale.VersionID})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale delete, got %v", err)
+	}
+
+	// The metadata store enforces the condition itself, so a write racing
+	// with the check above cannot slip through.
+	current, err := s.StatObject(ctx, "lock.json")
+	if err != nil {
+		t.Fatalf("Failed to stat object: %v", err)
+	}
+	if err := s.MetadataStore.DeleteIfVersion(ctx, current.ObjectID, stale.VersionID, false); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed from the metadata store, got %v", err)
+	}
+	data, err := s.ReadObject(ctx, "lock.json")
+	if err != nil || string(data) != "v2" {
+		t.Errorf("Expected object to keep content %q, got %q (%v)", "v2", data, err)
+	}
+	versions, err := s.ListVersions(ctx, "lock.json")
+	if err != nil || len(versions) != 2 {
+		t.Errorf("Expected 2 versions to survive failed writes, got %d (%v)", len(versions), err)
+	}
+
+	if err := s.DeleteObjectWith(ctx, "lock.json", DeleteOptions{IfVersionID: current.VersionID}); err != nil {
+		t.Errorf("Delete conditional on the current version failed: %v", err)
+	}
+}
+
+func TestConcurrentDeleteAndUpdate(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	const n = 100
+	for i := range n {
+		if _, err := s.CreateObject(ctx, fmt.Sprintf("%d.txt", i), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each object is deleted while it is updated; whichever comes second
+	// may find it gone, but neither may fail otherwise.
+	errs := make(chan error, 2*n)
+	for i := range n {
+		objectPath := fmt.Sprintf("%d.txt", i)
+		go func() {
+			errs <- s.DeleteObject(ctx, objectPath)
+		}()
+		go func() {
+			errs <- s.UpdateObject(ctx, objectPath, []byte(fmt.Sprintf("v2 of %d", i)))
+		}()
+	}
+	for range 2 * n {
+		if err := <-errs; err != nil && !errors.Is(err, ErrObjectNotFound) {
+			t.Error(err)
+		}
+	}
+
+	// Deleted objects leave no versions behind.
+	for i := range n {
+		objectPath := fmt.Sprintf("%d.txt", i)
+		if _, err := s.StatObject(ctx, objectPath); !errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		versions, err := s.MetadataStore.ListVersions(ctx, DefaultBucket, objectPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 0 {
+			t.Errorf("Expected no versions of deleted %s, got %d", objectPath, len(versions))
+		}
+	}
+}
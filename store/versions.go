@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrVersionNotFound is returned when an object has no version with the
+// requested ID.
+var ErrVersionNotFound = errors.New("version not found")
+
+// newID returns a random identifier for objects, versions and blobs that
+// cannot be named by their content.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// currentVersion returns the version record for the content metadata
+// currently points at.
+func currentVersion(metadata *Metadata) *Version {
+	return &Version{
+		Bucket:     metadata.Bucket,
+		ObjectPath: metadata.ObjectPath,
+		VersionID:  metadata.VersionID,
+		BlobID:     metadata.BlobID,
+		LocalPath:  metadata.LocalPath,
+		Size:       metadata.Size,
+		KeyID:      metadata.KeyID,
+		Codec:      metadata.Codec,
+		CreatedAt:  metadata.UpdatedAt,
+		Headers:    metadata.Headers,
+	}
+}
+
+// ListVersions returns every version of an object, newest first.
+func (s *Store) ListVersions(ctx context.Context, objectIDOrPath string) ([]*Version, error) {
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	return s.MetadataStore.ListVersions(ctx, metadata.Bucket, metadata.ObjectPath)
+}
+
+// StatObjectVersion returns a specific version of an object without opening
+// its content.
+func (s *Store) StatObjectVersion(ctx context.Context, objectIDOrPath, versionID string) (*Version, error) {
+	return s.getVersion(ctx, objectIDOrPath, versionID)
+}
+
+// OpenObjectVersion returns a reader for the content of a specific version of
+// an object. The caller must close it.
+func (s *Store) OpenObjectVersion(ctx context.Context, objectIDOrPath, versionID string) (io.ReadCloser, error) {
+	rc, _, err := s.GetObjectVersion(ctx, objectIDOrPath, versionID)
+	return rc, err
+}
+
+// GetObjectVersion returns a reader for the content of a specific version of
+// an object together with the version record. The caller must close the
+// reader.
+func (s *Store) GetObjectVersion(ctx context.Context, objectIDOrPath, versionID string) (io.ReadCloser, *Version, error) {
+	return s.GetObjectVersionWith(ctx, objectIDOrPath, versionID, ReadOptions{})
+}
+
+// GetObjectVersionWith is GetObjectVersion with per-request options.
+func (s *Store) GetObjectVersionWith(ctx context.Context, objectIDOrPath, versionID string, opts ReadOptions) (io.ReadCloser, *Version, error) {
+	version, err := s.getVersion(ctx, objectIDOrPath, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rc, err := s.openBlob(ctx, version.BlobID, version.KeyID, version.Codec, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc, version, nil
+}
+
+// RestoreVersion makes the content of an older version current again by
+// recording it as a new version. It returns the new version. Like an
+// update, it fails with ErrObjectLocked if the object is locked.
+func (s *Store) RestoreVersion(ctx context.Context, objectIDOrPath, versionID string) (*Version, error) {
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	if err := checkLock(metadata, time.Now(), false); err != nil {
+		return nil, err
+	}
+
+	bucket, err := s.GetBucket(ctx, metadata.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := s.getVersion(ctx, metadata.ObjectID, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The restored version references the blob of the version it restores;
+	// blobs are immutable so the content never needs to be copied.
+	previous := currentVersion(metadata)
+	metadata.VersionID = newID()
+	metadata.BlobID = version.BlobID
+	metadata.LocalPath = version.LocalPath
+	metadata.Size = version.Size
+	metadata.KeyID = version.KeyID
+	metadata.Codec = version.Codec
+	metadata.UpdatedAt = time.Now()
+	metadata.Headers = version.Headers
+
+	restored := currentVersion(metadata)
+	if err := s.MetadataStore.UpdateWithVersion(ctx, metadata, restored, nil, "", false); err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	if !bucket.Versioning {
+		if err := s.discardVersion(ctx, previous); err != nil {
+			return nil, err
+		}
+	}
+	return restored, nil
+}
+
+func (s *Store) getVersion(ctx context.Context, objectIDOrPath, versionID string) (*Version, error) {
+	metadata, err := s.getMetadata(ctx, objectIDOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	version, err := s.MetadataStore.GetVersion(ctx, metadata.Bucket, metadata.ObjectPath, versionID)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, versionID)
+	}
+	return version, err
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+
+	objectPath := "configs/app.yaml"
+	if _, err := s.CreateObject(ctx, objectPath, []byte("v1")); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+	if err := s.UpdateObject(ctx, objectPath, []byte("v2")); err != nil {
+		t.Fatalf("Failed to update object: %v", err)
+	}
+	if err := s.UpdateObject(ctx, objectPath, []byte("v3")); err != nil {
+		t.Fatalf("Failed to update object: %v", err)
+	}
+
+	versions, err := s.ListVersions(ctx, objectPath)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Expected 3 versions, got %d", len(versions))
+	}
+
+	first := versions[2]
+	rc, err := s.OpenObjectVersion(ctx, objectPath, first.VersionID)
+	if err != nil {
+		t.Fatalf("Failed to open first version: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "v1" {
+		t.Errorf("Expected first version content %q, got %q", "v1", data)
+	}
+
+	restored, err := s.RestoreVersion(ctx, objectPath, first.VersionID)
+	if err != nil {
+		t.Fatalf("Failed to restore version: %v", err)
+	}
+	current, err := s.ReadObject(ctx, objectPath)
+	if err != nil {
+		t.Fatalf("Failed to read restored object: %v", err)
+	}
+	if string(current) != "v1" {
+		t.Errorf("Expected restored content %q, got %q", "v1", current)
+	}
+
+	versions, err = s.ListVersions(ctx, objectPath)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 4 || versions[0].VersionID != restored.VersionID {
+		t.Errorf("Expected restored version to be the newest of 4, got %+v", versions)
+	}
+
+	if _, err := s.OpenObjectVersion(ctx, objectPath, "missing"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+
+	if err := s.DeleteObject(ctx, objectPath); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	names, err := s.FileStorage.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("Expected all version files to be deleted, found %v", names)
+	}
+}
+
+func TestUpdateDeletedObject(t *testing.T) {
+	ctx := t.Context()
+	s := newTestStore(t)
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("shared")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "b.txt", []byte("shared")); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := s.StatObject(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update racing with the delete must not leave a version behind.
+	metadata.VersionID = newID()
+	err = s.MetadataStore.UpdateWithVersion(ctx, metadata, currentVersion(metadata), nil, "", false)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	versions, err := s.MetadataStore.ListVersions(ctx, metadata.Bucket, metadata.ObjectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected no versions left behind, got %+v", versions)
+	}
+	blob, err := s.MetadataStore.GetBlob(ctx, metadata.BlobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.RefCount != 1 {
+		t.Errorf("Expected the blob to keep a single reference, got %d", blob.RefCount)
+	}
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDeliveryNotFound is returned when no dead-lettered delivery has an ID.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// Event types.
+const (
+	EventObjectCreated = "ObjectCreated"
+	EventObjectUpdated = "ObjectUpdated"
+	EventObjectDeleted = "ObjectDeleted"
+	// EventObjectLockChanged is the change of an object's retention or
+	// legal hold.
+	EventObjectLockChanged = "ObjectLockChanged"
+	// EventObjectVersionDeleted is the deletion of a single version of an
+	// object, such as a noncurrent version expired by a lifecycle rule.
+	EventObjectVersionDeleted = "ObjectVersionDeleted"
+)
+
+// Event describes a change to an object. It is the JSON payload webhooks
+// receive and the entries of the change feed.
+type Event struct {
+	// Seq is the sequence number of the change; see ListChanges.
+	Seq int64 `json:"seq"`
+	// Type is EventObjectCreated, EventObjectUpdated, EventObjectDeleted,
+	// EventObjectLockChanged or EventObjectVersionDeleted.
+	Type       string `json:"type"`
+	Bucket     string `json:"bucket"`
+	ObjectPath string `json:"path"`
+	ObjectID   string `json:"object_id"`
+	// VersionID is the version the change made current; for
+	// EventObjectDeleted the version that was current, and for
+	// EventObjectVersionDeleted the version deleted.
+	VersionID string    `json:"version_id"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Time is when the change happened.
+	Time time.Time `json:"time"`
+}
+
+func newEvent(kind string, metadata *Metadata) *Event {
+	return &Event{
+		Type:       kind,
+		Bucket:     metadata.Bucket,
+		ObjectPath: metadata.ObjectPath,
+		ObjectID:   metadata.ObjectID,
+		VersionID:  metadata.VersionID,
+		Size:       metadata.Size,
+		CreatedAt:  metadata.CreatedAt,
+		UpdatedAt:  metadata.UpdatedAt,
+		Time:       time.Now(),
+	}
+}
+
+// Webhook delivery headers. The signature is the hex-encoded HMAC-SHA256,
+// keyed with the webhook's secret, of the timestamp, a period and the
+// payload; see SignWebhook.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// DefaultWebhookMaxAttempts is how many times a delivery is attempted
+// before it is dead-lettered, unless configured otherwise.
+const DefaultWebhookMaxAttempts = 8
+
+// Webhook retries back off exponentially from webhookInitialBackoff up to
+// webhookMaxBackoff.
+const (
+	webhookInitialBackoff = 10 * time.Second
+	webhookMaxBackoff     = time.Hour
+)
+
+// webhookBatchSize is the number of due deliveries loaded at a time.
+const webhookBatchSize = 100
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookConfig configures an endpoint that events are delivered to.
+type WebhookConfig struct {
+	// ID names the webhook in its deliveries. Changing it drops the
+	// deliveries still pending.
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs the payloads. Payloads are not signed if it is empty.
+	Secret string `json:"secret,omitempty"`
+	// Events are the event types delivered; empty means all of them.
+	Events []string `json:"events,omitempty"`
+	// Bucket, Prefix and Suffix limit the webhook to objects in a bucket
+	// and with paths starting and ending with them. Empty matches
+	// everything.
+	Bucket string `json:"bucket,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+	// MaxAttempts is how many times a delivery is attempted before it is
+	// dead-lettered. Zero means DefaultWebhookMaxAttempts.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// Matches reports whether the webhook receives event.
+func (c WebhookConfig) Matches(event *Event) bool {
+	return (len(c.Events) == 0 || slices.Contains(c.Events, event.Type)) &&
+		(c.Bucket == "" || c.Bucket == event.Bucket) &&
+		strings.HasPrefix(event.ObjectPath, c.Prefix) &&
+		strings.HasSuffix(event.ObjectPath, c.Suffix)
+}
+
+func (c WebhookConfig) maxAttempts() int {
+	if c.MaxAttempts <= 0 {
+		return DefaultWebhookMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// Delivery is an event queued for delivery to a webhook.
+type Delivery struct {
+	ID        int64
+	WebhookID string
+	Event     Event
+	// payload is the event as it is delivered.
+	payload []byte
+	// Attempts is the number of failed attempts so far, and LastError the
+	// reason the last one failed.
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	// Dead is set once the delivery ran out of attempts.
+	Dead      bool
+	CreatedAt time.Time
+}
+
+// WebhookResult summarizes a delivery pass.
+type WebhookResult struct {
+	Delivered int
+	// Failed counts the deliveries that failed and will be retried, and
+	// Dead those that failed for the last time.
+	Failed int
+	Dead   int
+}
+
+// SignWebhook returns the signature of a webhook payload sent at timestamp,
+// in the format of WebhookTimestampHeader, under secret.
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns how long to wait before attempting a delivery
+// again after it failed attempts times.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookInitialBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+// DeliverWebhooks attempts the deliveries that are due. A delivery that
+// fails is retried with exponential backoff until it runs out of attempts,
+// when it is dead-lettered. Deliveries to webhooks that are no longer
+// configured are dead-lettered without an attempt.
+func (s *Store) DeliverWebhooks(ctx context.Context) (*WebhookResult, error) {
+	webhooks := make(map[string]WebhookConfig)
+	for _, webhook := range s.Config().Webhooks {
+		webhooks[webhook.ID] = webhook
+	}
+
+	result := &WebhookResult{}
+	now := time.Now()
+	for {
+		deliveries, err := s.MetadataStore.ListDueDeliveries(ctx, now, webhookBatchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(deliveries) == 0 {
+			return result, nil
+		}
+
+		for _, delivery := range deliveries {
+			webhook, ok := webhooks[delivery.WebhookID]
+			if ok {
+				err = deliver(ctx, webhook, delivery)
+			} else {
+				err = errors.New("webhook is no longer configured")
+			}
+			if err == nil {
+				result.Delivered++
+				if err := s.MetadataStore.DeleteDelivery(ctx, delivery.ID); err != nil {
+					return result, err
+				}
+				continue
+			}
+
+			delivery.Attempts++
+			delivery.LastError = err.Error()
+			delivery.NextAttemptAt = time.Now().Add(webhookBackoff(delivery.Attempts))
+			delivery.Dead = !ok || delivery.Attempts >= webhook.maxAttempts()
+			if delivery.Dead {
+				result.Dead++
+			} else {
+				result.Failed++
+			}
+			if err := s.MetadataStore.UpdateDelivery(ctx, delivery); err != nil {
+				return result, err
+			}
+		}
+	}
+}
+
+// deliver posts a delivery's event to a webhook. Any response other than
+// 2xx is a failure.
+func deliver(ctx context.Context, webhook WebhookConfig, delivery *Delivery) error {
+	payload := delivery.payload
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if webhook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, payload))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// ListDeadDeliveries returns the dead-lettered deliveries, oldest first.
+func (s *Store) ListDeadDeliveries(ctx context.Context) ([]*Delivery, error) {
+	return s.MetadataStore.ListDeadDeliveries(ctx)
+}
+
+// RetryDelivery queues a dead-lettered delivery again, with a fresh set of
+// attempts.
+func (s *Store) RetryDelivery(ctx context.Context, id int64) error {
+	err := s.MetadataStore.RetryDelivery(ctx, id)
+	if errors.Is(err, ErrMetadataNotFound) {
+		return fmt.Errorf("%w: %d", ErrDeliveryNotFound, id)
+	}
+	return err
+}
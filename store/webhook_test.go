@@ -0,0 +1,186 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the events posted to it, checking their
+// signatures, and fails requests while failing is set.
+type webhookReceiver struct {
+	t       *testing.T
+	secret  string
+	mu      sync.Mutex
+	events  []Event
+	failing bool
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, _ := io.ReadAll(r.Body)
+	if got, want := r.Header.Get(WebhookSignatureHeader), SignWebhook(rcv.secret, r.Header.Get(WebhookTimestampHeader), payload); got != want {
+		rcv.t.Errorf("Expected signature %s, got %s", want, got)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if rcv.failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		rcv.t.Errorf("Invalid payload %s: %v", payload, err)
+	}
+	if r.Header.Get(WebhookEventHeader) != event.Type {
+		rcv.t.Errorf("Expected event header %s, got %s", event.Type, r.Header.Get(WebhookEventHeader))
+	}
+	rcv.events = append(rcv.events, event)
+}
+
+func (rcv *webhookReceiver) setFailing(failing bool) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.failing = failing
+}
+
+// makeDeliveriesDue makes every pending delivery due now, as if its backoff
+// had passed.
+func makeDeliveriesDue(t *testing.T, s *Store) {
+	t.Helper()
+	if _, err := s.MetadataStore.db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := t.Context()
+	receiver := &webhookReceiver{t: t, secret: "hook secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	s := newTestStoreWithConfig(t, Config{Webhooks: []WebhookConfig{
+		{ID: "artifacts", URL: server.URL, Secret: "hook secret", Prefix: "builds/", Suffix: ".bin"},
+	}})
+
+	objectID, err := s.CreateObject(ctx, "builds/app.bin", []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "builds/notes.txt", []byte("filtered by suffix")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateObject(ctx, "docs/app.bin", []byte("filtered by prefix")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateObject(ctx, objectID, []byte("version two")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, objectID); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.DeliverWebhooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Delivered != 3 || result.Failed != 0 || result.Dead != 0 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	wantTypes := []string{EventObjectCreated, EventObjectUpdated, EventObjectDeleted}
+	if len(receiver.events) != len(wantTypes) {
+		t.Fatalf("Expected %d events, got %+v", len(wantTypes), receiver.events)
+	}
+	for i, event := range receiver.events {
+		if event.Type != wantTypes[i] || event.ObjectID != objectID || event.ObjectPath != "builds/app.bin" || event.Bucket != DefaultBucket {
+			t.Errorf("Unexpected event %d: %+v", i, event)
+		}
+	}
+	if updated := receiver.events[1]; updated.Size != int64(len("version two")) || updated.UpdatedAt.Before(updated.CreatedAt) {
+		t.Errorf("Unexpected update event %+v", updated)
+	}
+
+	// Delivered events are not delivered again.
+	if result, _ := s.DeliverWebhooks(ctx); result.Delivered != 0 {
+		t.Errorf("Expected nothing left to deliver, got %+v", result)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	ctx := t.Context()
+	receiver := &webhookReceiver{t: t, secret: "hook secret", failing: true}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	s := newTestStoreWithConfig(t, Config{Webhooks: []WebhookConfig{
+		{ID: "flaky", URL: server.URL, Secret: "hook secret", Events: []string{EventObjectCreated}, MaxAttempts: 2},
+	}})
+	if _, err := s.CreateObject(ctx, "a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.DeliverWebhooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed != 1 {
+		t.Errorf("Expected one failed delivery, got %+v", result)
+	}
+	// The retry waits for its backoff.
+	if result, _ := s.DeliverWebhooks(ctx); result.Failed != 0 || result.Dead != 0 {
+		t.Errorf("Expected the retry to wait, got %+v", result)
+	}
+
+	makeDeliveriesDue(t, s)
+	if result, _ := s.DeliverWebhooks(ctx); result.Dead != 1 {
+		t.Errorf("Expected the delivery to be dead-lettered, got %+v", result)
+	}
+	dead, err := s.ListDeadDeliveries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].WebhookID != "flaky" || dead[0].Attempts != 2 || dead[0].Event.ObjectPath != "a.txt" || dead[0].LastError == "" {
+		t.Fatalf("Unexpected dead letters %+v", dead)
+	}
+	makeDeliveriesDue(t, s)
+	if result, _ := s.DeliverWebhooks(ctx); result.Failed != 0 || result.Dead != 0 {
+		t.Errorf("Expected dead letters not to be attempted, got %+v", result)
+	}
+
+	receiver.setFailing(false)
+	if err := s.RetryDelivery(ctx, dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := s.DeliverWebhooks(ctx); result.Delivered != 1 {
+		t.Errorf("Expected the retried delivery to succeed, got %+v", result)
+	}
+	if len(receiver.events) != 1 {
+		t.Errorf("Expected one event, got %+v", receiver.events)
+	}
+	if err := s.RetryDelivery(ctx, dead[0].ID); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Expected ErrDeliveryNotFound, got %v", err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}